@@ -6,6 +6,9 @@ VERSION=$(cat VERSION)
 
 mkdir -p out
 
+# 生成静态文件的预压缩版本，随 Web 界面一起打包进二进制
+go generate ./public
+
 go build -o out/assismgr-linux-amd64 src/*.go 
 GOARCH=arm64 go build -o out/assismgr-linux-arm64 src/* 
 
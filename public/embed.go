@@ -0,0 +1,31 @@
+// Package public 将 Web 界面打包进二进制文件。
+//
+// 发布前执行 go generate ./public 生成 .gz/.br 预压缩文件，一起打包后按客户端支持的编码直接返回。
+package public
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+)
+
+//go:generate go run ../tools/compress .
+
+// 打包整个目录，预压缩文件（如 index.html.gz）存在时一并打包，不存在时也能编译
+//
+//go:embed *
+var files embed.FS
+
+// FS 为打包的 Web 界面，不包含本目录的 Go 源文件
+var FS fs.FS = webFS{files}
+
+type webFS struct {
+	files embed.FS
+}
+
+func (f webFS) Open(name string) (fs.File, error) {
+	if path.Ext(name) == ".go" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.files.Open(name)
+}
@@ -0,0 +1,28 @@
+package public
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	if _, err := fs.Stat(FS, "index.html"); err != nil {
+		t.Errorf("index.html 没有打包: %v", err)
+	}
+	if _, err := fs.ReadFile(FS, "embed.go"); err == nil {
+		t.Error("不应打包 Go 源文件")
+	}
+
+	// go generate 生成的预压缩文件都应打包，包括页面的 .html.gz / .html.br
+	fs.WalkDir(os.DirFS("."), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !(strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".br")) {
+			return err
+		}
+		if _, err := fs.Stat(FS, name); err != nil {
+			t.Errorf("%s 没有打包: %v", name, err)
+		}
+		return nil
+	})
+}
@@ -39,7 +39,7 @@ async function updateSystem() {
             // 开始升级并监控进度
             upgradeStatus.uploading = false;
             upgradeStatus.upgrading = true;
-            await pollUpgradeProgress();
+            await watchUpgradeProgress();
             
             // 升级完成
             // updateStatusDisplay('系统升级完成！', 100, 'success');
@@ -81,7 +81,7 @@ async function updateSystem() {
             if (!response.ok) throw new Error('请求失败');
             
             // 开始监控升级进度
-            await pollUpgradeProgress();
+            await watchUpgradeProgress();
             // updateStatusDisplay('系统升级完成！', 100, 'success');
             // alert('系统升级完成');
         } catch (error) {
@@ -157,51 +157,39 @@ async function uploadFile(formData) {
         xhr.send(formData);
     });
 }
-// 轮询升级进度
-async function pollUpgradeProgress() {
+// 通过 WebSocket 订阅升级进度，升级完成时返回，失败、取消或连接断开时抛出错误
+function watchUpgradeProgress() {
     const progressBar = document.getElementById('uploadProgress');
     progressBar.style.display = 'inline-block';
     progressBar.value = 0;
-    
-    let finished = false;
-    let retryCount = 0;
-    const maxRetries = 30; // 最大重试次数（约30秒超时）
-    
-    while (!finished && retryCount < maxRetries) {
-        await new Promise(r => setTimeout(r, 1000));
-        
-        try {
-            const resp = await authFetch('/upgrade_progress');
-            if (!resp.ok) throw new Error('进度获取失败');
-            
-            const data = await resp.json();
+
+    return new Promise((resolve, reject) => {
+        let finished = false;
+        const ws = subscribeTopics(['upgrade'], (topic, data) => {
             if (data.progress !== undefined) {
                 progressBar.value = data.progress;
                 updateStatusDisplay(data.message, data.progress, 'info');
             }
-            
+
             if (data.status === 'done') {
                 finished = true;
                 progressBar.value = 100;
                 updateStatusDisplay('系统升级完成！', 100, 'success');
-            } else if (data.status === 'failed') {
+                ws.close();
+                resolve();
+            } else if (data.status === 'failed' || data.status === 'cancelled') {
                 finished = true;
-                throw new Error(data.message || '升级过程中出现错误');
+                ws.close();
+                reject(new Error(data.message || '升级过程中出现错误'));
             }
-            
-            retryCount = 0; // 重置重试计数器
-        } catch (e) {
-            retryCount++;
-            if (retryCount >= maxRetries) {
-                throw new Error('获取升级进度超时');
+        });
+        ws.onclose = () => {
+            progressBar.style.display = 'none';
+            if (!finished) {
+                reject(new Error('升级过程未正常完成'));
             }
-        }
-    }
-    
-    progressBar.style.display = 'none';
-    if (!finished) {
-        throw new Error('升级过程未正常完成');
-    }
+        };
+    });
 }
 
 // 更新状态显示
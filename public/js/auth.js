@@ -1,17 +1,62 @@
 
-// 封装 WebSocket 连接函数
-function createAuthWebSocket(url) {
+// 封装 WebSocket 连接函数，默认使用主题订阅协议 assismgr.v1
+function createAuthWebSocket(url, protocols = ['assismgr.v1']) {
     const token = localStorage.getItem('authToken');
     const wsUrl = new URL(url);
     
     // 通过 URL 参数传递 Token（或使用子协议）
     wsUrl.searchParams.set('token', token);
     
-    return new WebSocket(wsUrl.toString());
+    return new WebSocket(wsUrl.toString(), protocols);
 }
 
+// 连接 /ws 并订阅 topics，onEvent(topic, data) 接收事件
+function subscribeTopics(topics, onEvent) {
+    const ws = createAuthWebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + window.location.host + '/ws');
+    ws.onopen = () => ws.send(JSON.stringify({ type: 'subscribe', topics }));
+    ws.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        if (msg.type === 'event') {
+            onEvent(msg.topic, msg.data);
+        } else if (msg.type === 'error') {
+            console.error('WebSocket:', msg.error.message);
+        }
+    };
+    return ws;
+}
+
+
+// 使用刷新 Token 换取新的访问 Token，多个请求同时过期时只刷新一次
+let refreshPromise = null;
+
+function refreshAuthToken() {
+    if (refreshPromise) {
+        return refreshPromise;
+    }
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) {
+        return Promise.resolve(false);
+    }
+
+    refreshPromise = fetch('/token/refresh', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken })
+    }).then(async response => {
+        if (!response.ok) {
+            return false;
+        }
+        const data = await response.json();
+        localStorage.setItem('authToken', data.token);
+        localStorage.setItem('refreshToken', data.refresh_token);
+        return true;
+    }).catch(() => false).finally(() => {
+        refreshPromise = null;
+    });
+    return refreshPromise;
+}
 
-async function authFetch(url, options = {}) {
+async function authFetch(url, options = {}, retried = false) {
     // 自动添加 Authorization 头
     const headers = new Headers(options.headers || {});
     const token = localStorage.getItem('authToken');
@@ -25,10 +70,13 @@ async function authFetch(url, options = {}) {
         ...options,
         headers
     };
-    // 发起请求并处理 401 错误
+    // 发起请求并处理 401 错误，访问 Token 过期时先尝试刷新
     try {
         const response = await fetch(url, mergedOptions);
         if (response.status === 401) {
+            if (!retried && await refreshAuthToken()) {
+                return authFetch(url, options, true);
+            }
             handleUnauthorized();
             return Promise.reject('会话过期，请重新登录');
         }
@@ -42,6 +90,7 @@ async function authFetch(url, options = {}) {
 // 统一处理未授权
 function handleUnauthorized() {
     localStorage.removeItem('authToken');
+    localStorage.removeItem('refreshToken');
     // alert('会话已过期，即将跳转登录页面');
     window.location.href = '/login';
 }
\ No newline at end of file
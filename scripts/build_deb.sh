@@ -21,8 +21,6 @@ mkdir -p /tmp/assismgr_{amd64,arm64}/usr/lib/systemd/system/multi-user.target.wa
 # 复制文件（假设当前目录为项目根目录）
 cp out/assismgr-linux-amd64 /tmp/assismgr_amd64/usr/sbin/assismgr
 cp out/assismgr-linux-arm64 /tmp/assismgr_arm64/usr/sbin/assismgr
-cp -r public/* /tmp/assismgr_amd64/usr/www/assismgr/
-cp -r public/* /tmp/assismgr_arm64/usr/www/assismgr/
 cp HaPerfMonitor_config.json /tmp/assismgr_arm64/etc/assismgr/
 cp HaPerfMonitor_config.json /tmp/assismgr_amd64/etc/assismgr/
 
@@ -46,8 +44,9 @@ After=network.target
 [Service]
 User=root
 Type=simple
+# Web 界面已打包进二进制，工作目录保持不变，日志等相对路径仍在此目录下
 WorkingDirectory=/usr/www/assismgr
-ExecStart=/usr/sbin/assismgr -s /usr/www/assismgr -c /etc/assismgr/HaPerfMonitor_config.json
+ExecStart=/usr/sbin/assismgr -c /etc/assismgr/HaPerfMonitor_config.json
 Restart=on-failure
 RestartSec=30s
 
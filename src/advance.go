@@ -1,20 +1,21 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -56,24 +57,27 @@ func (r *progressTrackingReader) Read(p []byte) (n int, err error) {
 	return
 }
 
-func initAdvance() {
-	// 初始化取消通道
-	cancelChan = make(chan struct{})
-
-	// 定义 /reboot 接口
-	handleAuthRoute("/reboot", rebootSystem)
-
-	// 定义 /reset 接口
-	handleAuthRoute("/reset", resetSystem)
+// 升级请求的处理结果
+type UpgradeStatus struct {
+	Status string `json:"status"`
+}
 
-	// 定义 /upload_update 接口
-	handleAuthRoute("/upload_update", uploadUpdateHandler)
+// 通过 URL 下载升级包
+type UpgradeURLRequest struct {
+	URL string `json:"url"`
+}
 
-	// 定义 /upgrade_progress 接口
-	handleAuthRoute("/upgrade_progress", upgradeProgressHandler)
+type UpgradeProgress struct {
+	Progress  int      `json:"progress"`
+	Status    string   `json:"status"`
+	Message   string   `json:"message"`
+	Output    []string `json:"output"`
+	Timestamp int64    `json:"timestamp"`
+}
 
-	// 定义 /cancel_upgrade 接口
-	handleAuthRoute("/cancel_upgrade", cancelUpgradeHandler)
+func initAdvance() {
+	// 初始化取消通道
+	cancelChan = make(chan struct{})
 }
 
 // 取消升级
@@ -84,40 +88,56 @@ func cancelUpgradeHandler(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(time.Second * 2)
 		cancelChan = make(chan struct{}) // 重新创建通道
 		setUpgradeStatus("cancelled", 0, "升级已取消")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"cancelled"}`))
+		respondData(w, r, http.StatusOK, UpgradeStatus{Status: "cancelled"})
 	} else {
-		http.Error(w, "没有正在进行的升级", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "没有正在进行的升级")
 	}
 }
 
 // 重启系统
 func rebootSystem(w http.ResponseWriter, r *http.Request) {
-	cmd := exec.Command("reboot")
-	err := cmd.Run()
+	_, err := getRunner().Output(context.Background(), "reboot")
 	if err != nil {
 		log.Printf("系统重启失败: %v\n", err)
-		http.Error(w, "系统重启失败", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "系统重启失败")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("系统正在重启..."))
+	respondText(w, r, "系统正在重启...")
 }
 
 // 恢复出厂设置
 func resetSystem(w http.ResponseWriter, r *http.Request) {
 	// 直接删除文件
-	cmd := exec.Command("sh", "-c", "rm -rf /mnt/data/* /mnt/overlay/* && sync")
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("恢复出厂设置失败: %v\n", err)
-		http.Error(w, "恢复出厂设置失败: "+err.Error(), http.StatusInternalServerError)
-		return
+	for _, dir := range []string{dataPath(), sysFS().Path("/mnt/overlay")} {
+		if err := removeDirContents(dir); err != nil {
+			log.Printf("恢复出厂设置失败: %v\n", err)
+			respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "恢复出厂设置失败: "+err.Error())
+			return
+		}
+	}
+	if _, err := getRunner().Output(context.Background(), "sync"); err != nil {
+		log.Printf("恢复出厂设置后 sync 失败: %v\n", err)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("恢复出厂设置成功 需要手动重启系统"))
+	respondText(w, r, "恢复出厂设置成功 需要手动重启系统")
+}
+
+// 删除目录下的所有内容，保留目录本身。目录不存在时不做任何事
+func removeDirContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 var (
@@ -127,6 +147,7 @@ var (
 	upgradeMessage      string
 	raucOutput          []string
 	cancelChan          chan struct{} // 用于取消升级
+	raucInstalling      atomic.Int32  // 正在执行的 RAUC 安装数
 )
 
 func uploadUpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -140,21 +161,21 @@ func uploadUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		handleURLDownload(w, r)
 	default:
 		setUpgradeStatus("failed", 0, "不支持的Content-Type")
-		http.Error(w, "不支持的Content-Type", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "不支持的Content-Type")
 	}
 }
 
 func handleFileUpload(w http.ResponseWriter, r *http.Request) {
 	part, err := getMultipartFile(r, "updateFile")
 	if err != nil {
-		handleUploadError(w, err.Error())
+		handleUploadError(w, r, err.Error())
 		return
 	}
 	defer part.Close()
 
-	localPath, err := createUpgradeFile("/mnt/data/upgrades", "update.raucb")
+	localPath, err := createUpgradeFile(sysFS().Path(getConfig().UpgradeDir), "update.raucb")
 	if err != nil {
-		handleUploadError(w, err.Error())
+		handleUploadError(w, r, err.Error())
 		return
 	}
 
@@ -163,29 +184,27 @@ func handleFileUpload(w http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		setUpgradeStatus("failed", 0, err.Error())
-		handleUploadError(w, err.Error())
+		handleUploadError(w, r, err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"upload_complete"}`))
+	respondData(w, r, http.StatusOK, UpgradeStatus{Status: "upload_complete"})
 	startBackgroundInstall(localPath)
 }
 
 func handleURLDownload(w http.ResponseWriter, r *http.Request) {
 	url, err := getDownloadURL(r.Body)
 	if err != nil {
-		handleUploadError(w, err.Error())
+		handleUploadError(w, r, err.Error())
 		return
 	}
 
 	// 立即返回响应
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"download_started"}`))
+	respondData(w, r, http.StatusOK, UpgradeStatus{Status: "download_started"})
 
 	// 异步执行下载
 	go func() {
-		localPath, err := createUpgradeFile("/mnt/data/upgrades", "update.raucb")
+		localPath, err := createUpgradeFile(sysFS().Path(getConfig().UpgradeDir), "update.raucb")
 		if err != nil {
 			setUpgradeStatus("failed", 0, err.Error())
 			return
@@ -291,7 +310,7 @@ func copyWithProgress(src io.Reader, dstPath string, contentLength int64, progre
 }
 
 func getDownloadURL(body io.ReadCloser) (string, error) {
-	var req struct{ URL string }
+	var req UpgradeURLRequest
 	if err := json.NewDecoder(body).Decode(&req); err != nil {
 		return "", fmt.Errorf("解析请求失败: %w", err)
 	}
@@ -364,7 +383,9 @@ func downloadWithProgress(src io.Reader, dstPath string, contentLength int64, ca
 }
 
 func startBackgroundInstall(localPath string) {
+	raucInstalling.Add(1)
 	go func() {
+		defer raucInstalling.Add(-1)
 		if err := doRaucInstall(localPath); err != nil {
 			setUpgradeStatus("failed", 0, "安装失败: "+err.Error())
 		} else {
@@ -374,83 +395,92 @@ func startBackgroundInstall(localPath string) {
 	}()
 }
 
-func handleUploadError(w http.ResponseWriter, message string) {
+func handleUploadError(w http.ResponseWriter, r *http.Request, message string) {
 	setUpgradeStatus("failed", 0, message)
-	http.Error(w, message, http.StatusInternalServerError)
+	respondError(w, r, http.StatusInternalServerError, errCodeInternal, message)
 }
 func doRaucInstall(pkg string) error {
 	setUpgradeStatus("installing", 80, "开始安装升级包")
 
-	cmd := exec.Command("rauc", "install", pkg)
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("创建输出管道失败: %v", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("启动RAUC失败: %v", err)
-	}
-
-	done := make(chan error, 1)
+	// 取消升级时终止 RAUC 进程
+	cancelled := cancelChan
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		// 解析RAUC输出获取精确进度
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			select {
-			case <-cancelChan:
-				return
-			default:
-				line := scanner.Text()
-				raucOutput = append(raucOutput, line)
-
-				// 解析进度信息 (例如: " 45% Copying image to rootfs.0")
-				if strings.Contains(line, "%") {
-					parts := strings.Fields(line)
-					if len(parts) > 0 {
-						percentStr := strings.TrimSuffix(parts[0], "%")
-						if percent, err := strconv.Atoi(percentStr); err == nil {
-							setUpgradeStatus("installing", percent, "安装中: ")
-						}
-					}
-				}
-			}
+		select {
+		case <-cancelled:
+			cancel()
+		case <-ctx.Done():
 		}
-		done <- cmd.Wait()
 	}()
 
-	select {
-	case <-cancelChan:
-		// 终止RAUC进程
-		if err := cmd.Process.Kill(); err != nil {
-			return fmt.Errorf("终止RAUC进程失败: %v", err)
+	err := getRunner().Stream(ctx, func(line string) {
+		appendRaucOutput(line)
+		if percent, ok := parseRaucProgress(line); ok {
+			setUpgradeStatus("installing", percent, "安装中: ")
 		}
+	}, "rauc", "install", pkg)
+
+	select {
+	case <-cancelled:
 		return fmt.Errorf("升级已取消")
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("RAUC安装失败: %v", err)
-		}
-		return nil
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("RAUC安装失败: %v", err)
+	}
+	return nil
+}
+
+// 解析 RAUC 输出中的进度，例如 " 45% Copying image to rootfs.0"
+func parseRaucProgress(line string) (int, bool) {
+	if !strings.Contains(line, "%") {
+		return 0, false
 	}
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return 0, false
+	}
+	percent, err := strconv.Atoi(strings.TrimSuffix(parts[0], "%"))
+	if err != nil {
+		return 0, false
+	}
+	return percent, true
+}
+
+func appendRaucOutput(line string) {
+	upgradeProgressLock.Lock()
+	raucOutput = append(raucOutput, line)
+	upgradeProgressLock.Unlock()
+	publishUpgradeProgress()
 }
 
 func upgradeProgressHandler(w http.ResponseWriter, r *http.Request) {
+	respondData(w, r, http.StatusOK, currentUpgradeProgress())
+}
+
+func currentUpgradeProgress() UpgradeProgress {
 	upgradeProgressLock.Lock()
 	defer upgradeProgressLock.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"progress":  upgradeProgress,
-		"status":    upgradeStatus,
-		"message":   upgradeMessage,
-		"output":    raucOutput,
-		"timestamp": time.Now().Unix(),
-	})
+	return UpgradeProgress{
+		Progress:  upgradeProgress,
+		Status:    upgradeStatus,
+		Message:   upgradeMessage,
+		Output:    append([]string(nil), raucOutput...),
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// 推送给订阅了 upgrade 主题的 WebSocket 连接
+func publishUpgradeProgress() {
+	if hasSubscribers(topicUpgrade) {
+		publishEvent(topicUpgrade, topicUpgrade, currentUpgradeProgress())
+	}
 }
 
 func setUpgradeStatus(status string, progress int, message string) {
 	upgradeProgressLock.Lock()
-	defer upgradeProgressLock.Unlock()
-
 	upgradeStatus = status
 	upgradeProgress = progress
 	upgradeMessage = message
@@ -459,14 +489,36 @@ func setUpgradeStatus(status string, progress int, message string) {
 	if len(raucOutput) > 100 {
 		raucOutput = raucOutput[len(raucOutput)-100:]
 	}
+	upgradeProgressLock.Unlock()
+	publishUpgradeProgress()
+}
+
+// 退出前等待正在进行的 RAUC 安装结束，force 收到信号时放弃等待
+func waitForUpgrade(force <-chan os.Signal) {
+	if raucInstalling.Load() == 0 {
+		return
+	}
+	log.Println("正在安装升级包，等待安装完成后退出，再次发送信号可强制退出")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for raucInstalling.Load() > 0 {
+		select {
+		case <-force:
+			log.Println("强制退出，升级安装被中断")
+			return
+		case <-ticker.C:
+		}
+	}
+	log.Println("升级包安装结束")
 }
 
 func resetUpgradeStatus() {
 	upgradeProgressLock.Lock()
-	defer upgradeProgressLock.Unlock()
-
 	upgradeStatus = "idle"
 	upgradeProgress = 0
 	upgradeMessage = ""
 	raucOutput = nil
+	upgradeProgressLock.Unlock()
+	publishUpgradeProgress()
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRaucProgress(t *testing.T) {
+	tests := []struct {
+		line    string
+		percent int
+		ok      bool
+	}{
+		{"  0% Installing", 0, true},
+		{" 45% Copying image to rootfs.0", 45, true},
+		{"100% Installing done.", 100, true},
+		{"installing `/tmp/update.raucb`: started", 0, false},
+		{"Checking bundle: 20% done", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		percent, ok := parseRaucProgress(tt.line)
+		if percent != tt.percent || ok != tt.ok {
+			t.Errorf("parseRaucProgress(%q) = %d, %v，期望 %d, %v", tt.line, percent, ok, tt.percent, tt.ok)
+		}
+	}
+}
+
+// 重置升级状态，测试结束后恢复
+func resetUpgradeState(t *testing.T) {
+	oldCancel := cancelChan
+	cancelChan = make(chan struct{})
+	setUpgradeStatus("idle", 0, "")
+	upgradeProgressLock.Lock()
+	raucOutput = nil
+	upgradeProgressLock.Unlock()
+	t.Cleanup(func() {
+		cancelChan = oldCancel
+		setUpgradeStatus("idle", 0, "")
+	})
+}
+
+func currentUpgrade() (string, int, []string) {
+	upgradeProgressLock.Lock()
+	defer upgradeProgressLock.Unlock()
+	return upgradeStatus, upgradeProgress, append([]string(nil), raucOutput...)
+}
+
+const raucInstallOutput = `installing ` + "`/tmp/update.raucb`" + `: started
+  0% Installing
+ 20% Checking bundle
+ 45% Copying image to rootfs.0
+ 99% Copying image to rootfs.0 done.
+100% Installing done.
+installing ` + "`/tmp/update.raucb`" + `: succeeded
+`
+
+func TestDoRaucInstall(t *testing.T) {
+	resetUpgradeState(t)
+	f := newFakeRunner(t).on("rauc install /tmp/update.raucb", raucInstallOutput, nil)
+
+	if err := doRaucInstall("/tmp/update.raucb"); err != nil {
+		t.Fatalf("doRaucInstall: %v", err)
+	}
+	status, progress, output := currentUpgrade()
+	if status != "installing" || progress != 100 {
+		t.Errorf("状态 %s %d%%，期望 installing 100%%", status, progress)
+	}
+	if want := strings.Split(strings.TrimSuffix(raucInstallOutput, "\n"), "\n"); strings.Join(output, "\n") != strings.Join(want, "\n") {
+		t.Errorf("RAUC 输出:\n%s", strings.Join(output, "\n"))
+	}
+	assertCommands(t, f, "rauc install /tmp/update.raucb")
+}
+
+func TestDoRaucInstallFailure(t *testing.T) {
+	resetUpgradeState(t)
+	newFakeRunner(t).on("rauc install /tmp/update.raucb", " 10% Checking bundle\n", errExitStatus1)
+
+	err := doRaucInstall("/tmp/update.raucb")
+	if err == nil || !strings.Contains(err.Error(), "RAUC安装失败") {
+		t.Fatalf("doRaucInstall 应返回安装失败，得到 %v", err)
+	}
+	if _, progress, _ := currentUpgrade(); progress != 10 {
+		t.Errorf("进度 %d%%，期望 10%%", progress)
+	}
+}
+
+func TestDoRaucInstallCancel(t *testing.T) {
+	resetUpgradeState(t)
+	newFakeRunner(t).onResult("rauc install /tmp/update.raucb", fakeResult{Output: " 30% Copying image\n", Block: true})
+
+	done := make(chan error, 1)
+	go func() { done <- doRaucInstall("/tmp/update.raucb") }()
+
+	// 等待 RAUC 输出进度后取消
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, progress, _ := currentUpgrade(); progress == 30 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待安装进度超时")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(cancelChan)
+
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "升级已取消" {
+			t.Errorf("doRaucInstall 应返回升级已取消，得到 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后 doRaucInstall 没有返回")
+	}
+}
+
+func TestResetSystem(t *testing.T) {
+	root := useSandboxRoot(t, map[string]string{
+		"/mnt/data/state.db":         "db",
+		"/mnt/data/.hidden":          "x",
+		"/mnt/data/dir with space/f": "x",
+		"/mnt/overlay/etc/hostname":  "x",
+	})
+	runner := newFakeRunner(t).on("sync", "", nil)
+
+	w := callHandler(resetSystem, http.MethodPost, "/reset", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resetSystem 返回 %d: %s", w.Code, w.Body.String())
+	}
+	for _, dir := range []string{"/mnt/data", "/mnt/overlay"} {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil || len(entries) != 0 {
+			t.Errorf("%s 应保留为空目录: %v %v", dir, entries, err)
+		}
+	}
+	assertCommands(t, runner, "sync")
+}
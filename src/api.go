@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// REST 接口统一挂在该前缀下，旧路径作为兼容别名保留
+const apiPrefix = "/api/v1"
+
+// 机器可读的错误码，客户端应根据错误码而不是错误信息判断错误类型
+const (
+	errCodeBadRequest         = "bad_request"
+	errCodeUnauthorized       = "unauthorized"
+	errCodeInvalidToken       = "invalid_token"
+	errCodeInvalidAPIKey      = "invalid_api_key"
+	errCodeInvalidCredentials = "invalid_credentials"
+	errCodeTOTPRequired       = "totp_required"
+	errCodeInvalidOTP         = "invalid_otp"
+	errCodeForbidden          = "forbidden"
+	errCodeScopeDenied        = "scope_denied"
+	errCodePasswordChange     = "password_change_required"
+	errCodeNotFound           = "not_found"
+	errCodeMethodNotAllowed   = "method_not_allowed"
+	errCodeConflict           = "conflict"
+	errCodeTooManyRequests    = "too_many_requests"
+	errCodeCommandFailed      = "command_failed"
+	errCodeInvalidConfig      = "invalid_config"
+	errCodeUnavailable        = "unavailable"
+	errCodeInternal           = "internal_error"
+)
+
+// /api/v1 下所有响应使用的信封，成功时 data 为响应内容，失败时 error 包含错误码和信息
+type apiResponse struct {
+	OK    bool        `json:"ok"`
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// 只包含提示信息的响应
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// 按 ID 操作的请求
+type IDRequest struct {
+	ID string `json:"id"`
+}
+
+// 一条接口路由，同一路径可以按方法注册多条
+type apiRoute struct {
+	Method  string
+	Path    string // /api/v1 下的路径
+	Legacy  string // 兼容的旧路径，为空表示没有
+	Role    Role   // 所需的最低角色
+	Public  bool   // 无需登录
+	Scope   string // 允许访问的 API Key 范围，为空表示不能使用 API Key
+	Handler http.HandlerFunc
+
+	// 必须修改默认密码的用户也可以访问
+	AllowPasswordChange bool
+
+	// Token 也可以通过查询参数 token 传递，用于浏览器无法设置请求头的 WebSocket
+	TokenQuery bool
+
+	// 以下字段用于生成 OpenAPI 文档
+	Summary  string
+	Query    []apiParam
+	Request  interface{} // 请求体类型的零值，JSON 以外的请求体使用 noBody、textBody 等标记
+	Response interface{} // 成功时 data 的类型的零值
+}
+
+// 查询参数
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// 请求体标记：没有请求体
+type noBody struct{}
+
+// 请求体标记：纯文本
+type textBody struct{}
+
+// 请求体标记：multipart 表单，Files 为文件字段
+type multipartBody struct {
+	Files []string
+}
+
+// 请求体标记：application/x-www-form-urlencoded 表单，字段同 Fields 的 JSON 字段
+type formBody struct {
+	Fields interface{}
+}
+
+// 同一接口接受多种请求体
+type requestBodies []interface{}
+
+// 响应标记：Server-Sent Events 流，Event 为各事件 data 的类型
+type eventStream struct {
+	Event interface{}
+}
+
+// 响应标记：升级为 WebSocket 连接，Message 为服务端推送的消息类型
+type webSocket struct {
+	Message interface{}
+}
+
+// 响应标记：不使用信封的文本，ContentType 为响应的内容类型
+type textResponse struct {
+	ContentType string
+}
+
+var (
+	apiRoutesLock sync.Mutex
+	apiRoutes     []*apiRoute
+)
+
+// 当前请求是否来自 /api/v1，决定响应使用信封格式还是旧格式
+func isAPIRequest(r *http.Request) bool {
+	v, _ := r.Context().Value("api").(bool)
+	return v
+}
+
+// 成功响应。旧路径直接返回 data，保持原有格式
+func respondData(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	if !isAPIRequest(r) {
+		respondJSON(w, status, data)
+		return
+	}
+	respondJSON(w, status, apiResponse{OK: true, Data: data})
+}
+
+// 只有一条提示信息的成功响应，旧路径按原来的纯文本返回
+func respondText(w http.ResponseWriter, r *http.Request, message string) {
+	if !isAPIRequest(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(message))
+		return
+	}
+	respondJSON(w, http.StatusOK, apiResponse{OK: true, Data: MessageResponse{Message: message}})
+}
+
+// 错误响应。旧路径按原来的 http.Error 返回纯文本
+func respondError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	respondErrorDetails(w, r, status, code, message, nil)
+}
+
+// 带附加信息的错误响应，附加信息只在 /api/v1 路径返回
+func respondErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]interface{}) {
+	if !isAPIRequest(r) {
+		http.Error(w, message, status)
+		return
+	}
+	respondJSON(w, status, apiResponse{Error: &apiError{Code: code, Message: message, Details: details}})
+}
+
+// 原来就返回 JSON 错误的旧路径（登录、Token 校验、切换热点）使用：
+// 旧路径返回 {"error": message}，附加信息合并到顶层字段
+func respondErrorJSON(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]interface{}) {
+	if !isAPIRequest(r) {
+		body := map[string]interface{}{"error": message}
+		for k, v := range details {
+			body[k] = v
+		}
+		respondJSON(w, status, body)
+		return
+	}
+	respondErrorDetails(w, r, status, code, message, details)
+}
+
+// 注册路由：/api/v1 路径严格检查请求方法，旧路径不检查，兼容原来用 GET 调用的客户端；
+// 同一旧路径注册了多个方法时按方法分发
+func registerRoutes(mux *http.ServeMux, routes []*apiRoute) {
+	apiRoutesLock.Lock()
+	defer apiRoutesLock.Unlock()
+
+	byPath := map[string][]*apiRoute{}
+	byLegacy := map[string][]*apiRoute{}
+	var paths, legacyPaths []string
+	for _, rt := range routes {
+		if _, ok := byPath[rt.Path]; !ok {
+			paths = append(paths, rt.Path)
+		}
+		byPath[rt.Path] = append(byPath[rt.Path], rt)
+		if rt.Legacy != "" {
+			if _, ok := byLegacy[rt.Legacy]; !ok {
+				legacyPaths = append(legacyPaths, rt.Legacy)
+			}
+			byLegacy[rt.Legacy] = append(byLegacy[rt.Legacy], rt)
+		}
+	}
+	apiRoutes = routes
+
+	for _, p := range paths {
+		mux.HandleFunc(apiPrefix+p, apiMethodHandler(byPath[p]))
+	}
+	for _, p := range legacyPaths {
+		mux.HandleFunc(p, legacyHandler(byLegacy[p]))
+	}
+	mux.HandleFunc(openAPIPath, openAPIHandler)
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), "api", true))
+		respondError(w, r, http.StatusNotFound, errCodeNotFound, "No such endpoint")
+	})
+}
+
+// 加上请求统计、鉴权和审计
+func (rt *apiRoute) wrap() http.HandlerFunc {
+	if rt.Public {
+		return metricsMiddleware(rt, rt.Handler)
+	}
+	return metricsMiddleware(rt, authMiddleware(rt, auditMiddleware(rt, rt.Handler)))
+}
+
+func apiMethodHandler(routes []*apiRoute) http.HandlerFunc {
+	handlers := map[string]http.HandlerFunc{}
+	var allow []string
+	for _, rt := range routes {
+		handlers[rt.Method] = rt.wrap()
+		allow = append(allow, rt.Method)
+	}
+	sort.Strings(allow)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), "api", true))
+		h, ok := handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func legacyHandler(routes []*apiRoute) http.HandlerFunc {
+	if len(routes) == 1 {
+		return routes[0].wrap()
+	}
+	handlers := map[string]http.HandlerFunc{}
+	for _, rt := range routes {
+		handlers[rt.Method] = rt.wrap()
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.Method]
+		if !ok {
+			respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// 所有路由中出现的 API Key 范围
+func apiKeyScopes() []string {
+	apiRoutesLock.Lock()
+	defer apiRoutesLock.Unlock()
+
+	seen := map[string]bool{}
+	var scopes []string
+	for _, rt := range apiRoutes {
+		if rt.Scope != "" && !seen[rt.Scope] {
+			seen[rt.Scope] = true
+			scopes = append(scopes, rt.Scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
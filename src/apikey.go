@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 脚本调用时通过该请求头传递 API Key，不需要走 /login
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyPrefix = "amk_"
+)
+
+// 最近使用时间每次都更新内存，间隔超过该值才写回数据库
+const apiKeyLastUsedFlush = 60 // 秒
+
+var (
+	errAPIKeyNotFound = errors.New("api key not found")
+	errInvalidAPIKey  = errors.New("invalid api key")
+)
+
+// API Key 以创建者的身份和角色访问，同时受 Scopes 限制。只保存密钥的哈希
+type APIKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Owner     string   `json:"owner"`
+	Scopes    []string `json:"scopes"`
+	Hash      string   `json:"hash"`
+	CreatedAt int64    `json:"created_at"`
+	LastUsed  int64    `json:"last_used,omitempty"`
+	LastIP    string   `json:"last_ip,omitempty"`
+	lastSaved int64
+}
+
+// 返回给客户端的 API Key 信息，不包含哈希
+type APIKeyInfo struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Owner     string   `json:"owner"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	LastUsed  int64    `json:"last_used,omitempty"`
+	LastIP    string   `json:"last_ip,omitempty"`
+}
+
+func (k *APIKey) info() APIKeyInfo {
+	return APIKeyInfo{
+		ID:        k.ID,
+		Name:      k.Name,
+		Owner:     k.Owner,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+		LastUsed:  k.LastUsed,
+		LastIP:    k.LastIP,
+	}
+}
+
+// 该 Key 的范围是否包含路由所属的范围，路由表中没有范围的路由（用户、会话等）不能通过 API Key 访问
+func (k *APIKey) allows(scope string) bool {
+	return scope != "" && slices.Contains(k.Scopes, scope)
+}
+
+var (
+	apiKeyMutex sync.Mutex
+	apiKeys     = map[string]*APIKey{}
+)
+
+func loadAPIKeys() {
+	apiKeyMutex.Lock()
+	defer apiKeyMutex.Unlock()
+
+	rows, err := stateDB.Query("SELECT id, name, owner, scopes, hash, created_at, last_used, last_ip FROM api_keys")
+	if err != nil {
+		log.Printf("读取API Key失败: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	apiKeys = map[string]*APIKey{}
+	for rows.Next() {
+		k := &APIKey{}
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.Name, &k.Owner, &scopes, &k.Hash, &k.CreatedAt, &k.LastUsed, &k.LastIP); err != nil {
+			log.Printf("读取API Key失败: %v", err)
+			return
+		}
+		if err := json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+			log.Printf("API Key %s 的范围无效: %v", k.ID, err)
+			continue
+		}
+		k.lastSaved = k.LastUsed
+		apiKeys[k.ID] = k
+	}
+}
+
+func insertAPIKey(tx *sql.Tx, k *APIKey) error {
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO api_keys (id, name, owner, scopes, hash, created_at, last_used, last_ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.Name, k.Owner, string(scopes), k.Hash, k.CreatedAt, k.LastUsed, k.LastIP)
+	return err
+}
+
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	known := apiKeyScopes()
+	for _, s := range scopes {
+		if !slices.Contains(known, s) {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+// 创建 API Key，返回完整密钥（只返回这一次），格式为 amk_<ID>_<随机串>
+func createAPIKey(name, owner string, scopes []string) (*APIKey, string, error) {
+	apiKeyMutex.Lock()
+	defer apiKeyMutex.Unlock()
+
+	secret := newTokenID()
+	k := &APIKey{
+		ID:        newTokenID()[:12],
+		Name:      name,
+		Owner:     owner,
+		Scopes:    scopes,
+		Hash:      hashRefreshSecret(secret),
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := storeTx(func(tx *sql.Tx) error { return insertAPIKey(tx, k) }); err != nil {
+		return nil, "", err
+	}
+	apiKeys[k.ID] = k
+	copied := *k
+	return &copied, apiKeyPrefix + k.ID + "_" + secret, nil
+}
+
+// 校验 API Key 并记录最近使用时间
+func validateAPIKey(key string, r *http.Request) (*APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+
+	apiKeyMutex.Lock()
+	defer apiKeyMutex.Unlock()
+
+	k, exists := apiKeys[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashRefreshSecret(secret))) != 1 {
+		return nil, errInvalidAPIKey
+	}
+
+	k.LastUsed = time.Now().Unix()
+	k.LastIP = clientIP(r)
+	if k.LastUsed-k.lastSaved >= apiKeyLastUsedFlush {
+		_, err := stateDB.Exec("UPDATE api_keys SET last_used = ?, last_ip = ? WHERE id = ?", k.LastUsed, k.LastIP, k.ID)
+		if err != nil {
+			log.Printf("保存API Key使用时间失败: %v", err)
+		} else {
+			k.lastSaved = k.LastUsed
+		}
+	}
+	copied := *k
+	return &copied, nil
+}
+
+func deleteAPIKey(id string) error {
+	apiKeyMutex.Lock()
+	defer apiKeyMutex.Unlock()
+
+	if _, ok := apiKeys[id]; !ok {
+		return errAPIKeyNotFound
+	}
+	if _, err := stateDB.Exec("DELETE FROM api_keys WHERE id = ?", id); err != nil {
+		return err
+	}
+	delete(apiKeys, id)
+	return nil
+}
+
+// 删除用户创建的所有 API Key，用于删除用户
+func deleteUserAPIKeys(owner string) error {
+	apiKeyMutex.Lock()
+	defer apiKeyMutex.Unlock()
+
+	if _, err := stateDB.Exec("DELETE FROM api_keys WHERE owner = ?", owner); err != nil {
+		return err
+	}
+	for id, k := range apiKeys {
+		if k.Owner == owner {
+			delete(apiKeys, id)
+		}
+	}
+	return nil
+}
+
+func listAPIKeys() []APIKeyInfo {
+	apiKeyMutex.Lock()
+	defer apiKeyMutex.Unlock()
+
+	infos := make([]APIKeyInfo, 0, len(apiKeys))
+	for _, k := range apiKeys {
+		infos = append(infos, k.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt < infos[j].CreatedAt })
+	return infos
+}
+
+type APIKeyList struct {
+	Keys   []APIKeyInfo `json:"keys"`
+	Scopes []string     `json:"scopes"` // 可用的范围
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type APIKeyCreated struct {
+	Key  string     `json:"key"` // 完整密钥，只返回这一次
+	Info APIKeyInfo `json:"info"`
+}
+
+// API Key 列表
+func listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	respondData(w, r, http.StatusOK, APIKeyList{
+		Keys:   listAPIKeys(),
+		Scopes: apiKeyScopes(),
+	})
+}
+
+// 创建 API Key，Key 以当前用户的身份访问
+func addAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return
+	}
+	if err := validScopes(req.Scopes); err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+
+	k, key, err := createAPIKey(req.Name, currentUsername(r), req.Scopes)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to create api key")
+		return
+	}
+	respondData(w, r, http.StatusOK, APIKeyCreated{
+		Key:  key,
+		Info: k.info(),
+	})
+}
+
+// 注销 API Key
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req IDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return
+	}
+	if err := deleteAPIKey(req.ID); err != nil {
+		if errors.Is(err, errAPIKeyNotFound) {
+			respondError(w, r, http.StatusNotFound, errCodeNotFound, err.Error())
+		} else {
+			respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+		}
+		return
+	}
+	respondData(w, r, http.StatusOK, MessageResponse{Message: "API key revoked"})
+}
+
+func initAPIKeys() {
+	loadAPIKeys()
+}
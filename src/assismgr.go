@@ -1,35 +1,41 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-
 type SystemInfo struct {
-	CPUUsage  float64 `json:"cpu_usage"`
-	MemUsage  float64 `json:"mem_usage"`
-	DiskUsage float64 `json:"disk_usage"`
+	Time         int64              `json:"time"` // 采样时间，Unix 秒
+	CPUUsage     float64            `json:"cpu_usage"`
+	MemUsage     float64            `json:"mem_usage"`
+	DiskUsage    float64            `json:"disk_usage"`
+	CPUCores     []float64          `json:"cpu_cores"` // 各核使用率，按核编号排列
+	CPUFreqs     []CPUFreq          `json:"cpu_freqs"`
+	Load         LoadAvg            `json:"load"`
+	Uptime       uint64             `json:"uptime"` // 开机时长，秒
+	SwapUsage    float64            `json:"swap_usage"`
+	SwapTotal    uint64             `json:"swap_total"`   // 字节，未启用交换分区时为 0
+	Temperatures map[string]float64 `json:"temperatures"` // 各温区温度（摄氏度），键为温区类型
+	Throttle     ThrottleInfo       `json:"throttle"`
+
+	disk map[string]float64 // getDiskUsage 的结果，供 MQTT 磁盘传感器和 Prometheus 使用
 }
 
 func parseSSIDs(output string) []string {
@@ -52,69 +58,46 @@ type WifiNetwork struct {
 	SSID      string   `json:"ssid"`
 }
 
-func startWebSocket() {
-	wsHandler := func(w http.ResponseWriter, r *http.Request) {
-		token := r.URL.Query().Get("token")
-		if token == "" {
-			log.Println(r.URL, "Invalid token")
-			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
-			return
-		}
-
-		_, err := validateToken(token)
-		if err != nil {
-			log.Println(r.URL, "Invalid token")
-			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
-			return
-		}
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Println("WebSocket upgrade error:", err)
-			return
-		}
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				info := getSystemInfo()
-				err := conn.WriteJSON(info)
-				if err != nil {
-					log.Println("WebSocket write error:", err)
-					conn.Close()
-					return
-				}
-			}
-		}
-	}
-
-	http.HandleFunc("/ws", wsHandler)
+// 直接读取一次系统信息，通常应使用 currentSystemInfo 读取采样结果
+func getSystemInfo() SystemInfo {
+	return readSystemInfo(time.Now(), getDiskUsage())
 }
 
-func getSystemInfo() SystemInfo {
-	var cpuPercent float64
-	var memPercent, diskPercent float64
+// disk 为 getDiskUsage 的结果，由调用方决定磁盘的采样频率
+func readSystemInfo(now time.Time, disk map[string]float64) SystemInfo {
+	info := SystemInfo{Time: now.Unix(), disk: disk}
 
-	// CPU
-	percentages, _ := cpu.Percent(time.Second, false) // 第二个参数设为 false 表示不按CPU核单独统计
-	cpuPercent = percentages[0]
+	// CPU，按核统计，整体使用率取各核的平均值。间隔为 0 时与上一次调用比较，不会阻塞
+	percentages, err := cpu.Percent(0, true)
+	if err == nil && len(percentages) > 0 {
+		var sum float64
+		for _, p := range percentages {
+			sum += p
+		}
+		info.CPUUsage = sum / float64(len(percentages))
+		info.CPUCores = percentages
+	}
 
 	// Memory
-	mem, _ := mem.VirtualMemory()
-	memPercent = float64(mem.Used) / float64(mem.Total) * 100
+	if mem, err := mem.VirtualMemory(); err == nil && mem.Total > 0 {
+		info.MemUsage = float64(mem.Used) / float64(mem.Total) * 100
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		info.SwapUsage = swap.UsedPercent
+		info.SwapTotal = swap.Total
+	}
 
 	// Disk
-	disks := getDiskUsage()
-	if disks != nil {
-		diskPercent = float64(disks["usage"]) / float64(disks["total"]) * 100
+	if disk != nil && disk["total"] > 0 {
+		info.DiskUsage = disk["usage"] / disk["total"] * 100
 	}
 
-	return SystemInfo{
-		CPUUsage:  cpuPercent,
-		MemUsage:  memPercent,
-		DiskUsage: diskPercent,
-	}
+	info.Load, _ = readLoadAvg()
+	info.Uptime, _ = readUptime()
+	info.CPUFreqs = readCPUFreqs()
+	info.Temperatures = readTemperatures()
+	info.Throttle = readThrottle(context.Background())
+	return info
 }
 
 type NetworkStats struct {
@@ -146,37 +129,54 @@ func getNetSpeed(intName string) (rxSpeed, txSpeed string, err error) {
 
 // getNetworkStats 从/proc/net/dev获取指定网络接口的统计信息
 func getNetworkStats(intName string) (NetworkStats, error) {
-	data, err := os.ReadFile("/proc/net/dev")
+	all, err := getAllNetworkStats()
 	if err != nil {
 		return NetworkStats{}, err
 	}
+	stats, ok := all[intName]
+	if !ok {
+		return NetworkStats{}, fmt.Errorf("interface %s not found", intName)
+	}
+	return stats, nil
+}
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, intName+":") {
-			fields := strings.Fields(line)
-			if len(fields) < 10 {
-				return NetworkStats{}, fmt.Errorf("invalid network stats format")
-			}
+// 读取 /proc/net/dev 中所有网卡的收发字节数
+func getAllNetworkStats() (map[string]NetworkStats, error) {
+	data, err := sysFS().ReadFile("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	return parseNetDev(string(data))
+}
 
-			rx, err := strconv.ParseUint(fields[1], 10, 64)
-			if err != nil {
-				return NetworkStats{}, err
-			}
+func parseNetDev(data string) (map[string]NetworkStats, error) {
+	all := make(map[string]NetworkStats)
+	for _, line := range strings.Split(data, "\n") {
+		name, counters, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("invalid network stats format")
+		}
 
-			tx, err := strconv.ParseUint(fields[9], 10, 64)
-			if err != nil {
-				return NetworkStats{}, err
-			}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
 
-			return NetworkStats{
-				RXBytes: rx,
-				TXBytes: tx,
-			}, nil
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	return NetworkStats{}, fmt.Errorf("interface %s not found", intName)
+		all[strings.TrimSpace(name)] = NetworkStats{
+			RXBytes: rx,
+			TXBytes: tx,
+		}
+	}
+	return all, nil
 }
 
 // formatBytes 将字节数格式化为易读的字符串 (B/s, KB/s, MB/s)
@@ -191,41 +191,43 @@ func formatBytes(bytes uint64) string {
 	}
 }
 
-var isOnlineStatus bool
+// 最近一次网络检测的结果，由 checkInternet 写入，HTTP 处理和采样协程读取
+var isOnlineStatus atomic.Bool
 
-func checkPingisSimple() bool {
-	cmd := exec.Command("ping", "-V")
-	_, err := cmd.Output()
+func checkPingisSimple(ctx context.Context) bool {
+	_, err := getRunner().Output(ctx, "ping", "-V")
 	if err != nil {
 		log.Println("ping is Simple")
 		return true
 	}
 	return false
 }
-func checkInternet() {
-	// var pingArgs string
-	isSimple := checkPingisSimple()
-
-	// if isSimple {
-	// 	pingArgs = "www.baidu.com"
-	// } else {
-	// 	pingArgs = " -c 1 www.baidu.com"
-	// }
+
+// 周期性 ping 检测网络，直到 ctx 结束
+func checkInternet(ctx context.Context, host string) {
+	isSimple := checkPingisSimple(ctx)
+
 	for {
-		var cmd *exec.Cmd
+		args := []string{"-c", "1", "-W", "1", host}
 		if isSimple {
-			cmd = exec.Command("ping", "www.baidu.com")
-		} else {
-			cmd = exec.Command("ping", "-c", "1", "-W", "1", "www.baidu.com")
+			args = []string{host}
+		}
+		_, err := getRunner().Output(ctx, "ping", args...)
+		if ctx.Err() != nil {
+			return
 		}
-		err := cmd.Run()
 		if err == nil {
-			isOnlineStatus = true
+			isOnlineStatus.Store(true)
 		} else {
 			log.Println("ping fail, DNS error:", err.Error())
-			isOnlineStatus = false
+			isOnlineStatus.Store(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * 4):
 		}
-		time.Sleep(time.Second * 4)
 	}
 }
 
@@ -246,14 +248,14 @@ func isOnlineWithDNS(host string, timeout time.Duration) bool {
 	return false
 
 }
-func netStauts(w http.ResponseWriter, r *http.Request) {
 
-	type NetWorkStatus struct {
-		Netstaus  bool   `json:"netstaus"`
-		Downspeed string `json:"downspeed"`
-		Upspeed   string `json:"upspeed"`
-	}
+type NetWorkStatus struct {
+	Netstaus  bool   `json:"netstaus"`
+	Downspeed string `json:"downspeed"`
+	Upspeed   string `json:"upspeed"`
+}
 
+func netStauts(w http.ResponseWriter, r *http.Request) {
 	var netstaus bool
 	if !isOnlineWithDNS("baidu.com", time.Second) {
 		netstaus = false
@@ -261,152 +263,323 @@ func netStauts(w http.ResponseWriter, r *http.Request) {
 		netstaus = true
 	}
 
-	rxSpeed, txSpeed, _ := getNetSpeed("wlan0")
+	rxSpeed, txSpeed, _ := getNetSpeed(getConfig().WlanIface)
 	status := NetWorkStatus{
 		Netstaus:  netstaus,
 		Downspeed: rxSpeed,
 		Upspeed:   txSpeed,
 	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(status); err != nil {
-		log.Printf("JSON 编码失败: %v\n", err)
-		http.Error(w, "服务器内部错误", http.StatusInternalServerError)
-	}
+	respondData(w, r, http.StatusOK, status)
 }
 
-func getServerLogs(w http.ResponseWriter, r *http.Request) {
-	// 读取日志文件
-	data, err := os.ReadFile("./log.txt")
-	if err != nil {
-		log.Printf("读取日志文件失败: %v\n", err)
-		http.Error(w, "无法读取日志文件", http.StatusInternalServerError)
-		return
-	}
-
-	// 构建响应
-	type LogResponse struct {
-		Output string `json:"output"`
-	}
-	response := LogResponse{
-		Output: string(data),
-	}
-
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/json")
-
-	// 返回 JSON 响应
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("JSON 编码失败: %v\n", err)
-		http.Error(w, "服务器内部错误", http.StatusInternalServerError)
-	}
+// 日志接口的响应
+type LogResponse struct {
+	Output string `json:"output"`
 }
 
+// 程序最近的日志，取自内存中保留的 daemonLogBacklog 行
+func getServerLogs(w http.ResponseWriter, r *http.Request) {
+	respondData(w, r, http.StatusOK, LogResponse{Output: daemonLogBroadcaster.text()})
+}
 func getSystemLogs(w http.ResponseWriter, r *http.Request) {
 	// 调用 journalctl 命令获取系统日志
-	cmd := exec.Command("journalctl", "-n", "100") // 获取最近的 100 条日志
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := getRunner().Output(r.Context(), "journalctl", "-n", "100") // 获取最近的 100 条日志
 	if err != nil {
 		log.Printf("调用 journalctl 失败: %v\n", err)
-		http.Error(w, "无法获取系统日志", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "无法获取系统日志")
 		return
 	}
 
-	// 构建响应
-	type LogResponse struct {
-		Output string `json:"output"`
-	}
-	response := LogResponse{
-		Output: out.String(),
-	}
-
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/json")
-
-	// 返回 JSON 响应
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("JSON 编码失败: %v\n", err)
-		http.Error(w, "服务器内部错误", http.StatusInternalServerError)
-	}
+	respondData(w, r, http.StatusOK, LogResponse{Output: string(out)})
 }
 
-// 鉴权中间件
-func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// 鉴权中间件，rt.Role 为访问该路由所需的最低角色
+func authMiddleware(rt *apiRoute, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// 脚本使用 API Key 访问
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			apiKeyMiddleware(key, rt, next)(w, r)
+			return
+		}
+
 		// 从 Header 获取 Token
 		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" && rt.TokenQuery {
+			tokenString = r.URL.Query().Get("token")
+		}
 		if tokenString == "" {
-			log.Println(r.URL, "No token!  ", *staticFileDir)
-			http.ServeFile(w, r, *staticFileDir+"/login.html")
+			log.Println(r.URL, "No token!")
+			if isAPIRequest(r) || rt.TokenQuery {
+				auditDenied(rt, r, "", "token", http.StatusUnauthorized, "authentication required")
+				respondError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Authentication required")
+				return
+			}
+			serveStaticFile(w, r, "login.html")
 			return
 		}
 
-		// 解析验证 Token
-		claims, err := validateToken(tokenString)
+		// 解析验证 Token，每次请求都读取用户当前的角色，删除或降级用户后立即生效
+		claims, user, err := validateTokenUser(tokenString)
 		if err != nil {
-			log.Println("Invalid token")
-			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+			log.Println("Invalid token:", err)
+			auditDenied(rt, r, "", "token", http.StatusUnauthorized, "invalid token")
+			respondErrorJSON(w, r, http.StatusUnauthorized, errCodeInvalidToken, "Invalid token", nil)
+			return
+		}
+		if !checkUserAccess(w, r, user, rt, "token") {
 			return
 		}
 
-		// 将用户名存入请求上下文
+		if claims.SessionID != "" {
+			touchSession(claims.SessionID, r)
+		}
+
+		// 将用户名和角色存入请求上下文
 		ctx := context.WithValue(r.Context(), "username", claims.Subject)
+		ctx = context.WithValue(ctx, "role", user.Role)
+		ctx = context.WithValue(ctx, "claims", claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
-func handleAuthRoute(pattern string, handler http.HandlerFunc) {
-	http.HandleFunc(pattern, authMiddleware(handler))
-}
+// API Key 以创建者的身份访问，除角色外还要检查 Key 的范围
+func apiKeyMiddleware(key string, rt *apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		k, err := validateAPIKey(key, r)
+		if err != nil {
+			log.Printf("invalid api key: ip=%s path=%s", clientIP(r), r.URL.Path)
+			auditDenied(rt, r, "", "apikey", http.StatusUnauthorized, "invalid api key")
+			respondError(w, r, http.StatusUnauthorized, errCodeInvalidAPIKey, "Invalid API key")
+			return
+		}
+		auth := "apikey:" + k.ID
+		user, err := findUser(k.Owner)
+		if err != nil {
+			auditDenied(rt, r, k.Owner, auth, http.StatusUnauthorized, "api key owner not found")
+			respondError(w, r, http.StatusUnauthorized, errCodeInvalidAPIKey, "Invalid API key")
+			return
+		}
+		if !k.allows(rt.Scope) {
+			log.Printf("%s: api key %s (%s) scopes %v do not include this route", r.URL.Path, k.ID, k.Name, k.Scopes)
+			auditDenied(rt, r, user.Username, auth, http.StatusForbidden, "scope denied")
+			respondError(w, r, http.StatusForbidden, errCodeScopeDenied, "API key scope does not allow this route")
+			return
+		}
+		if !checkUserAccess(w, r, user, rt, auth) {
+			return
+		}
 
-func httpSwitchLed(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		ctx := context.WithValue(r.Context(), "username", user.Username)
+		ctx = context.WithValue(ctx, "role", user.Role)
+		ctx = context.WithValue(ctx, "apikey", k)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
 
-	switch r.Method {
-	case http.MethodGet:
-		handleGetStatus(w)
-	case http.MethodPost:
-		handlePostRequest(w, r)
-	default:
-		sendErrorResponse(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+// 检查用户是否需要先修改密码以及角色是否满足要求，不满足时返回 403。auth 为审计日志中的认证方式
+func checkUserAccess(w http.ResponseWriter, r *http.Request, user *User, rt *apiRoute, auth string) bool {
+	if user.MustChangePassword && !rt.AllowPasswordChange {
+		auditDenied(rt, r, user.Username, auth, http.StatusForbidden, "password change required")
+		respondError(w, r, http.StatusForbidden, errCodePasswordChange, "Password change required")
+		return false
+	}
+	if !user.Role.allows(rt.Role) {
+		log.Printf("%s: user %s (%s) requires role %s", r.URL.Path, user.Username, user.Role, rt.Role)
+		auditDenied(rt, r, user.Username, auth, http.StatusForbidden, "permission denied")
+		respondError(w, r, http.StatusForbidden, errCodeForbidden, "Permission denied")
+		return false
 	}
+	return true
 }
 
-var staticFileDir *string
+var (
+	staticFileDir  *string
+	configFilePath string
+)
 
-func main() {
+// 加载配置文件并合并命令行参数
+func loadAppConfig(configPath string) (*Config, error) {
+	cfg, err := loadConfigFile(configPath)
+	if os.IsNotExist(err) {
+		// 没有配置文件时使用默认配置，并关闭依赖配置的 MQTT
+		log.Printf("配置文件 %s 不存在，使用默认配置", configPath)
+		cfg = defaultConfig()
+		cfg.Features.MQTT = false
+		cfg.normalize()
+	} else if err != nil {
+		return nil, err
+	}
 
-	configPath := flag.String("c", defaultConfigFile, "配置文件路径 (JSON 格式)")
-	staticFileDir = flag.String("s", "./public", "静态文件目录")
-	flag.Parse()
-	ledInit()
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, *staticFileDir+"/index.html")
-	})
-	// 配置静态文件服务
-	fs := http.FileServer(http.Dir(*staticFileDir))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-	// 其他路由配置
-	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, *staticFileDir+"/images/favicon.ico")
+	// 命令行显式指定的参数优先于配置文件
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "s":
+			cfg.StaticDir = *staticFileDir
+		case "l":
+			cfg.Listen = f.Value.String()
+		case "root":
+			cfg.Root = f.Value.String()
+		}
 	})
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 使用 cfg 初始化各模块，创建包含全部页面、接口和 WebSocket 路由的 Handler。
+// 外部命令和宿主机文件分别通过 getRunner() 和 cfg.Root 访问，测试时可以整体替换
+func newHandler(cfg *Config) (http.Handler, error) {
+	setConfig(cfg)
+	exportHostPaths()
+	// 在沙箱目录中运行时数据目录通常还不存在
+	if err := os.MkdirAll(dataPath(), 0755); err != nil {
+		return nil, err
+	}
+	initStatic(cfg.StaticDir)
+	if err := initStore(); err != nil {
+		return nil, fmt.Errorf("打开状态数据库失败: %w", err)
+	}
 	initUser()
 	initLogin()
-	handleAuthRoute("/serverlogs", getServerLogs)
-	handleAuthRoute("/systemlogs", getSystemLogs)
-	handleAuthRoute("/netstatus", netStauts)
-	handleAuthRoute("/ledstatus", httpSwitchLed)
-	handleAuthRoute("/version", versionHandler)
-	initServiceMgr()
+	initAPIKeys()
 	initAdvance()
-	initWifiMgr()
-	sysconfigInit()
-	startWebSocket()
-	go HaPerMonitor(*configPath)
-	go updateLed()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		serveStaticFile(w, r, "index.html")
+	})
+	// 配置静态文件服务
+	mux.HandleFunc("/static/", staticHandler)
+	// 其他路由配置
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		serveStaticFile(w, r, "images/favicon.ico")
+	})
+	registerRoutes(mux, apiRouteTable())
+	return mux, nil
+}
+
+// 创建 HTTP 服务，启用 HTTPS 时同时监听 HTTPS 端口，HTTP 端口可选择只做重定向
+func newHTTPServers(ctx context.Context, cfg *Config, handler http.Handler) []*http.Server {
+	// 请求的 context 继承根 context，处理中的请求结束后取消，WebSocket 等长连接随之退出
+	baseContext := func(net.Listener) context.Context { return ctx }
+
+	plain := &http.Server{Addr: cfg.Listen, Handler: handler, BaseContext: baseContext}
+	if !cfg.TLS.Enabled {
+		return []*http.Server{plain}
+	}
+	if cfg.TLS.RedirectHTTP {
+		plain.Handler = redirectToHTTPS(cfg.TLS.Listen)
+	}
+	secure := &http.Server{
+		Addr:        cfg.TLS.Listen,
+		Handler:     handler,
+		BaseContext: baseContext,
+		TLSConfig:   newTLSConfig(),
+	}
+	return []*http.Server{plain, secure}
+}
+
+// 按顺序退出：升级过程中拒绝退出（再次收到信号时强制退出），然后停止接受新请求并等待处理中的请求结束，
+// 最后取消根 context，结束 WebSocket 连接和后台任务。日志流不会自己结束，开始退出时先关闭
+func shutdownServers(servers []*http.Server, cancel context.CancelFunc, force <-chan os.Signal) {
+	waitForUpgrade(force)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	stopLogStreams()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP 服务 %s 退出失败: %v", server.Addr, err)
+		}
+	}
+	cancel()
+}
+
+func main() {
+
+	configPath := flag.String("c", defaultConfigFile, "配置文件路径 (JSON/YAML 格式)")
+	staticFileDir = flag.String("s", "", "静态文件目录，默认使用内置的 Web 界面")
+	flag.String("l", ":4000", "HTTP 监听地址")
+	flag.String("root", "/", "宿主机文件系统的根目录，用于在沙箱目录中运行")
+	printConfig := flag.Bool("print-config", false, "打印合并后的生效配置并退出")
+	flag.Parse()
+
+	// 日志同时推送给订阅了 logs 主题的 WebSocket 连接
+	log.SetOutput(io.MultiWriter(os.Stderr, daemonLogBroadcaster))
+
+	configFilePath = *configPath
+	cfg, err := loadAppConfig(configFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setConfig(cfg)
+
+	if *printConfig {
+		data, err := cfg.dump(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	handler, err := newHandler(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Features.Led {
+		ledInit()
+	}
+	initReload()
 	InitSerialCommands()
-	log.Println("Starting AssistMgr on :4000")
-	log.Fatal(http.ListenAndServe(":4000", nil))
+	if err := initTLS(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	// 在启动任何服务之前注册信号，退出过程中再次收到的信号（强制退出）也不会丢失
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	// 请求和后台任务的根 context，处理中的请求结束后才取消
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	go startSubsystems(ctx, cfg)
+	go sweepLoginAttempts(ctx)
+	if cfg.Features.Led {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			updateLed(ctx)
+		}()
+	}
+
+	servers := newHTTPServers(ctx, cfg, handler)
+	serverErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			log.Println("Starting AssistMgr on", server.Addr)
+			if server.TLSConfig != nil {
+				serverErr <- server.ListenAndServeTLS("", "")
+			} else {
+				serverErr <- server.ListenAndServe()
+			}
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-sigs:
+	}
+	log.Println("收到退出信号，开始退出")
+
+	shutdownServers(servers, cancel, sigs)
+	stopSubsystems()
+	wg.Wait()
+	closeStore()
+	log.Println("AssistMgr 已退出")
 }
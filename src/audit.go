@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	auditMaxBody   = 64 << 10 // 超过该大小的请求体只记录长度
+	auditMaxResult = 256      // 失败时记录的响应内容长度
+	auditPageSize  = 50
+	auditMaxPage   = 500
+	auditMaxRows   = 50000 // 保留的记录数，由 audit_log_retention 触发器删除更早的记录，修改时需要新的表结构升级
+)
+
+// 记录参数时隐藏的字段
+var auditSensitiveKeys = map[string]bool{
+	"password":      true,
+	"oldpassword":   true,
+	"newpassword":   true,
+	"code":          true,
+	"otp":           true,
+	"refresh_token": true,
+	"key":           true,
+	"token":         true,
+}
+
+type AuditEntry struct {
+	ID       int64           `json:"id"`
+	Time     int64           `json:"time"`
+	Username string          `json:"username"`
+	Auth     string          `json:"auth"` // token、apikey:<ID> 或 password（登录）
+	Action   string          `json:"action"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params"`
+	IP       string          `json:"ip"`
+	Status   int             `json:"status"`
+	Result   string          `json:"result"`
+}
+
+// 审计日志查询结果
+type AuditPage struct {
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+// 写入一条审计记录，同时输出到日志。audit_log 表只允许追加，触发器拒绝修改和删除，
+// 只有超出 auditMaxRows 条的最早记录会在插入时被删除
+func recordAudit(e AuditEntry) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	if len(e.Params) == 0 {
+		e.Params = json.RawMessage("{}")
+	}
+	log.Printf("[AUDIT] user=%q auth=%s action=%s %s ip=%s status=%d result=%q params=%s",
+		e.Username, e.Auth, e.Method, e.Action, e.IP, e.Status, e.Result, e.Params)
+	_, err := stateDB.Exec(
+		`INSERT INTO audit_log (time, username, auth, action, method, params, ip, status, result)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time, e.Username, e.Auth, e.Action, e.Method, string(e.Params), e.IP, e.Status, e.Result,
+	)
+	if err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+	}
+}
+
+// 需要审计的请求：operator 及以上权限的路由，以及其他路由上的非 GET 请求
+func shouldAudit(rt *apiRoute, r *http.Request) bool {
+	return rt.Role.allows(RoleOperator) || (r.Method != http.MethodGet && r.Method != http.MethodHead)
+}
+
+// 记录请求参数：查询参数和 JSON 请求体（隐藏密码等字段），其他请求体只记录长度和哈希
+func auditParams(r *http.Request) json.RawMessage {
+	params := map[string]interface{}{}
+	for k, v := range r.URL.Query() {
+		if auditSensitiveKeys[strings.ToLower(k)] {
+			params[k] = "***"
+		} else {
+			params[k] = strings.Join(v, ",")
+		}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case r.Body == nil || r.ContentLength == 0:
+	case strings.Contains(contentType, "multipart/form-data") || r.ContentLength > auditMaxBody:
+		// 上传文件等大请求体不读取，避免影响处理函数
+		params["body_bytes"] = r.ContentLength
+	default:
+		body, err := io.ReadAll(io.LimitReader(r.Body, auditMaxBody+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if err != nil || len(body) > auditMaxBody {
+			params["body_bytes"] = r.ContentLength
+			break
+		}
+		var fields map[string]interface{}
+		// 不依赖 Content-Type，部分客户端发送 JSON 时没有设置
+		if json.Unmarshal(body, &fields) == nil {
+			for k, v := range fields {
+				if auditSensitiveKeys[strings.ToLower(k)] {
+					v = "***"
+				}
+				params[k] = v
+			}
+		} else {
+			sum := sha256.Sum256(body)
+			params["body_bytes"] = len(body)
+			params["body_sha256"] = hex.EncodeToString(sum[:])
+		}
+	}
+
+	data, _ := json.Marshal(params)
+	return data
+}
+
+// 记录响应状态码，失败时保留部分响应内容作为结果
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && w.body.Len() < auditMaxResult {
+		w.body.Write(b[:min(len(b), auditMaxResult-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// 支持 http.ResponseController 访问底层的 Flush 等方法
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *auditResponseWriter) result() string {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status < 400 {
+		return "ok"
+	}
+	if msg := strings.TrimSpace(w.body.String()); msg != "" {
+		return msg
+	}
+	return http.StatusText(w.status)
+}
+
+// 记录特权操作，需放在 authMiddleware 之后以获取当前用户。
+// 操作统一记录为 /api/v1 下的路径，旧路径的请求也不例外，方便按操作过滤
+func auditMiddleware(rt *apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !shouldAudit(rt, r) {
+			next(w, r)
+			return
+		}
+
+		entry := AuditEntry{
+			Username: currentUsername(r),
+			Auth:     "token",
+			Action:   apiPrefix + rt.Path,
+			Method:   r.Method,
+			Params:   auditParams(r),
+			IP:       clientIP(r),
+		}
+		if k, ok := r.Context().Value("apikey").(*APIKey); ok {
+			entry.Auth = "apikey:" + k.ID
+		}
+
+		aw := &auditResponseWriter{ResponseWriter: w}
+		defer func() {
+			entry.Result = aw.result()
+			entry.Status = aw.status
+			recordAudit(entry)
+		}()
+		next(aw, r)
+	}
+}
+
+// 记录鉴权阶段拒绝的请求。鉴权在 auditMiddleware 之前执行，被拒绝的请求不会经过它。
+// 与 auditMiddleware 一样只记录需要审计的请求，过期 Token 的状态轮询不会写入审计日志
+func auditDenied(rt *apiRoute, r *http.Request, username, auth string, status int, result string) {
+	if !shouldAudit(rt, r) {
+		return
+	}
+	recordAudit(AuditEntry{
+		Username: username,
+		Auth:     auth,
+		Action:   apiPrefix + rt.Path,
+		Method:   r.Method,
+		Params:   auditParams(r),
+		IP:       clientIP(r),
+		Status:   status,
+		Result:   result,
+	})
+}
+
+// 查询审计日志，支持按用户、操作、IP、结果和时间范围过滤，按时间倒序分页
+func auditQueryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var where []string
+	var args []interface{}
+	if v := q.Get("user"); v != "" {
+		where = append(where, "username = ?")
+		args = append(args, v)
+	}
+	if v := q.Get("action"); v != "" {
+		// 前缀匹配，例如 action=/api/v1/services/ 匹配所有服务操作
+		where = append(where, "action LIKE ? ESCAPE '\\'")
+		args = append(args, strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(v)+"%")
+	}
+	if v := q.Get("ip"); v != "" {
+		where = append(where, "ip = ?")
+		args = append(args, v)
+	}
+	switch v := q.Get("result"); v {
+	case "":
+	case "ok":
+		where = append(where, "status < 400")
+	case "error":
+		where = append(where, "status >= 400")
+	default:
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "result must be ok or error")
+		return
+	}
+	for _, f := range []struct{ name, cond string }{{"since", "time >= ?"}, {"until", "time < ?"}} {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		t, err := parseTimeParam(v)
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid "+f.name)
+			return
+		}
+		where = append(where, f.cond)
+		args = append(args, t)
+	}
+
+	limit, offset := auditPageSize, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, auditMaxPage)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid offset")
+			return
+		}
+		offset = n
+	}
+
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := stateDB.QueryRow("SELECT COUNT(*) FROM audit_log"+cond, args...).Scan(&total); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	rows, err := stateDB.Query(
+		"SELECT id, time, username, auth, action, method, params, ip, status, result FROM audit_log"+
+			cond+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var params string
+		if err := rows.Scan(&e.ID, &e.Time, &e.Username, &e.Auth, &e.Action, &e.Method, &params, &e.IP, &e.Status, &e.Result); err != nil {
+			respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		e.Params = json.RawMessage(params)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	respondData(w, r, http.StatusOK, AuditPage{
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Entries: entries,
+	})
+}
+
+// 时间参数支持 Unix 秒和 RFC 3339
+func parseTimeParam(v string) (int64, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 默认参数文件路径
+const defaultConfigFile = "./HaPerfMonitor_config.json"
+
+// 功能开关
+type FeatureConfig struct {
+	MQTT   bool `json:"mqtt" yaml:"mqtt"`     // Home Assistant MQTT 集成
+	Serial bool `json:"serial" yaml:"serial"` // 串口命令行
+	Led    bool `json:"led" yaml:"led"`       // LED 状态指示
+	// 记录系统指标历史
+	MetricsHistory bool `json:"metrics_history" yaml:"metrics_history"`
+}
+
+// HTTPS 监听配置，证书保存在 data_dir/tls 下，首次启动时自动生成自签名证书
+type TLSConfig struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	Listen       string `json:"listen" yaml:"listen"`               // HTTPS 监听地址
+	RedirectHTTP bool   `json:"redirect_http" yaml:"redirect_http"` // HTTP 请求重定向到 HTTPS
+}
+
+// Prometheus /metrics 接口，Token 非空时需要 Authorization: Bearer <token>
+type PrometheusConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Token   string `json:"token" yaml:"token"`
+}
+
+// 守护进程配置，MQTT 字段保持原有的扁平格式以兼容旧配置文件
+type Config struct {
+	Server   string `json:"server" yaml:"server"`
+	Port     string `json:"port" yaml:"port"`
+	User     string `json:"user" yaml:"user"`
+	Pass     string `json:"pass" yaml:"pass"`
+	ClientID string `json:"client_id" yaml:"client_id"`
+
+	Root       string `json:"root" yaml:"root"`               // 宿主机文件系统的根目录，/sys、/proc 和数据目录等都在该目录下访问
+	Listen     string `json:"listen" yaml:"listen"`           // HTTP 监听地址
+	StaticDir  string `json:"static_dir" yaml:"static_dir"`   // 静态文件目录，为空时使用内置的 Web 界面
+	DataDir    string `json:"data_dir" yaml:"data_dir"`       // 持久化数据目录
+	WlanIface  string `json:"wlan_iface" yaml:"wlan_iface"`   // 上网使用的无线网卡
+	ApIface    string `json:"ap_iface" yaml:"ap_iface"`       // 热点使用的无线网卡
+	SerialDev  string `json:"serial_dev" yaml:"serial_dev"`   // 串口设备
+	UpgradeDir string `json:"upgrade_dir" yaml:"upgrade_dir"` // 升级包存放目录，为空时使用 data_dir/upgrades
+	FrpcConfig string `json:"frpc_config" yaml:"frpc_config"` // frpc 配置文件
+	PingHost   string `json:"ping_host" yaml:"ping_host"`     // 网络检测使用的主机
+
+	SampleInterval int `json:"sample_interval" yaml:"sample_interval"` // 系统信息采样间隔，秒
+
+	Features   FeatureConfig    `json:"features" yaml:"features"`
+	TLS        TLSConfig        `json:"tls" yaml:"tls"`
+	Prometheus PrometheusConfig `json:"prometheus" yaml:"prometheus"`
+}
+
+// 当前生效的配置，重载时整体替换，不要原地修改
+var (
+	appConfigLock sync.RWMutex
+	appConfig     = defaultConfig()
+)
+
+func getConfig() *Config {
+	appConfigLock.RLock()
+	defer appConfigLock.RUnlock()
+	return appConfig
+}
+
+func setConfig(cfg *Config) {
+	appConfigLock.Lock()
+	defer appConfigLock.Unlock()
+	appConfig = cfg
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Root:       "/",
+		Listen:     ":4000",
+		DataDir:    "/mnt/data",
+		WlanIface:  "wlan0",
+		ApIface:    "wlan1",
+		SerialDev:  "/dev/ttyGS0",
+		FrpcConfig: "/opt/config/frp/frpc.toml",
+		PingHost:   "www.baidu.com",
+
+		SampleInterval: 5,
+		Features: FeatureConfig{
+			MQTT:   true,
+			Serial: true,
+			Led:    true,
+
+			MetricsHistory: true,
+		},
+		TLS: TLSConfig{
+			Listen: ":4443",
+		},
+	}
+}
+
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// 读取配置文件，未出现在文件中的字段保留默认值
+func loadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if isYAMLFile(path) {
+		err = yaml.Unmarshal(data, cfg)
+	} else {
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	cfg.normalize()
+	return cfg, nil
+}
+
+// 补全派生字段
+func (c *Config) normalize() {
+	if c.UpgradeDir == "" {
+		c.UpgradeDir = filepath.Join(c.DataDir, "upgrades")
+	}
+}
+
+// 校验配置，返回所有错误
+func (c *Config) validate() error {
+	var errs []string
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Sprintf("listen %q 无效: %v", c.Listen, err))
+	}
+	if c.TLS.Enabled {
+		if _, _, err := net.SplitHostPort(c.TLS.Listen); err != nil {
+			errs = append(errs, fmt.Sprintf("tls.listen %q 无效: %v", c.TLS.Listen, err))
+		} else if c.TLS.Listen == c.Listen {
+			errs = append(errs, "tls.listen 不能与 listen 相同")
+		}
+	}
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Sprintf("static_dir %q 不是有效的目录", c.StaticDir))
+		}
+	}
+	if !filepath.IsAbs(c.Root) {
+		errs = append(errs, fmt.Sprintf("root %q 必须是绝对路径", c.Root))
+	} else if info, err := os.Stat(c.Root); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Sprintf("root %q 不是有效的目录", c.Root))
+	}
+	if !filepath.IsAbs(c.DataDir) {
+		errs = append(errs, fmt.Sprintf("data_dir %q 必须是绝对路径", c.DataDir))
+	} else if systemDirs[filepath.Clean(c.DataDir)] {
+		// 恢复出厂设置会清空 data_dir
+		errs = append(errs, fmt.Sprintf("data_dir %q 不能是系统目录", c.DataDir))
+	}
+	if !filepath.IsAbs(c.UpgradeDir) {
+		errs = append(errs, fmt.Sprintf("upgrade_dir %q 必须是绝对路径", c.UpgradeDir))
+	}
+	if c.FrpcConfig == "" {
+		errs = append(errs, "frpc_config 不能为空")
+	}
+	if c.PingHost == "" {
+		errs = append(errs, "ping_host 不能为空")
+	}
+	if c.SampleInterval < 1 {
+		errs = append(errs, fmt.Sprintf("sample_interval %d 无效，至少为 1 秒", c.SampleInterval))
+	}
+	if c.WlanIface == "" {
+		errs = append(errs, "wlan_iface 不能为空")
+	}
+	if c.ApIface == "" {
+		errs = append(errs, "ap_iface 不能为空")
+	}
+	if c.Features.Serial && c.SerialDev == "" {
+		errs = append(errs, "已启用串口功能，但 serial_dev 为空")
+	}
+	if c.Features.MQTT {
+		if c.Server == "" {
+			errs = append(errs, "已启用 MQTT 功能，但 server 为空")
+		}
+		if _, err := strconv.Atoi(c.Port); err != nil {
+			errs = append(errs, fmt.Sprintf("MQTT port %q 无效", c.Port))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("配置校验失败:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// 输出当前生效的配置，密码做脱敏处理
+func (c *Config) dump(path string) ([]byte, error) {
+	masked := *c
+	if masked.Pass != "" {
+		masked.Pass = "******"
+	}
+	if masked.Prometheus.Token != "" {
+		masked.Prometheus.Token = "******"
+	}
+	if isYAMLFile(path) {
+		return yaml.Marshal(&masked)
+	}
+	return json.MarshalIndent(&masked, "", "  ")
+}
+
+// 不能作为 data_dir 的目录
+var systemDirs = map[string]bool{
+	"/": true, "/bin": true, "/boot": true, "/dev": true, "/etc": true, "/home": true,
+	"/lib": true, "/lib64": true, "/mnt": true, "/opt": true, "/proc": true, "/root": true,
+	"/run": true, "/sbin": true, "/srv": true, "/sys": true, "/tmp": true, "/usr": true, "/var": true,
+}
+
+// 数据目录下的文件路径，已按根目录转换
+func dataPath(name ...string) string {
+	return sysFS().Path(filepath.Join(append([]string{getConfig().DataDir}, name...)...))
+}
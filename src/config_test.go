@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigRejectsSystemDataDir(t *testing.T) {
+	for _, dir := range []string{"/", "/etc", "/usr/", "//var"} {
+		cfg := defaultConfig()
+		cfg.Root = t.TempDir()
+		cfg.DataDir = dir
+		cfg.normalize()
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "data_dir") {
+			t.Errorf("data_dir %q: validate() = %v", dir, err)
+		}
+	}
+}
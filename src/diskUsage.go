@@ -1,9 +1,8 @@
 package main
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
+	"context"
+	"log"
 	"strconv"
 	"strings"
 
@@ -11,7 +10,7 @@ import (
 )
 
 func getDiskUsage() map[string]float64 {
-	mountPointAll := []string{"/mnt/data", "/"} // 替换为你的挂载点
+	mountPointAll := []string{getConfig().DataDir, "/"}
 	var mountPoint string
 	diskUage := make(map[string]float64)
 	// 获取磁盘使用情况
@@ -19,7 +18,7 @@ func getDiskUsage() map[string]float64 {
 
 	partitions, err := disk.Partitions(false)
 	if err != nil {
-		fmt.Printf("获取分区信息失败: %v\n", err)
+		log.Printf("获取分区信息失败: %v", err)
 		return nil
 	}
 
@@ -54,15 +53,14 @@ func getDiskUsage() map[string]float64 {
 		}
 	}
 	if device == "" {
-		fmt.Println("未找到挂载点对应的设备")
+		log.Println("未找到挂载点对应的设备")
 		return nil
 	}
 
 	// 使用 lsblk -no pkname 获取物理磁盘名
-	cmd := exec.Command("lsblk", "-no", "pkname", device)
-	output, err := cmd.Output()
+	output, err := getRunner().Output(context.Background(), "lsblk", "-no", "pkname", device)
 	if err != nil {
-		fmt.Printf("lsblk 命令执行失败: %v\n", err)
+		log.Printf("lsblk 命令执行失败: %v", err)
 		return nil
 	}
 	phyDisk := "/dev/" + string(output)
@@ -70,15 +68,15 @@ func getDiskUsage() map[string]float64 {
 
 	diskName := strings.TrimPrefix(phyDisk, "/dev/")
 	sizePath := "/sys/block/" + diskName + "/size"
-	data, err := os.ReadFile(sizePath)
+	data, err := sysFS().ReadFile(sizePath)
 	if err != nil {
-		fmt.Printf("读取物理磁盘大小失败: %v\n", err)
+		log.Printf("读取物理磁盘大小失败: %v", err)
 		return nil
 	}
 	sectorsStr := strings.TrimSpace(string(data))
 	sectors, err := strconv.ParseUint(sectorsStr, 10, 64)
 	if err != nil {
-		fmt.Printf("解析磁盘扇区数失败: %v\n", err)
+		log.Printf("解析磁盘扇区数失败: %v", err)
 		return nil
 	}
 	totalBytes := sectors * 512
@@ -0,0 +1,980 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 进程内启动完整的路由：命令使用 fakeRunner，宿主机文件和数据目录都在临时目录下
+type testServer struct {
+	t      *testing.T
+	srv    *httptest.Server
+	runner *fakeRunner
+	root   string
+	token  string
+}
+
+const testPassword = "Integration#2024"
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	root := t.TempDir()
+	writeFixture(t, root, "/proc/net/dev", procNetDev)
+	writeFixture(t, root, "/etc/os-release", "PRETTY_NAME=\"Test OS\"\nVERSION_ID=\"1\"\n")
+
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Features = FeatureConfig{}
+	cfg.normalize()
+
+	// newHandler 会替换全局配置并设置 gopsutil 的环境变量，测试结束后恢复
+	old := getConfig()
+	t.Cleanup(func() { setConfig(old) })
+	for _, env := range []string{"HOST_PROC", "HOST_SYS", "HOST_ETC"} {
+		t.Setenv(env, os.Getenv(env))
+	}
+
+	resetLoginAttempts()
+	t.Cleanup(resetLoginAttempts)
+
+	ts := &testServer{t: t, runner: newFakeRunner(t), root: root}
+	handler, err := newHandler(cfg)
+	if err != nil {
+		t.Fatalf("newHandler: %v", err)
+	}
+	ts.srv = httptest.NewServer(handler)
+	t.Cleanup(ts.srv.Close)
+	t.Cleanup(closeStore)
+	return ts
+}
+
+// 登录失败记录是进程级的，每个测试重新开始
+func resetLoginAttempts() {
+	loginAttemptMutex.Lock()
+	defer loginAttemptMutex.Unlock()
+	loginAttempts = map[string]*loginAttempt{}
+}
+
+func writeFixture(t *testing.T, root, name, content string) {
+	t.Helper()
+	p := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 发送请求，已登录时带上 Token
+func (ts *testServer) do(method, path, contentType string, body io.Reader) *http.Response {
+	ts.t.Helper()
+	req, err := http.NewRequest(method, ts.srv.URL+path, body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ts.token != "" {
+		req.Header.Set("Authorization", ts.token)
+	}
+	resp, err := ts.srv.Client().Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp
+}
+
+// 发送 JSON 请求并解析响应，返回状态码
+func (ts *testServer) json(method, path string, in, out interface{}) int {
+	ts.t.Helper()
+	var body io.Reader
+	if in != nil {
+		data, _ := json.Marshal(in)
+		body = bytes.NewReader(data)
+	}
+	resp := ts.do(method, path, "application/json", body)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			ts.t.Fatalf("%s %s: 解析响应失败: %v, 响应: %s", method, path, err, data)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (ts *testServer) text(method, path, contentType string, body io.Reader) (int, string) {
+	ts.t.Helper()
+	resp := ts.do(method, path, contentType, body)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// 使用默认管理员登录，修改默认密码后重新登录
+func (ts *testServer) login() {
+	ts.t.Helper()
+	var resp LoginResponse
+	if code := ts.json(http.MethodPost, "/login", LoginRequest{Username: "admin", Password: defaultPassword}, &resp); code != http.StatusOK {
+		ts.t.Fatalf("登录失败: %d", code)
+	}
+	if !resp.MustChangePassword {
+		ts.t.Fatal("默认密码登录后应要求修改密码")
+	}
+	ts.token = resp.Token
+
+	// 修改密码前不能访问其他接口
+	if code := ts.json(http.MethodGet, "/services", nil, nil); code != http.StatusForbidden {
+		ts.t.Fatalf("修改密码前访问 /services 返回 %d，期望 403", code)
+	}
+
+	var changed PasswordChangeResponse
+	req := PasswordChangeRequest{OldPassword: defaultPassword, NewPassword: testPassword}
+	if code := ts.json(http.MethodPost, "/change-password", req, &changed); code != http.StatusOK {
+		ts.t.Fatalf("修改密码失败: %d", code)
+	}
+
+	ts.token = ""
+	resp = LoginResponse{}
+	if code := ts.json(http.MethodPost, "/login", LoginRequest{Username: "admin", Password: testPassword}, &resp); code != http.StatusOK {
+		ts.t.Fatalf("使用新密码登录失败: %d", code)
+	}
+	if resp.Role != RoleAdmin || resp.MustChangePassword {
+		ts.t.Fatalf("登录结果不正确: %+v", resp)
+	}
+	ts.token = resp.Token
+}
+
+func TestIntegrationAuth(t *testing.T) {
+	ts := newTestServer(t)
+
+	// 未登录时旧路径返回登录页面，/api/v1 返回 401
+	code, body := ts.text(http.MethodGet, "/services", "", nil)
+	if code != http.StatusOK || !strings.Contains(body, "<html") {
+		t.Errorf("未登录访问 /services: %d %.80q", code, body)
+	}
+	var apiResp apiResponse
+	if code := ts.json(http.MethodGet, "/api/v1/services", nil, &apiResp); code != http.StatusUnauthorized || apiResp.Error == nil || apiResp.Error.Code != errCodeUnauthorized {
+		t.Errorf("未登录访问 /api/v1/services: %d %+v", code, apiResp.Error)
+	}
+
+	// 错误的密码
+	var errResp map[string]interface{}
+	if code := ts.json(http.MethodPost, "/login", LoginRequest{Username: "admin", Password: "wrong"}, &errResp); code != http.StatusUnauthorized || errResp["error"] != "Invalid credentials" {
+		t.Errorf("错误密码登录: %d %v", code, errResp)
+	}
+	// 失败后需要等待一段时间才能再次尝试
+	if code := ts.json(http.MethodPost, "/login", LoginRequest{Username: "admin", Password: defaultPassword}, &errResp); code != http.StatusTooManyRequests || errResp["retry_after"] == nil {
+		t.Errorf("失败后立即登录: %d %v", code, errResp)
+	}
+	resetLoginAttempts()
+
+	// 用户不存在时的响应与密码错误相同，不能据此判断用户名是否存在
+	_, wrongPassword := ts.text(http.MethodPost, "/login", "application/json", strings.NewReader(`{"username":"admin","password":"wrong"}`))
+	resetLoginAttempts()
+	code, unknownUser := ts.text(http.MethodPost, "/login", "application/json", strings.NewReader(`{"username":"nobody","password":"wrong"}`))
+	if code != http.StatusUnauthorized || unknownUser != wrongPassword {
+		t.Errorf("不存在的用户登录: %d %q，密码错误时为 %q", code, unknownUser, wrongPassword)
+	}
+	resetLoginAttempts()
+
+	// 伪造的 Token
+	ts.token = "not-a-token"
+	if code := ts.json(http.MethodGet, "/api/v1/services", nil, &apiResp); code != http.StatusUnauthorized {
+		t.Errorf("伪造的 Token 返回 %d，期望 401", code)
+	}
+
+	ts.login()
+
+	// 不能使用默认密码创建用户
+	if code := ts.json(http.MethodPost, "/api/v1/users/add", UserRequest{Username: "weak", Password: defaultPassword}, nil); code != http.StatusBadRequest {
+		t.Errorf("使用默认密码创建用户返回 %d，期望 400", code)
+	}
+
+	// 创建 viewer 用户，验证角色检查
+	user := UserRequest{Username: "viewer1", Password: testPassword, Role: RoleViewer}
+	if code := ts.json(http.MethodPost, "/api/v1/users/add", user, nil); code != http.StatusOK {
+		t.Fatalf("创建用户失败: %d", code)
+	}
+	adminToken := ts.token
+	var viewer LoginResponse
+	ts.token = ""
+	ts.json(http.MethodPost, "/api/v1/auth/login", LoginRequest{Username: "viewer1", Password: testPassword}, &apiResponse{Data: &viewer})
+	if viewer.Token == "" {
+		t.Fatal("viewer 登录失败")
+	}
+	ts.token = viewer.Token
+	if code := ts.json(http.MethodGet, "/api/v1/upgrade", nil, nil); code != http.StatusOK {
+		t.Errorf("viewer 查询升级进度返回 %d", code)
+	}
+	if code := ts.json(http.MethodPost, "/api/v1/system/reboot", nil, &apiResp); code != http.StatusForbidden || apiResp.Error.Code != errCodeForbidden {
+		t.Errorf("viewer 重启系统返回 %d %+v，期望 403", code, apiResp.Error)
+	}
+	for _, cmd := range ts.runner.commands() {
+		if cmd == "reboot" {
+			t.Error("没有权限时不应执行 reboot")
+		}
+	}
+
+	// 鉴权阶段拒绝的请求同样写入审计日志
+	ts.token = adminToken
+	var audit AuditPage
+	if code := ts.json(http.MethodGet, "/api/v1/audit?result=error&action=/api/v1/system/reboot", nil, &apiResponse{Data: &audit}); code != http.StatusOK {
+		t.Fatalf("查询审计日志: %d", code)
+	}
+	if len(audit.Entries) != 1 || audit.Entries[0].Username != "viewer1" || audit.Entries[0].Status != http.StatusForbidden || audit.Entries[0].Auth != "token" {
+		t.Errorf("拒绝的重启请求的审计记录 = %+v", audit.Entries)
+	}
+
+	// 只修改邮箱不影响已登录的会话，修改角色后原来的访问 Token 和刷新 Token 都失效
+	if code := ts.json(http.MethodPost, "/api/v1/users/update", UserRequest{Username: "viewer1", Email: "v@example.com"}, nil); code != http.StatusOK {
+		t.Fatalf("修改邮箱失败: %d", code)
+	}
+	ts.token = viewer.Token
+	if code := ts.json(http.MethodGet, "/api/v1/upgrade", nil, nil); code != http.StatusOK {
+		t.Errorf("修改邮箱后 viewer 的 Token 返回 %d", code)
+	}
+	ts.token = adminToken
+	if code := ts.json(http.MethodPost, "/api/v1/users/update", UserRequest{Username: "viewer1", Role: RoleOperator}, nil); code != http.StatusOK {
+		t.Fatalf("修改角色失败: %d", code)
+	}
+	ts.token = viewer.Token
+	if code := ts.json(http.MethodGet, "/api/v1/upgrade", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("修改角色后旧 Token 返回 %d，期望 401", code)
+	}
+	ts.token = ""
+	if code := ts.json(http.MethodPost, "/api/v1/auth/refresh", RefreshRequest{RefreshToken: viewer.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("修改角色后刷新 Token 返回 %d，期望 401", code)
+	}
+
+	// 删除用户后以同名重新创建，之前的访问 Token 和刷新 Token 不能用于新用户
+	ts.token = adminToken
+	if code := ts.json(http.MethodPost, "/api/v1/users/add", UserRequest{Username: "gone", Password: testPassword, Role: RoleViewer}, nil); code != http.StatusOK {
+		t.Fatalf("创建用户失败: %d", code)
+	}
+	var gone LoginResponse
+	ts.token = ""
+	ts.json(http.MethodPost, "/api/v1/auth/login", LoginRequest{Username: "gone", Password: testPassword}, &apiResponse{Data: &gone})
+	if gone.Token == "" {
+		t.Fatal("gone 登录失败")
+	}
+	ts.token = adminToken
+	if code := ts.json(http.MethodPost, "/api/v1/users/delete", UserRequest{Username: "gone"}, nil); code != http.StatusOK {
+		t.Fatalf("删除用户失败: %d", code)
+	}
+	if code := ts.json(http.MethodPost, "/api/v1/users/add", UserRequest{Username: "gone", Password: testPassword, Role: RoleAdmin}, nil); code != http.StatusOK {
+		t.Fatalf("重新创建用户失败: %d", code)
+	}
+	ts.token = gone.Token
+	if code := ts.json(http.MethodGet, "/api/v1/upgrade", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("删除前签发的 Token 返回 %d，期望 401", code)
+	}
+	ts.token = ""
+	if code := ts.json(http.MethodPost, "/api/v1/auth/refresh", RefreshRequest{RefreshToken: gone.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("删除前签发的刷新 Token 返回 %d，期望 401", code)
+	}
+
+	// API Key：格式错误、范围不足、撤销以及创建者被删除后都不能访问
+	ts.token = adminToken
+	if code := ts.json(http.MethodPost, "/api/v1/users/add", UserRequest{Username: "keyowner", Password: testPassword, Role: RoleAdmin}, nil); code != http.StatusOK {
+		t.Fatalf("创建用户失败: %d", code)
+	}
+	var owner LoginResponse
+	ts.token = ""
+	ts.json(http.MethodPost, "/api/v1/auth/login", LoginRequest{Username: "keyowner", Password: testPassword}, &apiResponse{Data: &owner})
+	if owner.Token == "" {
+		t.Fatal("keyowner 登录失败")
+	}
+	newKey := func() APIKeyCreated {
+		t.Helper()
+		var created APIKeyCreated
+		ts.token = owner.Token
+		if code := ts.json(http.MethodPost, "/api/v1/apikeys/add", APIKeyRequest{Name: "ci", Scopes: []string{"upgrade"}}, &apiResponse{Data: &created}); code != http.StatusOK {
+			t.Fatalf("创建 API Key 返回 %d", code)
+		}
+		ts.token = ""
+		return created
+	}
+	withKey := func(key, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.srv.URL+path, nil)
+		req.Header.Set(apiKeyHeader, key)
+		resp, err := ts.srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out apiResponse
+		json.NewDecoder(resp.Body).Decode(&out)
+		if out.Error == nil {
+			return resp.StatusCode, ""
+		}
+		return resp.StatusCode, out.Error.Code
+	}
+
+	created := newKey()
+	if !strings.HasPrefix(created.Key, apiKeyPrefix) {
+		t.Fatalf("API Key %q 缺少前缀 %s", created.Key, apiKeyPrefix)
+	}
+	if code, _ := withKey(created.Key, "/api/v1/upgrade"); code != http.StatusOK {
+		t.Errorf("API Key 访问范围内的路由返回 %d", code)
+	}
+	for _, key := range []string{
+		"amk_nosecret",
+		strings.TrimPrefix(created.Key, apiKeyPrefix) + "x",
+		created.Key + "x",
+		apiKeyPrefix + created.Info.ID + "_",
+	} {
+		if code, errCode := withKey(key, "/api/v1/upgrade"); code != http.StatusUnauthorized || errCode != errCodeInvalidAPIKey {
+			t.Errorf("无效 API Key %q 返回 %d %q，期望 401 %s", key, code, errCode, errCodeInvalidAPIKey)
+		}
+	}
+	if code, errCode := withKey(created.Key, "/api/v1/services"); code != http.StatusForbidden || errCode != errCodeScopeDenied {
+		t.Errorf("API Key 访问范围外的路由返回 %d %q，期望 403 %s", code, errCode, errCodeScopeDenied)
+	}
+
+	ts.token = owner.Token
+	if code := ts.json(http.MethodPost, "/api/v1/apikeys/revoke", IDRequest{ID: created.Info.ID}, nil); code != http.StatusOK {
+		t.Fatalf("撤销 API Key 返回 %d", code)
+	}
+	if code, errCode := withKey(created.Key, "/api/v1/upgrade"); code != http.StatusUnauthorized || errCode != errCodeInvalidAPIKey {
+		t.Errorf("撤销后的 API Key 返回 %d %q，期望 401 %s", code, errCode, errCodeInvalidAPIKey)
+	}
+
+	created = newKey()
+	ts.token = adminToken
+	if code := ts.json(http.MethodPost, "/api/v1/users/delete", UserRequest{Username: "keyowner"}, nil); code != http.StatusOK {
+		t.Fatalf("删除用户失败: %d", code)
+	}
+	if code, errCode := withKey(created.Key, "/api/v1/upgrade"); code != http.StatusUnauthorized || errCode != errCodeInvalidAPIKey {
+		t.Errorf("创建者被删除后 API Key 返回 %d %q，期望 401 %s", code, errCode, errCodeInvalidAPIKey)
+	}
+}
+
+// 启用两步验证后的登录流程：需要验证码、错误的验证码计入失败次数、验证码和恢复码都不能重复使用
+func TestIntegrationTOTP(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+
+	var setup TOTPSetupResponse
+	if code := ts.json(http.MethodPost, "/api/v1/2fa/setup", nil, &apiResponse{Data: &setup}); code != http.StatusOK || setup.Secret == "" {
+		t.Fatalf("/2fa/setup 返回 %d", code)
+	}
+	step := time.Now().Unix() / totpPeriod
+	otp := func(step int64) string {
+		t.Helper()
+		code, err := totpCode(setup.Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	var enabled RecoveryCodesResponse
+	if code := ts.json(http.MethodPost, "/api/v1/2fa/enable", TOTPRequest{Code: otp(step)}, &apiResponse{Data: &enabled}); code != http.StatusOK || len(enabled.RecoveryCodes) == 0 {
+		t.Fatalf("/2fa/enable 返回 %d", code)
+	}
+	ts.token = ""
+
+	login := func(otp string) (int, map[string]interface{}) {
+		t.Helper()
+		var resp map[string]interface{}
+		code := ts.json(http.MethodPost, "/login", LoginRequest{Username: "admin", Password: testPassword, OTP: otp}, &resp)
+		return code, resp
+	}
+
+	// 只提交密码时要求验证码，不计入失败次数
+	if code, resp := login(""); code != http.StatusUnauthorized || resp["totp_required"] != true {
+		t.Errorf("未提交验证码: %d %v", code, resp)
+	}
+
+	// 错误的验证码计入失败次数，之后需要等待
+	if code, resp := login(otp(step + 10)); code != http.StatusUnauthorized || resp["totp_required"] != true {
+		t.Errorf("错误的验证码: %d %v", code, resp)
+	}
+	if code, resp := login(otp(step + 1)); code != http.StatusTooManyRequests || resp["retry_after"] == nil {
+		t.Errorf("验证码错误后立即登录: %d %v", code, resp)
+	}
+	resetLoginAttempts()
+
+	// 启用时使用过的验证码不能再次使用
+	if code, resp := login(otp(step)); code != http.StatusUnauthorized {
+		t.Errorf("重放启用时的验证码: %d %v", code, resp)
+	}
+	resetLoginAttempts()
+
+	// 新的验证码只能使用一次
+	if code, resp := login(otp(step + 1)); code != http.StatusOK || resp["token"] == nil {
+		t.Fatalf("使用验证码登录: %d %v", code, resp)
+	}
+	if code, resp := login(otp(step + 1)); code != http.StatusUnauthorized {
+		t.Errorf("重放验证码: %d %v", code, resp)
+	}
+	resetLoginAttempts()
+
+	// 恢复码只能使用一次
+	recovery := enabled.RecoveryCodes[0]
+	if code, resp := login(recovery); code != http.StatusOK || resp["token"] == nil {
+		t.Fatalf("使用恢复码登录: %d %v", code, resp)
+	}
+	if code, resp := login(recovery); code != http.StatusUnauthorized {
+		t.Errorf("重复使用恢复码: %d %v", code, resp)
+	}
+	resetLoginAttempts()
+
+	user, err := findUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.RecoveryCodes) != len(enabled.RecoveryCodes)-1 || user.TOTPLastStep != step+1 {
+		t.Errorf("剩余恢复码 %d 个，最近时间步 %d，期望 %d 个、%d", len(user.RecoveryCodes), user.TOTPLastStep, len(enabled.RecoveryCodes)-1, step+1)
+	}
+}
+
+func TestIntegrationServices(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+	ts.runner.
+		on("systemctl is-enabled frpc", "enabled\n", nil).
+		on("systemctl is-active frpc", "active\n", nil).
+		on("sudo systemctl restart frpc", "", nil)
+
+	var services []Service
+	if code := ts.json(http.MethodGet, "/services", nil, &services); code != http.StatusOK {
+		t.Fatalf("/services 返回 %d", code)
+	}
+	for _, s := range services {
+		if want := s.Name == "frpc"; s.IsEnableD != want || s.IsActive != want {
+			t.Errorf("服务状态不正确: %+v", s)
+		}
+	}
+
+	code, body := ts.text(http.MethodPost, "/service/restart?name=frpc", "", nil)
+	if code != http.StatusOK || body != "服务重启成功" {
+		t.Errorf("/service/restart: %d %q", code, body)
+	}
+
+	// /api/v1 严格检查请求方法
+	var apiResp apiResponse
+	if code := ts.json(http.MethodGet, "/api/v1/services/restart?name=frpc", nil, &apiResp); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/v1/services/restart 返回 %d，期望 405", code)
+	}
+}
+
+func TestIntegrationUpgrade(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+	resetUpgradeState(t)
+
+	pkg := filepath.Join(ts.root, getConfig().UpgradeDir, "update.raucb")
+	ts.runner.on("rauc install "+pkg, raucInstallOutput, nil)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("updateFile", "update.raucb")
+	fw.Write(bytes.Repeat([]byte("bundle"), 1024))
+	mw.Close()
+
+	var status UpgradeStatus
+	resp := ts.do(http.MethodPost, "/upload_update", mw.FormDataContentType(), &body)
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || status.Status != "upload_complete" {
+		t.Fatalf("/upload_update: %d %+v", resp.StatusCode, status)
+	}
+
+	// 后台安装完成
+	var progress UpgradeProgress
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if code := ts.json(http.MethodGet, "/upgrade_progress", nil, &progress); code != http.StatusOK {
+			t.Fatalf("/upgrade_progress 返回 %d", code)
+		}
+		if progress.Status == "done" || progress.Status == "failed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待升级完成超时: %+v", progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if progress.Status != "done" || progress.Progress != 100 || len(progress.Output) == 0 {
+		t.Errorf("升级结果不正确: %+v", progress)
+	}
+	assertCommands(t, ts.runner, "rauc install "+pkg)
+	// 安装后删除升级包
+	for raucInstalling.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := os.Stat(pkg); !os.IsNotExist(err) {
+		t.Errorf("升级包没有删除: %v", err)
+	}
+}
+
+func TestIntegrationSysconfig(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+
+	const content = "serverAddr = \"frp.example.com\"\nserverPort = 7000\n"
+	code, body := ts.text(http.MethodPost, "/sysconfig/save", "text/plain", strings.NewReader(content))
+	if code != http.StatusOK {
+		t.Fatalf("/sysconfig/save: %d %s", code, body)
+	}
+	data, err := os.ReadFile(filepath.Join(ts.root, getConfig().FrpcConfig))
+	if err != nil || string(data) != content {
+		t.Errorf("配置文件内容不正确: %q, %v", data, err)
+	}
+
+	if code, body := ts.text(http.MethodGet, "/sysconfig/get", "", nil); code != http.StatusOK || body != content {
+		t.Errorf("/sysconfig/get: %d %q", code, body)
+	}
+	var cfg ConfigContent
+	if code := ts.json(http.MethodGet, "/api/v1/frpc/config", nil, &apiResponse{Data: &cfg}); code != http.StatusOK || cfg.Content != content {
+		t.Errorf("/api/v1/frpc/config: %d %q", code, cfg.Content)
+	}
+}
+
+func TestIntegrationLed(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+	setOnline(t, false)
+	ts.runner.
+		on("ifconfig", ifconfigWithIP, nil).
+		on("led-control sys_led "+LED_MODE_SLOW, "", nil).
+		on("led-control sys_led off", "", nil)
+
+	var led Ledstatus
+	if code := ts.json(http.MethodPost, "/ledstatus", Ledstatus{STATUS: "ON"}, &led); code != http.StatusOK || led.STATUS != "ON" {
+		t.Fatalf("POST /ledstatus: %d %+v", code, led)
+	}
+	if code := ts.json(http.MethodGet, "/ledstatus", nil, &led); code != http.StatusOK || led.STATUS != "ON" {
+		t.Errorf("GET /ledstatus: %d %+v", code, led)
+	}
+	if code := ts.json(http.MethodPost, "/ledstatus", Ledstatus{STATUS: "OFF"}, &led); code != http.StatusOK {
+		t.Fatalf("POST /ledstatus: %d", code)
+	}
+	if code := ts.json(http.MethodGet, "/ledstatus", nil, &led); code != http.StatusOK || led.STATUS != "OFF" {
+		t.Errorf("GET /ledstatus: %d %+v", code, led)
+	}
+	if got := getStoredLedStatus(); got != "OFF" {
+		t.Errorf("updateLed 读取到的开关为 %q", got)
+	}
+	assertCommands(t, ts.runner, "ifconfig", "led-control sys_led "+LED_MODE_SLOW, "led-control sys_led off")
+
+	var errResp struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if code := ts.json(http.MethodPost, "/ledstatus", Ledstatus{STATUS: "blink"}, &errResp); code != http.StatusBadRequest || errResp.Error.Code != http.StatusBadRequest {
+		t.Errorf("无效的状态返回 %d %+v，期望 400", code, errResp)
+	}
+}
+
+func TestIntegrationWebSocket(t *testing.T) {
+	ts := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runSystemSampler(ctx, 10*time.Millisecond)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	wsURL := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("没有 Token 时应拒绝连接: %v", err)
+	}
+
+	ts.login()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token="+ts.token, nil)
+	if err != nil {
+		t.Fatalf("WebSocket 连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var info SystemInfo
+	if err := conn.ReadJSON(&info); err != nil {
+		t.Fatalf("读取系统信息失败: %v", err)
+	}
+	if info.CPUUsage < 0 || info.MemUsage < 0 || info.MemUsage > 100 || info.Time == 0 {
+		t.Errorf("系统信息不正确: %+v", info)
+	}
+
+	// 其他连接订阅同一个采样
+	other, _, err := websocket.DefaultDialer.Dial(wsURL+"?token="+ts.token, nil)
+	if err != nil {
+		t.Fatalf("WebSocket 连接失败: %v", err)
+	}
+	defer other.Close()
+	other.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := other.ReadJSON(&info); err != nil {
+		t.Fatalf("读取系统信息失败: %v", err)
+	}
+
+	// /api/v1/ws 与其他接口一样可以使用 Authorization 头
+	apiConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.srv.URL, "http")+apiPrefix+"/ws",
+		http.Header{"Authorization": {ts.token}})
+	if err != nil {
+		t.Fatalf("/api/v1/ws 连接失败: %v", err)
+	}
+	apiConn.Close()
+}
+
+// 读取消息直到 match 返回 true，跳过其他消息
+func readWSMessage(t *testing.T, conn *websocket.Conn, match func(msg map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("读取消息失败: %v", err)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+// 匹配指定主题的事件，check 为 nil 时匹配该主题的任意事件
+func wsEventOf(topic string, check func(data map[string]interface{}) bool) func(map[string]interface{}) bool {
+	return func(msg map[string]interface{}) bool {
+		if msg["type"] != "event" || msg["topic"] != topic {
+			return false
+		}
+		data, _ := msg["data"].(map[string]interface{})
+		return check == nil || check(data)
+	}
+}
+
+func TestIntegrationWebSocketTopics(t *testing.T) {
+	ts := newTestServer(t)
+	resetUpgradeState(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runSystemSampler(ctx, 10*time.Millisecond)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	ts.login()
+	ts.runner.
+		on("systemctl is-enabled frpc", "enabled\n", nil).
+		on("systemctl is-active frpc", "active\n", nil).
+		on("sudo systemctl restart frpc", "", nil)
+
+	dialer := websocket.Dialer{Subprotocols: []string{wsProtocol}}
+	wsURL := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws?token=" + ts.token
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("WebSocket 连接失败: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != wsProtocol {
+		t.Fatalf("子协议 = %q", conn.Subprotocol())
+	}
+
+	send := func(req wsRequest) {
+		t.Helper()
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isType := func(typ string) func(map[string]interface{}) bool {
+		return func(msg map[string]interface{}) bool { return msg["type"] == typ }
+	}
+
+	// 未订阅时不推送事件，未知主题返回错误
+	send(wsRequest{Type: "subscribe", Topics: []string{"bogus"}})
+	if msg := readWSMessage(t, conn, func(map[string]interface{}) bool { return true }); msg["type"] != "error" {
+		t.Errorf("未知主题返回 %v", msg)
+	}
+	if msg := readWSMessage(t, conn, isType("subscribed")); !reflect.DeepEqual(msg["data"], []interface{}{}) {
+		t.Errorf("subscribed = %v", msg["data"])
+	}
+
+	send(wsRequest{Type: "subscribe", Topics: []string{topicSystem, topicNetwork, topicUpgrade, topicLeds, topicServices}})
+	msg := readWSMessage(t, conn, isType("subscribed"))
+	if !reflect.DeepEqual(msg["data"], []interface{}{"leds", "network", "services", "system", "upgrade"}) {
+		t.Errorf("subscribed = %v", msg["data"])
+	}
+
+	// 订阅后立即收到当前状态
+	readWSMessage(t, conn, wsEventOf(topicUpgrade, func(d map[string]interface{}) bool { return d["status"] == "idle" }))
+	readWSMessage(t, conn, wsEventOf(topicServices, func(d map[string]interface{}) bool { return d["name"] == "frpc" }))
+	readWSMessage(t, conn, wsEventOf(topicSystem, nil))
+	readWSMessage(t, conn, wsEventOf(topicNetwork, func(d map[string]interface{}) bool {
+		wlan, _ := d["interfaces"].(map[string]interface{})["wlan0"].(map[string]interface{})
+		return wlan["rx_bytes"] == 123456.0
+	}))
+
+	// 状态变化时推送
+	setUpgradeStatus("installing", 50, "正在安装")
+	readWSMessage(t, conn, wsEventOf(topicUpgrade, func(d map[string]interface{}) bool {
+		return d["status"] == "installing" && d["progress"] == 50.0
+	}))
+	if err := saveStoredLedStatus("OFF"); err != nil {
+		t.Fatal(err)
+	}
+	readWSMessage(t, conn, wsEventOf(topicLeds, func(d map[string]interface{}) bool { return d["status"] == "OFF" }))
+	if code, _ := ts.text(http.MethodPost, "/service/restart?name=frpc", "", nil); code != http.StatusOK {
+		t.Fatalf("/service/restart 返回 %d", code)
+	}
+	readWSMessage(t, conn, wsEventOf(topicServices, func(d map[string]interface{}) bool {
+		return d["name"] == "frpc" && d["isActive"] == true
+	}))
+
+	send(wsRequest{Type: "ping"})
+	readWSMessage(t, conn, isType("pong"))
+
+	// 取消订阅后不再收到该主题的事件
+	send(wsRequest{Type: "unsubscribe", Topics: []string{topicSystem, topicNetwork, topicServices, topicLeds}})
+	if msg := readWSMessage(t, conn, isType("subscribed")); !reflect.DeepEqual(msg["data"], []interface{}{"upgrade"}) {
+		t.Errorf("subscribed = %v", msg["data"])
+	}
+	setUpgradeStatus("done", 100, "完成")
+	readWSMessage(t, conn, func(msg map[string]interface{}) bool {
+		if msg["type"] == "event" && msg["topic"] != topicUpgrade {
+			// 取消订阅前已入队的事件可能还在发送
+			return false
+		}
+		data, _ := msg["data"].(map[string]interface{})
+		return data["status"] == "done"
+	})
+}
+
+func TestIntegrationWebSocketKeepalive(t *testing.T) {
+	ts := newTestServer(t)
+	oldPing, oldPong := wsPingInterval, wsPongWait
+	wsPingInterval, wsPongWait = 20*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { wsPingInterval, wsPongWait = oldPing, oldPong })
+	ts.login()
+
+	// 不读取消息的客户端无法回复 pong，超时后服务端断开
+	wsURL := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws?token=" + ts.token
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("WebSocket 连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		wsClientsLock.Lock()
+		n := len(wsClients)
+		wsClientsLock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("没有回复 pong 的连接没有被断开")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIntegrationLogStream(t *testing.T) {
+	ts := newTestServer(t)
+	old := logStreamHeartbeat
+	logStreamHeartbeat = 20 * time.Millisecond
+	t.Cleanup(func() { logStreamHeartbeat = old })
+	ts.login()
+
+	// 经过鉴权和统计中间件后仍能立即收到心跳和新日志
+	resp := ts.do(http.MethodGet, "/api/v1/logs/stream?source=daemon&lines=0", "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /logs/stream: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, err := r.ReadString('\n'); err != nil || line != ": keepalive\n" {
+		t.Fatalf("第一行 %q, %v", line, err)
+	}
+	fmt.Fprint(daemonLogBroadcaster, "service restarted\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("没有收到新日志: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			var ev LogLine
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil || ev.Message != "service restarted" || ev.Source != logSourceDaemon {
+				t.Fatalf("日志事件 %q", line)
+			}
+			break
+		}
+	}
+
+	// 程序日志接口返回同一份保留的日志
+	var logs LogResponse
+	if code := ts.json(http.MethodGet, "/serverlogs", nil, &logs); code != http.StatusOK || !strings.Contains(logs.Output, "service restarted\n") {
+		t.Errorf("GET /serverlogs: %d %q", code, logs.Output)
+	}
+
+	// viewer 不能读取本程序的日志，包括 WebSocket 的 logs 主题
+	if code := ts.json(http.MethodPost, "/api/v1/users/add", UserRequest{Username: "viewer1", Password: testPassword, Role: RoleViewer}, nil); code != http.StatusOK {
+		t.Fatalf("创建用户失败: %d", code)
+	}
+	var viewer LoginResponse
+	ts.token = ""
+	ts.json(http.MethodPost, "/api/v1/auth/login", LoginRequest{Username: "viewer1", Password: testPassword}, &apiResponse{Data: &viewer})
+	ts.token = viewer.Token
+	if code, _ := ts.text(http.MethodGet, "/api/v1/logs/stream?source=daemon", "", nil); code != http.StatusForbidden {
+		t.Errorf("viewer 跟踪程序日志返回 %d，期望 403", code)
+	}
+	if code, _ := ts.text(http.MethodGet, "/serverlogs", "", nil); code != http.StatusForbidden {
+		t.Errorf("viewer 读取程序日志返回 %d，期望 403", code)
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{wsProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.srv.URL, "http")+"/ws?token="+viewer.Token, nil)
+	if err != nil {
+		t.Fatalf("WebSocket 连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsRequest{Type: "subscribe", Topics: []string{topicLogs, topicLeds}})
+	msg := readWSMessage(t, conn, func(msg map[string]interface{}) bool { return msg["type"] == "error" })
+	if e, _ := msg["error"].(map[string]interface{}); e["code"] != errCodeForbidden {
+		t.Errorf("viewer 订阅 logs 回复 %v", msg)
+	}
+	msg = readWSMessage(t, conn, func(msg map[string]interface{}) bool { return msg["type"] == "subscribed" })
+	if topics, _ := msg["data"].([]interface{}); len(topics) != 1 || topics[0] != topicLeds {
+		t.Errorf("viewer 订阅的主题 %v，期望只有 leds", msg["data"])
+	}
+}
+
+func TestIntegrationPrometheus(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+	ts.runner.
+		on("systemctl is-active nginx", "active\n", nil).
+		on("systemctl is-enabled nginx", "enabled\n", nil)
+
+	// 默认关闭
+	if code, _ := ts.text(http.MethodGet, "/metrics", "", nil); code != http.StatusNotFound {
+		t.Errorf("默认配置下 /metrics 返回 %d，期望 404", code)
+	}
+	cfg := *getConfig()
+	cfg.Prometheus.Enabled = true
+	setConfig(&cfg)
+
+	// 旧路径接受任意方法，统计时不使用客户端提供的方法名
+	ts.text("BREW", "/login", "", nil)
+
+	resp := ts.do(http.MethodGet, "/metrics", "", nil)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != prometheusContentType {
+		t.Fatalf("GET /metrics: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	body := string(data)
+	for _, want := range []string{
+		"# TYPE assismgr_cpu_usage_percent gauge\n",
+		`assismgr_service_active{service="nginx"} 1` + "\n",
+		`assismgr_service_enabled{service="frpc"} 0` + "\n",
+		`assismgr_network_receive_bytes_total{interface="wlan0"} 123456` + "\n",
+		`assismgr_network_transmit_bytes_total{interface="wlan0"} 65432` + "\n",
+		`assismgr_upgrade_status{status="idle"} 1` + "\n",
+		`assismgr_upgrade_status{status="installing"} 0` + "\n",
+		`assismgr_http_requests_total{route="/api/v1/auth/login",method="POST",code="200"} `,
+		`assismgr_http_request_duration_seconds_count{route="/api/v1/auth/password",method="POST"} `,
+		`assismgr_http_requests_total{route="/api/v1/auth/login",method="other",`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics 中缺少 %q", want)
+		}
+	}
+	if strings.Contains(body, `method="BREW"`) {
+		t.Error("/metrics 中不应出现客户端提供的方法名")
+	}
+
+	// 配置 Token 后需要 Bearer 认证
+	cfg.Prometheus.Token = "scrape-secret"
+	setConfig(&cfg)
+	if code, _ := ts.text(http.MethodGet, "/metrics", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("没有 Token 时返回 %d，期望 401", code)
+	}
+	req, _ := http.NewRequest(http.MethodGet, ts.srv.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-secret")
+	if resp, err := ts.srv.Client().Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("带 Token 请求失败: %v %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	cfg.Prometheus.Enabled = false
+	setConfig(&cfg)
+	if code, _ := ts.text(http.MethodGet, "/metrics", "", nil); code != http.StatusNotFound {
+		t.Errorf("关闭后返回 %d，期望 404", code)
+	}
+}
+
+// 退出时处理中的请求先正常结束，之后才取消请求的 context；
+// 升级过程中提前收到的第二次信号仍然可以强制退出
+func TestIntegrationShutdown(t *testing.T) {
+	t.Cleanup(func() { logStreamsCtx, stopLogStreams = context.WithCancel(context.Background()) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			if r.Context().Err() != nil {
+				http.Error(w, "cancelled", http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, "ok")
+		}),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(data)
+	}()
+	<-started
+
+	raucInstalling.Add(1)
+	defer raucInstalling.Add(-1)
+	force := make(chan os.Signal, 1)
+	force <- syscall.SIGTERM
+
+	shutdownServers([]*http.Server{server}, cancel, force)
+	if got := <-body; got != "ok" {
+		t.Errorf("处理中的请求返回 %q", got)
+	}
+	if ctx.Err() == nil {
+		t.Error("退出后应取消根 context")
+	}
+}
@@ -1,27 +1,22 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
-const (
-	LED_STATE_PATH = "/mnt/data/assismgr/ledstatus"
-)
-
 var ledList []string
 
 var ledStateMutex sync.Mutex
 
-// saveLedStatus 保存 LED 状态到 JSON 文件
+// saveLedStatus 保存 LED 状态到数据库
 // ledName: LED 名称（如 "sys_led"）
 // status: true 表示 ON，false 表示 OFF
 func saveLedStatus(ledName string, ledMode string) error {
@@ -32,67 +27,97 @@ func saveLedStatus(ledName string, ledMode string) error {
 	// 1. 尝试读取现有状态
 	states, err := readLedStates()
 	if err != nil {
-		// 如果文件不存在或无效，初始化空状态
+		// 如果读取失败，初始化空状态
 		states = make(map[string]string)
 	}
 
 	// 2. 更新指定 LED 的状态
 	states[ledName] = ledMode
 
-	// 3. 将更新后的状态写回文件
+	// 3. 将更新后的状态写回数据库
 	return writeLedStates(states)
 }
 
-// readLedStates 从文件读取所有 LED 状态
+// readLedStates 从数据库读取所有 LED 状态
 func readLedStates() (map[string]string, error) {
-	// 检查文件是否存在
-	if _, err := os.Stat(LED_STATE_PATH); os.IsNotExist(err) {
-		return make(map[string]string), nil
+	rows, err := stateDB.Query("SELECT name, mode FROM led_states")
+	if err != nil {
+		return nil, fmt.Errorf("读取LED状态失败: %w", err)
 	}
+	defer rows.Close()
 
-	// 读取文件内容
-	data, err := os.ReadFile(LED_STATE_PATH)
-	if err != nil {
-		return nil, fmt.Errorf("读取文件失败: %w", err)
+	states := make(map[string]string)
+	for rows.Next() {
+		var name, mode string
+		if err := rows.Scan(&name, &mode); err != nil {
+			return nil, fmt.Errorf("读取LED状态失败: %w", err)
+		}
+		states[name] = mode
 	}
+	return states, rows.Err()
+}
 
-	// 解析 JSON
-	var states map[string]string
-	if err := json.Unmarshal(data, &states); err != nil {
-		return nil, fmt.Errorf("解析JSON失败: %w", err)
+// writeLedStates 用 states 替换数据库中的所有 LED 状态
+func writeLedStates(states map[string]string) error {
+	err := storeTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM led_states"); err != nil {
+			return err
+		}
+		return insertLedStates(tx, states)
+	})
+	if err != nil {
+		log.Println("写入LED状态失败:", err)
+		return fmt.Errorf("写入LED状态失败: %w", err)
 	}
+	publishLedState()
+	return nil
+}
 
-	return states, nil
+// leds 主题的事件：用户设置的 LED 开关和各 LED 的模式
+type LedEvent struct {
+	Status string            `json:"status"`
+	Leds   map[string]string `json:"leds"`
 }
 
-// writeLedStates 将 LED 状态写入文件
-func writeLedStates(states map[string]string) error {
-	// 创建 JSON 数据
-	data, err := json.MarshalIndent(states, "", "  ")
+func currentLedEvent() (LedEvent, error) {
+	states, err := readLedStates()
 	if err != nil {
-		return fmt.Errorf("JSON编码失败: %w", err)
+		return LedEvent{}, err
 	}
+	return LedEvent{Status: getStoredLedStatus(), Leds: states}, nil
+}
 
-	// 写入文件（使用 0644 权限：用户读写，组只读，其他只读）
-	if err := os.WriteFile(LED_STATE_PATH, data, 0644); err != nil {
-		log.Println("写入文件失败:", err)
-		return fmt.Errorf("写入文件失败: %w", err)
+// LED 设置变化后推送给订阅了 leds 主题的 WebSocket 连接
+func publishLedState() {
+	if !hasSubscribers(topicLeds) {
+		return
+	}
+	if ev, err := currentLedEvent(); err == nil {
+		publishEvent(topicLeds, topicLeds, ev)
 	}
+}
 
+func insertLedStates(tx *sql.Tx, states map[string]string) error {
+	for name, mode := range states {
+		if _, err := tx.Exec("INSERT INTO led_states (name, mode) VALUES (?, ?)", name, mode); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
 func getAllLed() []string {
 
 	var ledList []string
 	// 使用 Go 的 Glob 函数匹配路径
-	ledPaths, err := filepath.Glob("/sys/class/leds/*")
+	ledPaths, err := sysFS().Glob("/sys/class/leds/*")
 	if err != nil {
-		fmt.Printf("查找LED设备失败: %v\n", err)
+		log.Printf("查找LED设备失败: %v", err)
 		return nil
 	}
 
 	if len(ledPaths) == 0 {
-		fmt.Println("未找到任何LED设备")
+		log.Println("未找到任何LED设备")
 		return nil
 	}
 
@@ -112,12 +137,9 @@ func ledInit() error {
 		return fmt.Errorf("未找到任何LED设备")
 	}
 
-	if _, err := os.Stat(LED_STATE_PATH); os.IsNotExist(err) {
-		if err := os.MkdirAll(filepath.Dir(LED_STATE_PATH), 0755); err != nil {
-			return fmt.Errorf("创建目录失败: %w", err)
-		}
-		// 文件不存在时创建并初始化ON
-		log.Println("LED状态文件不存在，创建新文件并初始化状态")
+	if states, err := readLedStates(); err == nil && len(states) == 0 {
+		// 没有保存过状态时初始化为ON
+		log.Println("没有保存的LED状态，初始化状态")
 		states := make(map[string]string)
 		for _, ledName := range ledList {
 			if ledName == "sys_led" {
@@ -151,7 +173,7 @@ func ledInit() error {
 		if ledName == "sys_led" {
 			switchLed(states[ledName] == "on")
 		} else {
-			exec.Command("led-control", ledName, states[ledName]).Run()
+			getRunner().Output(context.Background(), "led-control", ledName, states[ledName])
 		}
 	}
 	return nil
@@ -166,8 +188,7 @@ func controlSysLed(status bool) error {
 	} else {
 		Ledstatus = "off"
 	}
-	cmd := exec.Command("led-control", "sys_led", Ledstatus)
-	cmd.Run()
+	getRunner().Output(context.Background(), "led-control", "sys_led", Ledstatus)
 	return saveLedStatus("sys_led", Ledstatus)
 }
 
@@ -196,8 +217,8 @@ func setLedMode(ledName string, ledMode string) error {
 
 	// 2. 更新指定 LED 的模式
 	states[ledName] = ledMode
-	exec.Command("led-control", ledName, ledMode).Run()
-	// 3. 将更新后的状态写回文件
+	getRunner().Output(context.Background(), "led-control", ledName, ledMode)
+	// 3. 将更新后的状态写回数据库
 	return writeLedStates(states)
 }
 
@@ -226,25 +247,40 @@ var ledStatusMap = map[int]string{
 	STATUS_LED_OFF:   "off",       // LED关闭状态
 }
 
+// 读取用户设置的 LED 开关，没有设置过时返回 errSettingNotFound
+func readStoredLedStatus() (string, error) {
+	data, err := getSetting(settingLedStatus)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func getStoredLedStatus() string {
-	// 读取LED状态文件
-	data, err := os.ReadFile("/mnt/data/ledstatus")
+	// 读取LED开关设置
+	status, err := readStoredLedStatus()
 	if err != nil {
-		log.Println("读取LED状态文件失败:", err)
-		return "ON" // 如果读取失败，返回OFF状态
+		if !errors.Is(err, errSettingNotFound) {
+			log.Println("读取LED状态失败:", err)
+		}
+		return "ON" // 如果读取失败，返回ON状态
 	}
-	status := strings.TrimSpace(string(data))
-	if status == "OFF" {
-		return "OFF"
+	return status
+}
+
+// 保存用户设置的 LED 开关，status 为 ON 或 OFF
+func saveStoredLedStatus(status string) error {
+	if err := setSetting(settingLedStatus, []byte(status)); err != nil {
+		return err
 	}
-	return "ON"
+	publishLedState()
+	return nil
 }
 
 func getLedStatus() string {
 	var ledstatus int
-	var out bytes.Buffer
 	// 执行 ping 命令获取网络状态
-	netstatus := isOnlineStatus
+	netstatus := isOnlineStatus.Load()
 
 	if !netstatus {
 		ledstatus = STATUS_IP_OK
@@ -255,16 +291,13 @@ func getLedStatus() string {
 
 	// 执行 ifconfig 命令获取网络状态
 	for i := 0; i < 10; i++ {
-		cmd := exec.Command("ifconfig")
-		out.Reset()
-		cmd.Stdout = &out
-		err := cmd.Run()
+		out, err := getRunner().Output(context.Background(), "ifconfig")
 		if err != nil {
 			break
 		}
-		if strings.Contains(out.String(), "inet ") {
+		if strings.Contains(string(out), "inet ") {
 
-			lines := strings.Split(out.String(), "\n")
+			lines := strings.Split(string(out), "\n")
 			for _, line := range lines {
 				line = strings.TrimSpace(line)
 				if strings.HasPrefix(line, "inet ") {
@@ -281,26 +314,28 @@ func getLedStatus() string {
 	return ledStatusMap[STATUS_SYSTEM_ON] // 如果没有获取到IP，则返回系统开机状态
 }
 
-func updateLed() {
+func updateLed(ctx context.Context) {
 	// 读取LED状态文件
 	var preLedStatus string
 
-	go checkInternet()
 	for {
 
 		// 每10次检查一次LED状态
 		Ledstatus := getLedStatus()
 
 		if Ledstatus != preLedStatus { // 如果状态发生变化
-			cmd := exec.Command("led-control", Ledstatus)
-			cmd.Run()
+			getRunner().Output(ctx, "led-control", Ledstatus)
 			preLedStatus = Ledstatus
 			// 如果当前LED状态是OFF，并且网络状态是正常的，则需要关闭LED
 			if getStoredLedStatus() == "OFF" && Ledstatus == ledStatusMap[STATUS_NETWORK] {
-				cmd := exec.Command("led-control", "off") // 关闭LED
-				cmd.Run()
+				getRunner().Output(ctx, "led-control", "off") // 关闭LED
 			}
 		}
-		time.Sleep(10 * time.Second) // 每秒检查一次
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const ifconfigWithIP = `eth0: flags=4163<UP,BROADCAST,RUNNING,MULTICAST>  mtu 1500
+        inet 192.168.1.20  netmask 255.255.255.0  broadcast 192.168.1.255
+        ether 02:42:ac:11:00:02  txqueuelen 0  (Ethernet)
+`
+
+const ifconfigWithoutIP = `eth0: flags=4099<UP,BROADCAST,MULTICAST>  mtu 1500
+        ether 02:42:ac:11:00:02  txqueuelen 0  (Ethernet)
+`
+
+func setOnline(t *testing.T, online bool) {
+	old := isOnlineStatus.Load()
+	isOnlineStatus.Store(online)
+	t.Cleanup(func() { isOnlineStatus.Store(old) })
+}
+
+func TestGetLedStatus(t *testing.T) {
+	t.Run("网络已连接", func(t *testing.T) {
+		setOnline(t, true)
+		f := newFakeRunner(t)
+		if got := getLedStatus(); got != LED_MODE_HEARTBEAT {
+			t.Errorf("getLedStatus() = %q，期望 %q", got, LED_MODE_HEARTBEAT)
+		}
+		assertCommands(t, f)
+	})
+
+	t.Run("已获取 IP", func(t *testing.T) {
+		setOnline(t, false)
+		f := newFakeRunner(t).on("ifconfig", ifconfigWithIP, nil)
+		if got := getLedStatus(); got != LED_MODE_SLOW {
+			t.Errorf("getLedStatus() = %q，期望 %q", got, LED_MODE_SLOW)
+		}
+		assertCommands(t, f, "ifconfig")
+	})
+
+	t.Run("没有 IP", func(t *testing.T) {
+		setOnline(t, false)
+		f := newFakeRunner(t).on("ifconfig", ifconfigWithoutIP, nil)
+		if got := getLedStatus(); got != LED_MODE_FAST {
+			t.Errorf("getLedStatus() = %q，期望 %q", got, LED_MODE_FAST)
+		}
+		// 没有 IP 时重试 10 次
+		assertCommands(t, f, strings.Split(strings.Repeat("ifconfig\n", 10), "\n")[:10]...)
+	})
+
+	t.Run("ifconfig 失败", func(t *testing.T) {
+		setOnline(t, false)
+		f := newFakeRunner(t).on("ifconfig", "", errExitStatus1)
+		if got := getLedStatus(); got != LED_MODE_FAST {
+			t.Errorf("getLedStatus() = %q，期望 %q", got, LED_MODE_FAST)
+		}
+		assertCommands(t, f, "ifconfig")
+	})
+}
@@ -3,10 +3,11 @@ package main
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -20,19 +21,31 @@ var users = map[string]string{
 
 // JWT 配置
 var (
-	jwtSecret []byte           // 生产环境应从安全配置读取
-	tokenExp  = time.Hour * 10 // Token 有效期
+	jwtSecret []byte             // 生产环境应从安全配置读取
+	tokenExp  = time.Minute * 15 // 访问 Token 有效期，过期后使用刷新 Token 换取
 )
 
+// 用户不存在时用来比较密码的哈希，使两种失败耗时相同，避免通过响应时间判断用户名是否存在
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(newTokenID()), bcrypt.DefaultCost)
+	return hash
+})
+
 // 登录请求结构体
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	OTP      string `json:"otp,omitempty"` // 启用两步验证时的验证码或恢复码
 }
 
 // 登录响应结构体
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // 访问 Token 有效期（秒）
+	Role         Role   `json:"role"`
+
+	MustChangePassword bool `json:"must_change_password,omitempty"`
 }
 
 // 受保护接口响应结构体
@@ -54,90 +67,124 @@ func keygen() []byte {
 
 func initLogin() {
 	// 初始化密钥
-	// 初始化密钥
-	keyPath := "/mnt/data/assismgr-key"
-
-	// 检查文件是否存在
-	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		// 文件不存在，创建新密钥
+	var err error
+	jwtSecret, err = getSetting(settingJWTKey)
+	if errors.Is(err, errSettingNotFound) {
+		// 没有保存过密钥，创建新密钥
 		jwtSecret = keygen()
-
-		// 创建文件并设置权限为600（只有所有者可读写）
-		err := os.WriteFile(keyPath, jwtSecret, 0600)
-		if err != nil {
-			log.Fatalf("Failed to create JWT key file: %v", err)
+		if err := setSetting(settingJWTKey, jwtSecret); err != nil {
+			log.Fatalf("Failed to save JWT key: %v", err)
 		}
-	} else {
-		// 文件已存在，读取密钥
-		var err error
-		jwtSecret, err = os.ReadFile(keyPath)
-		if err != nil {
-			log.Fatalf("Failed to read JWT key file: %v", err)
-		}
-
+	} else if err != nil {
+		log.Fatalf("Failed to read JWT key: %v", err)
+	} else if len(jwtSecret) == 0 {
 		// 检查密钥是否有效
-		if len(jwtSecret) == 0 {
-			log.Fatal("JWT key file is empty")
-		}
+		log.Fatal("JWT key is empty")
 	}
-	// 注册路由
-	http.HandleFunc("/login", loginHandler)
+	loadRevokedTokens()
+	initSessions()
+
 }
 
 // 登录处理函数
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 
-	var creds struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
+	var creds LoginRequest
 	// 只接受 POST 请求
 	if r.Method != http.MethodPost {
-		http.ServeFile(w, r, *staticFileDir+"/login.html")
+		serveStaticFile(w, r, "login.html")
 		return
 	}
 	// 解析请求体
-	// var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		respondErrorJSON(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request", nil)
 		return
 	}
 
-	// 验证用户凭证
-
-	user, err := loadUser()
-	if err != nil {
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+	// 失败次数过多时拒绝尝试，避免暴力破解
+	ip := clientIP(r)
+	// 被拒绝的尝试不写审计日志，否则未登录的客户端可以不断写入数据库
+	if wait := loginRetryAfter(ip, creds.Username); wait > 0 {
+		respondTooManyAttempts(w, r, wait)
 		return
 	}
 
-	if user.Username != creds.Username {
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+	// 验证用户凭证。用户不存在和密码错误返回相同的响应
+	user, err := findUser(creds.Username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(creds.Password))
+		recordLoginFailure(ip, creds.Username)
+		auditLogin(r, creds.Username, http.StatusUnauthorized, "unknown user")
+		respondErrorJSON(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, "Invalid credentials", nil)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
-		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
+		recordLoginFailure(ip, creds.Username)
+		auditLogin(r, creds.Username, http.StatusUnauthorized, "wrong password")
+		respondErrorJSON(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, "Invalid credentials", nil)
 		return
 	}
 
-	// 生成 JWT Token
-	token, err := generateToken(creds.Username)
+	// 已启用两步验证的用户还需要验证码，客户端收到 totp_required 后带上 otp 重新提交
+	if user.totpEnabled() {
+		if creds.OTP == "" {
+			respondErrorJSON(w, r, http.StatusUnauthorized, errCodeTOTPRequired, "Two-factor code required",
+				map[string]interface{}{"totp_required": true})
+			return
+		}
+		if err := verifySecondFactor(user.Username, creds.OTP); err != nil {
+			recordLoginFailure(ip, creds.Username)
+			auditLogin(r, creds.Username, http.StatusUnauthorized, "wrong two-factor code")
+			respondErrorJSON(w, r, http.StatusUnauthorized, errCodeInvalidOTP, "Invalid two-factor code",
+				map[string]interface{}{"totp_required": true})
+			return
+		}
+	}
+	resetLoginFailures(ip, creds.Username)
+	auditLogin(r, user.Username, http.StatusOK, "ok")
+
+	// 创建会话并生成 JWT Token
+	session, refresh, err := createSession(user.Username, r)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+		respondErrorJSON(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to create session", nil)
+		return
+	}
+	token, err := generateToken(user, session.ID)
+	if err != nil {
+		respondErrorJSON(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate token", nil)
 		return
 	}
 
 	// 返回 Token
-	respondJSON(w, http.StatusOK, LoginResponse{Token: token})
+	respondData(w, r, http.StatusOK, LoginResponse{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(tokenExp.Seconds()),
+		Role:         user.Role,
+
+		MustChangePassword: user.MustChangePassword,
+	})
+}
+
+// JWT Claims，Generation 与用户当前的 TokenGeneration 不一致或会话已注销时 Token 失效
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Generation int    `json:"gen"`
+	SessionID  string `json:"sid,omitempty"`
 }
 
 // 生成 JWT Token
-func generateToken(username string) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Subject:   username,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+func generateToken(user *User, sessionID string) (string, error) {
+	claims := TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   user.Username,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		Generation: user.TokenGeneration,
+		SessionID:  sessionID,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -145,10 +192,16 @@ func generateToken(username string) (string, error) {
 }
 
 // 验证 Token
-func validateToken(tokenString string) (*jwt.RegisteredClaims, error) {
+func validateToken(tokenString string) (*TokenClaims, error) {
+	claims, _, err := validateTokenUser(tokenString)
+	return claims, err
+}
+
+// 验证 Token 并返回对应的用户，已注销、用户已删除或已强制下线的 Token 视为无效
+func validateTokenUser(tokenString string) (*TokenClaims, *User, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
-		&jwt.RegisteredClaims{},
+		&TokenClaims{},
 		func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -156,11 +209,32 @@ func validateToken(tokenString string) (*jwt.RegisteredClaims, error) {
 			return jwtSecret, nil
 		},
 	)
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, nil, errors.New("invalid token")
+	}
 
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		return claims, nil
+	if isTokenRevoked(claims.ID) {
+		return nil, nil, errors.New("token revoked")
+	}
+	if claims.SessionID != "" && !sessionExists(claims.SessionID) {
+		return nil, nil, errors.New("session revoked")
+	}
+	user, err := findUser(claims.Subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.Generation != user.TokenGeneration {
+		return nil, nil, errors.New("token revoked")
+	}
+	// 用户删除后重新创建时 TokenGeneration 从 0 开始，按签发时间拒绝之前同名用户的 Token
+	if claims.IssuedAt == nil || claims.IssuedAt.Unix() < user.CreatedAt {
+		return nil, nil, errors.New("token revoked")
 	}
-	return nil, err
+	return claims, user, nil
 }
 
 // 通用 JSON 响应工具函数
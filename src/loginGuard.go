@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 登录失败限制
+var (
+	loginMaxFailures   = 5                // 连续失败次数达到该值后锁定
+	loginBackoffBase   = time.Second      // 锁定前每次失败后的等待时间，按 2 的幂递增
+	loginLockoutBase   = time.Minute * 5  // 首次锁定时长，之后每次失败翻倍
+	loginLockoutMax    = time.Hour        // 最长锁定时长
+	loginAttemptExpiry = time.Hour * 24   // 超过该时间没有失败则清除记录
+	loginSweepInterval = time.Minute * 10 // 定期清除过期记录的间隔
+	loginAttemptLimit  = 10000            // 最多保留的记录数，超过时淘汰最早失败的记录
+)
+
+type loginAttempt struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+}
+
+var (
+	loginAttemptMutex sync.Mutex
+	loginAttempts     = map[string]*loginAttempt{}
+)
+
+func attemptKeys(ip, username string) []string {
+	return []string{"ip:" + ip, "user:" + username}
+}
+
+// 返回还需等待的时间，为 0 时允许尝试登录
+func loginRetryAfter(ip, username string) time.Duration {
+	loginAttemptMutex.Lock()
+	defer loginAttemptMutex.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range attemptKeys(ip, username) {
+		if a, ok := loginAttempts[key]; ok && a.blockedTill.After(now) {
+			wait = max(wait, a.blockedTill.Sub(now))
+		}
+	}
+	return wait
+}
+
+// 记录一次失败，并计算下一次允许尝试的时间
+func recordLoginFailure(ip, username string) {
+	loginAttemptMutex.Lock()
+	defer loginAttemptMutex.Unlock()
+
+	now := time.Now()
+	keys := attemptKeys(ip, username)
+	for _, key := range keys {
+		a, ok := loginAttempts[key]
+		if !ok {
+			if len(loginAttempts) >= loginAttemptLimit {
+				evictLoginAttempts(now, keys)
+			}
+			a = &loginAttempt{}
+			loginAttempts[key] = a
+		}
+		a.failures++
+		a.lastFailure = now
+
+		var wait time.Duration
+		if a.failures < loginMaxFailures {
+			wait = loginBackoffBase * time.Duration(math.Pow(2, float64(a.failures-1)))
+		} else {
+			// 失败次数很多时 2 的幂会使 Duration 溢出，先按浮点数与上限比较
+			wait = time.Duration(min(float64(loginLockoutBase)*math.Pow(2, float64(a.failures-loginMaxFailures)), float64(loginLockoutMax)))
+		}
+		a.blockedTill = now.Add(wait)
+	}
+}
+
+// 记录以攻击者可以任意指定的用户名为 key，需要限制数量：先清除过期记录，
+// 仍然超过上限时淘汰最早失败的记录，keep 中的记录（本次失败的 IP 和用户名）不淘汰。
+// 调用时需持有 loginAttemptMutex
+func evictLoginAttempts(now time.Time, keep []string) {
+	sweepLoginAttemptsLocked(now)
+	for len(loginAttempts) >= loginAttemptLimit {
+		var oldestKey string
+		var oldest time.Time
+		for key, a := range loginAttempts {
+			if slices.Contains(keep, key) {
+				continue
+			}
+			if oldestKey == "" || a.lastFailure.Before(oldest) {
+				oldestKey, oldest = key, a.lastFailure
+			}
+		}
+		delete(loginAttempts, oldestKey)
+	}
+}
+
+func sweepLoginAttemptsLocked(now time.Time) {
+	for key, a := range loginAttempts {
+		if now.Sub(a.lastFailure) > loginAttemptExpiry {
+			delete(loginAttempts, key)
+		}
+	}
+}
+
+// 定期清除过期的失败记录，直到 ctx 结束
+func sweepLoginAttempts(ctx context.Context) {
+	ticker := time.NewTicker(loginSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			loginAttemptMutex.Lock()
+			sweepLoginAttemptsLocked(now)
+			loginAttemptMutex.Unlock()
+		}
+	}
+}
+
+// 登录成功后清除失败记录
+func resetLoginFailures(ip, username string) {
+	loginAttemptMutex.Lock()
+	defer loginAttemptMutex.Unlock()
+
+	for _, key := range attemptKeys(ip, username) {
+		delete(loginAttempts, key)
+	}
+}
+
+// 登录结果写入审计日志
+func auditLogin(r *http.Request, username string, status int, result string) {
+	params, _ := json.Marshal(map[string]string{"user_agent": r.UserAgent()})
+	recordAudit(AuditEntry{
+		Username: username,
+		Auth:     "password",
+		Action:   apiPrefix + "/auth/login",
+		Method:   r.Method,
+		Params:   params,
+		IP:       clientIP(r),
+		Status:   status,
+		Result:   result,
+	})
+}
+
+func respondTooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondErrorJSON(w, r, http.StatusTooManyRequests, errCodeTooManyRequests, "Too many failed login attempts",
+		map[string]interface{}{"retry_after": seconds})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptLimit(t *testing.T) {
+	resetLoginAttempts()
+	t.Cleanup(resetLoginAttempts)
+	old := loginAttemptLimit
+	loginAttemptLimit = 10
+	t.Cleanup(func() { loginAttemptLimit = old })
+
+	// 同一 IP 尝试大量不存在的用户名，记录数不超过上限，最新的记录保留
+	for i := 0; i < 50; i++ {
+		recordLoginFailure("10.0.0.1", fmt.Sprintf("spray%d", i))
+	}
+	loginAttemptMutex.Lock()
+	n := len(loginAttempts)
+	_, ip := loginAttempts["ip:10.0.0.1"]
+	_, last := loginAttempts["user:spray49"]
+	loginAttemptMutex.Unlock()
+	if n > loginAttemptLimit || !ip || !last {
+		t.Errorf("记录数 %d，IP 记录 %v，最新用户记录 %v", n, ip, last)
+	}
+	if wait := loginRetryAfter("10.0.0.1", "someone"); wait <= 0 || wait > loginLockoutMax {
+		t.Errorf("连续失败 50 次后 IP 锁定 %s，期望不超过 %s", wait, loginLockoutMax)
+	}
+
+	// 定期清理过期记录
+	loginAttemptMutex.Lock()
+	sweepLoginAttemptsLocked(time.Now().Add(loginAttemptExpiry + time.Minute))
+	n = len(loginAttempts)
+	loginAttemptMutex.Unlock()
+	if n != 0 {
+		t.Errorf("过期后仍有 %d 条记录", n)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志跟踪。守护进程的日志通过 WebSocket 的 logs 主题推送；
+// GET /logs/stream 以 Server-Sent Events 持续输出 journal 或守护进程的新日志，
+// 例如重启服务后观察其启动过程
+
+// 日志来源
+const (
+	logSourceDaemon  = "daemon"
+	logSourceJournal = "journal"
+)
+
+// 守护进程日志没有级别，统一视为 info
+const daemonLogPriority = 6
+
+// 一行日志，logs 主题和日志流的事件
+type LogLine struct {
+	Time     int64  `json:"time"`           // Unix 毫秒
+	Source   string `json:"source"`         // daemon 或 journal
+	Unit     string `json:"unit,omitempty"` // journal 日志的 systemd 单元或程序名
+	Priority int    `json:"priority"`       // syslog 级别，0（emerg）到 7（debug）
+	Message  string `json:"message"`
+}
+
+const (
+	daemonLogBacklog  = 500  // 守护进程日志保留的最近行数，日志流从中补发历史
+	logStreamBuffer   = 256  // 每个日志流最多缓存的待发送行数
+	logStreamMaxLines = 1000 // lines 参数的上限
+)
+
+// 日志流的心跳间隔，防止代理因长时间没有数据断开连接
+var logStreamHeartbeat = 15 * time.Second
+
+// 每个 journal 日志流运行一个 journalctl 进程，限制同时运行的数量
+const (
+	journalStreamMax        = 8 // 所有用户合计
+	journalStreamMaxPerUser = 2
+)
+
+// 进程退出时结束所有日志流，否则 http.Server.Shutdown 要等到超时
+var logStreamsCtx, stopLogStreams = context.WithCancel(context.Background())
+
+var (
+	journalStreamMutex sync.Mutex
+	journalStreams     = map[string]int{} // 每个用户正在运行的 journal 日志流数
+)
+
+// 占用一个 journal 日志流名额，超过上限时返回 false；成功时结束后需调用 releaseJournalStream
+func acquireJournalStream(username string) bool {
+	journalStreamMutex.Lock()
+	defer journalStreamMutex.Unlock()
+
+	total := 0
+	for _, n := range journalStreams {
+		total += n
+	}
+	if total >= journalStreamMax || journalStreams[username] >= journalStreamMaxPerUser {
+		return false
+	}
+	journalStreams[username]++
+	return true
+}
+
+func releaseJournalStream(username string) {
+	journalStreamMutex.Lock()
+	defer journalStreamMutex.Unlock()
+
+	if journalStreams[username]--; journalStreams[username] <= 0 {
+		delete(journalStreams, username)
+	}
+}
+
+// 日志流的过滤条件
+type logFilter struct {
+	Source   string
+	Unit     string
+	Priority int   // 只输出不低于该级别（数值不大于）的日志，-1 表示不过滤
+	Since    int64 // Unix 秒，0 表示不限
+	Lines    int   // 开始跟踪前补发的历史行数，-1 表示补发 Since 之后的全部历史
+}
+
+// syslog 级别名称，下标即数值
+var logPriorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.@\\-]+$`)
+
+func parseLogFilter(q url.Values) (logFilter, error) {
+	f := logFilter{Source: q.Get("source"), Unit: q.Get("unit"), Priority: -1, Lines: 10}
+	switch f.Source {
+	case "":
+		f.Source = logSourceJournal
+	case logSourceJournal, logSourceDaemon:
+	default:
+		return f, fmt.Errorf("无效的日志来源: %s", f.Source)
+	}
+	if f.Unit != "" {
+		if f.Source != logSourceJournal {
+			return f, errors.New("只有 journal 日志可以按单元过滤")
+		}
+		if !unitNamePattern.MatchString(f.Unit) {
+			return f, fmt.Errorf("无效的单元名: %s", f.Unit)
+		}
+	}
+	if v := q.Get("priority"); v != "" {
+		p, err := parseLogPriority(v)
+		if err != nil {
+			return f, err
+		}
+		f.Priority = p
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := parseTimeParam(v)
+		if err != nil || since <= 0 {
+			return f, fmt.Errorf("无效的时间: %s", v)
+		}
+		f.Since = since
+		f.Lines = -1
+	}
+	if v := q.Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > logStreamMaxLines {
+			return f, fmt.Errorf("lines 应为 0 到 %d", logStreamMaxLines)
+		}
+		f.Lines = n
+	}
+	return f, nil
+}
+
+// 级别可以是数字或名称
+func parseLogPriority(v string) (int, error) {
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < len(logPriorityNames) {
+		return n, nil
+	}
+	for i, name := range logPriorityNames {
+		if strings.EqualFold(v, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("无效的日志级别: %s", v)
+}
+
+func (f logFilter) match(line LogLine) bool {
+	if f.Priority >= 0 && line.Priority > f.Priority {
+		return false
+	}
+	return f.Since == 0 || line.Time >= f.Since*1000
+}
+
+// journalctl 的参数，输出 JSON 并持续跟踪
+func (f logFilter) journalArgs() []string {
+	args := []string{"-o", "json", "-f"}
+	if f.Lines >= 0 {
+		args = append(args, "-n", strconv.Itoa(f.Lines))
+	} else {
+		args = append(args, "-n", "all")
+	}
+	if f.Unit != "" {
+		args = append(args, "-u", f.Unit)
+	}
+	if f.Priority >= 0 {
+		args = append(args, "-p", strconv.Itoa(f.Priority))
+	}
+	if f.Since > 0 {
+		args = append(args, "--since", "@"+strconv.FormatInt(f.Since, 10))
+	}
+	return args
+}
+
+// 解析 journalctl -o json 的一行。MESSAGE 含有不可打印字符时 journalctl 输出为字节数组
+func parseJournalLine(data string) (LogLine, error) {
+	var entry map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return LogLine{}, err
+	}
+	field := func(name string) string {
+		var s string
+		if raw, ok := entry[name]; ok {
+			if json.Unmarshal(raw, &s) != nil {
+				var b []byte
+				var ints []int
+				if json.Unmarshal(raw, &ints) == nil {
+					for _, c := range ints {
+						b = append(b, byte(c))
+					}
+				}
+				s = string(b)
+			}
+		}
+		return s
+	}
+
+	line := LogLine{Source: logSourceJournal, Unit: field("_SYSTEMD_UNIT"), Priority: daemonLogPriority, Message: field("MESSAGE")}
+	if line.Unit == "" {
+		line.Unit = field("SYSLOG_IDENTIFIER")
+	}
+	if us, err := strconv.ParseInt(field("__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		line.Time = us / 1000
+	}
+	if p, err := strconv.Atoi(field("PRIORITY")); err == nil {
+		line.Priority = p
+	}
+	return line, nil
+}
+
+// 一个日志流的待发送队列。发送不会阻塞，读取太慢时丢弃并计数
+type logFollower struct {
+	lines chan LogLine
+
+	mu      sync.Mutex
+	dropped int
+	err     error // 日志来源异常结束的原因，关闭 lines 之前设置
+}
+
+func newLogFollower(backlog []LogLine) *logFollower {
+	f := &logFollower{lines: make(chan LogLine, len(backlog)+logStreamBuffer)}
+	for _, line := range backlog {
+		f.lines <- line
+	}
+	return f
+}
+
+func (f *logFollower) send(line LogLine) {
+	select {
+	case f.lines <- line:
+	default:
+		f.mu.Lock()
+		f.dropped++
+		f.mu.Unlock()
+	}
+}
+
+func (f *logFollower) takeDropped() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := f.dropped
+	f.dropped = 0
+	return n
+}
+
+func (f *logFollower) finish(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+	close(f.lines)
+}
+
+func (f *logFollower) error() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// 执行 journalctl 跟踪日志，ctx 结束时终止
+func followJournal(ctx context.Context, filter logFilter) *logFollower {
+	f := newLogFollower(nil)
+	go func() {
+		err := getRunner().Stream(ctx, func(data string) {
+			line, err := parseJournalLine(data)
+			if err != nil {
+				return
+			}
+			f.send(line)
+		}, "journalctl", filter.journalArgs()...)
+		if ctx.Err() != nil {
+			err = nil
+		}
+		f.finish(err)
+	}()
+	return f
+}
+
+// 作为 log 包的输出之一，把每行日志推送给订阅了 logs 主题的 WebSocket 连接和守护进程日志流，
+// 并保留最近的日志。推送过程中不能再调用 log，否则会递归
+type logBroadcaster struct {
+	mu        sync.Mutex
+	partial   []byte // 还没有换行的部分
+	recent    []LogLine
+	followers map[*logFollower]logFilter
+}
+
+var daemonLogBroadcaster = &logBroadcaster{}
+
+func (b *logBroadcaster) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := append(b.partial, p...)
+	b.partial = nil
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := LogLine{
+			Time:     time.Now().UnixMilli(),
+			Source:   logSourceDaemon,
+			Priority: daemonLogPriority,
+			Message:  strings.TrimRight(string(data[:i]), "\r"),
+		}
+		data = data[i+1:]
+
+		b.recent = append(b.recent, line)
+		if len(b.recent) > daemonLogBacklog {
+			b.recent = b.recent[len(b.recent)-daemonLogBacklog:]
+		}
+		for f, filter := range b.followers {
+			if filter.match(line) {
+				f.send(line)
+			}
+		}
+		if hasSubscribers(topicLogs) {
+			publishEvent(topicLogs, "", line)
+		}
+	}
+	if len(data) > 0 {
+		b.partial = append([]byte(nil), data...)
+	}
+	return len(p), nil
+}
+
+// 保留的最近日志，每行一条
+func (b *logBroadcaster) text() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var sb strings.Builder
+	for _, line := range b.recent {
+		sb.WriteString(line.Message)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// 开始跟踪，先补发符合条件的最近日志。补发和之后的新日志之间不会遗漏或重复
+func (b *logBroadcaster) follow(filter logFilter) *logFollower {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []LogLine
+	for _, line := range b.recent {
+		if filter.match(line) {
+			backlog = append(backlog, line)
+		}
+	}
+	if filter.Lines >= 0 && len(backlog) > filter.Lines {
+		backlog = backlog[len(backlog)-filter.Lines:]
+	}
+	f := newLogFollower(backlog)
+	if b.followers == nil {
+		b.followers = make(map[*logFollower]logFilter)
+	}
+	b.followers[f] = filter
+	return f
+}
+
+func (b *logBroadcaster) unfollow(f *logFollower) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.followers, f)
+}
+
+// 以 Server-Sent Events 输出日志，直到客户端断开。事件类型：
+// log（LogLine）、dropped（{"count":n}，读取太慢丢弃的行数）、
+// error（journalctl 异常退出）、end（日志来源结束）
+func streamLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+
+	// 本程序的日志与 /logs/server 一样只对 operator 以上开放
+	if filter.Source == logSourceDaemon && !currentRole(r).allows(RoleOperator) {
+		respondError(w, r, http.StatusForbidden, errCodeForbidden, "Permission denied")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(logStreamsCtx, cancel)()
+	var f *logFollower
+	if filter.Source == logSourceDaemon {
+		f = daemonLogBroadcaster.follow(filter)
+		defer daemonLogBroadcaster.unfollow(f)
+	} else {
+		username := currentUsername(r)
+		if !acquireJournalStream(username) {
+			respondError(w, r, http.StatusTooManyRequests, errCodeTooManyRequests, "打开的系统日志流过多")
+			return
+		}
+		defer releaseJournalStream(username)
+		f = followJournal(ctx, filter)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 禁止 nginx 缓冲
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case line, ok := <-f.lines:
+			if !ok {
+				if err := f.error(); err != nil {
+					log.Printf("跟踪系统日志失败: %v\n", err)
+					writeSSE(w, "error", apiError{Code: errCodeCommandFailed, Message: "journalctl 异常退出"})
+				}
+				writeSSE(w, "end", struct{}{})
+				rc.Flush()
+				return
+			}
+			if n := f.takeDropped(); n > 0 {
+				writeSSE(w, "dropped", map[string]int{"count": n})
+			}
+			writeSSE(w, "log", line)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	b, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
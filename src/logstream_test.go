@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogBroadcaster(t *testing.T) {
+	c := addTestWSClient(t, topicLogs)
+	b := &logBroadcaster{}
+	l := log.New(b, "", 0)
+
+	l.Println("first")
+	fmt.Fprint(b, "second part")
+	fmt.Fprint(b, "ial\r\nthird\n")
+
+	var lines []string
+	for _, msg := range c.take() {
+		lines = append(lines, msg.Data.(LogLine).Message)
+	}
+	if !reflect.DeepEqual(lines, []string{"first", "second partial", "third"}) {
+		t.Errorf("推送的日志 = %q", lines)
+	}
+}
+
+func TestParseLogFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		args  string // journalctl 参数，为空表示参数无效
+	}{
+		{"", "-o json -f -n 10"},
+		{"unit=nginx.service&priority=warning&lines=0", "-o json -f -n 0 -u nginx.service -p 4"},
+		{"priority=3&since=1700000000", "-o json -f -n all -p 3 --since @1700000000"},
+		{"since=2023-11-14T22:13:20Z&lines=5", "-o json -f -n 5 --since @1700000000"},
+		{"source=daemon", "-o json -f -n 10"},
+		{"source=kernel", ""},
+		{"source=daemon&unit=nginx", ""},
+		{"unit=nginx%20reboot", ""},
+		{"priority=8", ""},
+		{"priority=verbose", ""},
+		{"since=yesterday", ""},
+		{"lines=1001", ""},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := parseLogFilter(q)
+		if tt.args == "" {
+			if err == nil {
+				t.Errorf("%q: 应返回错误", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if got := strings.Join(f.journalArgs(), " "); got != tt.args {
+			t.Errorf("%q: journalctl %s，期望 %s", tt.query, got, tt.args)
+		}
+	}
+}
+
+func TestParseJournalLine(t *testing.T) {
+	line, err := parseJournalLine(`{"__REALTIME_TIMESTAMP":"1700000000123456","_SYSTEMD_UNIT":"nginx.service","SYSLOG_IDENTIFIER":"nginx","PRIORITY":"3","MESSAGE":"bind failed"}`)
+	want := LogLine{Time: 1700000000123, Source: logSourceJournal, Unit: "nginx.service", Priority: 3, Message: "bind failed"}
+	if err != nil || line != want {
+		t.Errorf("parseJournalLine = %+v, %v", line, err)
+	}
+
+	// 内核等没有单元的日志使用程序名，不可打印的消息是字节数组
+	line, err = parseJournalLine(`{"__REALTIME_TIMESTAMP":"1700000000000000","SYSLOG_IDENTIFIER":"kernel","MESSAGE":[104,105,7]}`)
+	want = LogLine{Time: 1700000000000, Source: logSourceJournal, Unit: "kernel", Priority: daemonLogPriority, Message: "hi\a"}
+	if err != nil || line != want {
+		t.Errorf("parseJournalLine = %+v, %v", line, err)
+	}
+
+	if _, err := parseJournalLine("-- No entries --"); err == nil {
+		t.Error("非 JSON 行应返回错误")
+	}
+}
+
+func TestDaemonLogFollow(t *testing.T) {
+	b := &logBroadcaster{}
+	for i := 0; i < daemonLogBacklog+5; i++ {
+		fmt.Fprintf(b, "old %d\n", i)
+	}
+	if len(b.recent) != daemonLogBacklog || b.recent[0].Message != "old 5" {
+		t.Fatalf("保留 %d 行，第一行 %q", len(b.recent), b.recent[0].Message)
+	}
+	if text := b.text(); !strings.HasPrefix(text, "old 5\nold 6\n") || strings.Count(text, "\n") != daemonLogBacklog {
+		t.Errorf("text() 返回 %d 行", strings.Count(text, "\n"))
+	}
+
+	// 先补发最近的日志，之后收到新日志；不符合级别的流收不到任何日志
+	f := b.follow(logFilter{Priority: -1, Lines: 2})
+	quiet := b.follow(logFilter{Priority: 3, Lines: 10})
+	fmt.Fprint(b, "new\n")
+	var got []string
+	for len(f.lines) > 0 {
+		got = append(got, (<-f.lines).Message)
+	}
+	want := []string{fmt.Sprintf("old %d", daemonLogBacklog+3), fmt.Sprintf("old %d", daemonLogBacklog+4), "new"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("日志流收到 %q", got)
+	}
+	if len(quiet.lines) != 0 {
+		t.Errorf("priority=3 的日志流收到 %d 行", len(quiet.lines))
+	}
+
+	// 读取太慢时丢弃并计数
+	for i := 0; i < cap(f.lines)+3; i++ {
+		fmt.Fprint(b, "flood\n")
+	}
+	if n := f.takeDropped(); n != 3 {
+		t.Errorf("丢弃 %d 行，期望 3", n)
+	}
+
+	b.unfollow(f)
+	for len(f.lines) > 0 {
+		<-f.lines
+	}
+	fmt.Fprint(b, "after\n")
+	if len(f.lines) != 0 {
+		t.Error("取消跟踪后仍收到日志")
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	runner := newFakeRunner(t).
+		on("journalctl -o json -f -n 10 -u nginx.service",
+			`{"__REALTIME_TIMESTAMP":"1700000000000000","_SYSTEMD_UNIT":"nginx.service","PRIORITY":"6","MESSAGE":"started"}`+"\n"+
+				"-- Boot 1234 --\n", nil).
+		on("journalctl -o json -f -n 10 -u missing.service", "", errors.New("exit status 1"))
+
+	w := callHandler(streamLogs, http.MethodGet, "/logs/stream?unit=nginx.service", true)
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := "event: log\n" +
+		`data: {"time":1700000000000,"source":"journal","unit":"nginx.service","priority":6,"message":"started"}` + "\n\n" +
+		"event: end\ndata: {}\n\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("响应:\n%s\n期望:\n%s", got, want)
+	}
+
+	// journalctl 异常退出时先发送 error 事件
+	w = callHandler(streamLogs, http.MethodGet, "/logs/stream?unit=missing.service", true)
+	if !strings.HasPrefix(w.Body.String(), "event: error\n") || !strings.HasSuffix(w.Body.String(), "event: end\ndata: {}\n\n") {
+		t.Errorf("响应:\n%s", w.Body.String())
+	}
+	assertCommands(t, runner,
+		"journalctl -o json -f -n 10 -u nginx.service",
+		"journalctl -o json -f -n 10 -u missing.service")
+
+	// 参数无效时按普通接口返回错误
+	w = callHandler(streamLogs, http.MethodGet, "/logs/stream?priority=loud", true)
+	var resp struct {
+		OK    bool     `json:"ok"`
+		Error apiError `json:"error"`
+	}
+	decodeBody(t, w, &resp)
+	if w.Code != http.StatusBadRequest || resp.Error.Code != errCodeBadRequest {
+		t.Errorf("无效参数返回 %d %+v", w.Code, resp)
+	}
+}
+
+func TestJournalStreamLimit(t *testing.T) {
+	newFakeRunner(t)
+	for i := 0; i < journalStreamMaxPerUser; i++ {
+		if !acquireJournalStream("") {
+			t.Fatalf("第 %d 个日志流被拒绝", i+1)
+		}
+		defer releaseJournalStream("")
+	}
+	w := callHandler(streamLogs, http.MethodGet, "/logs/stream", true)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("超过每个用户的上限时返回 %d", w.Code)
+	}
+
+	// 其他用户受总数限制
+	var users []string
+	for i := journalStreamMaxPerUser; i < journalStreamMax; i++ {
+		user := fmt.Sprintf("user%d", i/journalStreamMaxPerUser)
+		if !acquireJournalStream(user) {
+			t.Fatalf("%s 的日志流被拒绝", user)
+		}
+		users = append(users, user)
+	}
+	if acquireJournalStream("other") {
+		t.Error("超过总数上限时应拒绝")
+	}
+	for _, user := range users {
+		releaseJournalStream(user)
+	}
+	if !acquireJournalStream("other") {
+		t.Error("释放后应可以打开")
+	}
+	releaseJournalStream("other")
+}
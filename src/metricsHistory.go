@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 系统指标历史：每次系统信息采样后记录 CPU、内存、磁盘、各网卡吞吐量和温度，
+// 每分钟汇总一次写入 metrics_minute，同时更新所在小时的 metrics_hour 汇总
+
+const (
+	metricsMinuteRetention = 25 * time.Hour      // 分钟数据保留时间，覆盖最近 24 小时的图表
+	metricsHourRetention   = 31 * 24 * time.Hour // 小时数据保留时间，覆盖最近 30 天的图表
+	metricsMaxPoints       = 5000                // 一次查询最多返回的点数
+)
+
+// 一个时间区间内的汇总值
+type MetricPoint struct {
+	Time int64   `json:"time"` // 区间起始时间，Unix 秒
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Avg  float64 `json:"avg"`
+}
+
+type MetricHistory struct {
+	Metric string        `json:"metric"`
+	From   int64         `json:"from"`
+	To     int64         `json:"to"`
+	Step   int64         `json:"step"` // 每个点的区间长度，秒
+	Points []MetricPoint `json:"points"`
+}
+
+// 一分钟内的样本汇总
+type metricAgg struct {
+	min, max, sum float64
+	count         int
+}
+
+func (a *metricAgg) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+// 汇总当前分钟的样本，进入下一分钟时写入数据库
+type metricsRecorder struct {
+	minute int64 // 当前分钟的起始时间
+	aggs   map[string]*metricAgg
+}
+
+func (r *metricsRecorder) record(ts time.Time, samples map[string]float64) error {
+	var err error
+	minute := ts.Unix() / 60 * 60
+	if minute != r.minute {
+		err = r.flush()
+		r.minute = minute
+	}
+	if r.aggs == nil {
+		r.aggs = make(map[string]*metricAgg)
+	}
+	for name, v := range samples {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		a := r.aggs[name]
+		if a == nil {
+			a = &metricAgg{}
+			r.aggs[name] = a
+		}
+		a.add(v)
+	}
+	return err
+}
+
+// 写入当前分钟的汇总，并重新计算所在小时的汇总，小时数据始终包含最近一分钟
+func (r *metricsRecorder) flush() error {
+	if len(r.aggs) == 0 {
+		return nil
+	}
+	aggs, minute := r.aggs, r.minute
+	r.aggs = nil
+
+	hour := minute / 3600 * 3600
+	now := time.Now()
+	return storeTx(func(tx *sql.Tx) error {
+		for name, a := range aggs {
+			_, err := tx.Exec(`INSERT OR REPLACE INTO metrics_minute (metric, ts, min, max, avg, count) VALUES (?, ?, ?, ?, ?, ?)`,
+				name, minute, a.min, a.max, a.sum/float64(a.count), a.count)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO metrics_hour (metric, ts, min, max, avg, count)
+			SELECT metric, ?, MIN(min), MAX(max), SUM(avg * count) / SUM(count), SUM(count)
+			FROM metrics_minute WHERE ts >= ? AND ts < ? GROUP BY metric`,
+			hour, hour, hour+3600)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM metrics_minute WHERE ts < ?", now.Add(-metricsMinuteRetention).Unix()); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM metrics_hour WHERE ts < ?", now.Add(-metricsHourRetention).Unix())
+		return err
+	})
+}
+
+// 采样状态：网卡吞吐量由两次采样的字节数之差计算
+type metricsSampler struct {
+	prevNet  map[string]NetworkStats
+	prevTime time.Time
+}
+
+// 由一次系统信息快照生成样本。指标名为 cpu、mem、disk（百分比），net.<网卡>.rx / net.<网卡>.tx（字节/秒）
+// 和 temp.<温区类型>（摄氏度），读取失败的指标本次不记录
+func (s *metricsSampler) sample(now time.Time, info SystemInfo) map[string]float64 {
+	samples := make(map[string]float64)
+	if len(info.CPUCores) > 0 {
+		samples["cpu"] = info.CPUUsage
+	}
+	if info.MemUsage > 0 {
+		samples["mem"] = info.MemUsage
+	}
+	if info.disk != nil && info.disk["total"] > 0 {
+		samples["disk"] = info.DiskUsage
+	}
+
+	if stats, err := getAllNetworkStats(); err == nil {
+		for name, v := range netThroughput(s.prevNet, stats, now.Sub(s.prevTime)) {
+			samples[name] = v
+		}
+		s.prevNet, s.prevTime = stats, now
+	}
+
+	for name, v := range info.Temperatures {
+		samples["temp."+name] = v
+	}
+	return samples
+}
+
+// 根据两次采样计算各网卡每秒收发字节数，忽略回环网卡和计数器归零的网卡
+func netThroughput(prev, curr map[string]NetworkStats, elapsed time.Duration) map[string]float64 {
+	result := make(map[string]float64)
+	if prev == nil || elapsed <= 0 {
+		return result
+	}
+	for name, c := range curr {
+		p, ok := prev[name]
+		if !ok || name == "lo" || c.RXBytes < p.RXBytes || c.TXBytes < p.TXBytes {
+			continue
+		}
+		result["net."+name+".rx"] = float64(c.RXBytes-p.RXBytes) / elapsed.Seconds()
+		result["net."+name+".tx"] = float64(c.TXBytes-p.TXBytes) / elapsed.Seconds()
+	}
+	return result
+}
+
+// 读取 /sys/class/thermal 下各温区的温度（摄氏度），键为温区类型，例如 cpu-thermal。
+// 没有类型或多个温区类型相同时使用温区目录名（thermal_zone0），避免互相覆盖，
+// MQTT 传感器名 temp_<键> 也因此不会重复
+func readTemperatures() map[string]float64 {
+	type zoneTemp struct {
+		zone, typ string
+		temp      float64
+	}
+	var found []zoneTemp
+	types := map[string]int{}
+	zones, _ := sysFS().Glob("/sys/class/thermal/thermal_zone*")
+	for _, zone := range zones {
+		data, err := sysFS().ReadFile(zone + "/temp")
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		if err != nil {
+			continue
+		}
+		z := zoneTemp{zone: filepath.Base(zone), temp: milli / 1000}
+		if t, err := sysFS().ReadFile(zone + "/type"); err == nil {
+			z.typ = strings.TrimSpace(string(t))
+		}
+		types[z.typ]++
+		found = append(found, z)
+	}
+
+	temps := make(map[string]float64)
+	for _, z := range found {
+		name := z.typ
+		if name == "" || types[name] > 1 {
+			name = z.zone
+		}
+		temps[name] = z.temp
+	}
+	return temps
+}
+
+// 指标历史采样子系统，退出时写入未满一分钟的样本
+func startMetricsHistory(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var sampler metricsSampler
+		var recorder metricsRecorder
+		updates, unsubscribe := sysInfoHub.subscribe()
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := recorder.flush(); err != nil {
+					log.Printf("保存指标历史失败: %v", err)
+				}
+				return
+			case info := <-updates:
+				now := time.Now()
+				if err := recorder.record(now, sampler.sample(now, info)); err != nil {
+					log.Printf("保存指标历史失败: %v", err)
+				}
+			}
+		}
+	}()
+	return done, nil
+}
+
+// 已记录的指标名
+func metricNames() ([]string, error) {
+	rows, err := stateDB.Query("SELECT DISTINCT metric FROM metrics_hour ORDER BY metric")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// 查询指标历史。默认查询最近 24 小时；step 不足一小时且时间范围在分钟数据的保留期内时使用分钟数据，
+// 否则使用小时数据，step 向上取整到所用数据的精度
+func metricsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		names, _ := metricNames()
+		respondErrorDetails(w, r, http.StatusBadRequest, errCodeBadRequest, "metric is required",
+			map[string]interface{}{"metrics": names})
+		return
+	}
+
+	now := time.Now().Unix()
+	from, to := now-24*3600, now
+	for _, p := range []struct {
+		name string
+		v    *int64
+	}{{"from", &from}, {"to", &to}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid "+p.name)
+				return
+			}
+			*p.v = t
+		}
+	}
+	if from >= to {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "from must be before to")
+		return
+	}
+
+	var step int64
+	if v := q.Get("step"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			d, derr := time.ParseDuration(v)
+			n, err = int64(d.Seconds()), derr
+		}
+		if err != nil || n <= 0 {
+			respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid step")
+			return
+		}
+		step = n
+	}
+
+	table, resolution := "metrics_minute", int64(60)
+	if step >= 3600 || from < now-int64(metricsMinuteRetention.Seconds()) {
+		table, resolution = "metrics_hour", 3600
+	}
+	if step == 0 {
+		step = resolution
+	}
+	step = (step + resolution - 1) / resolution * resolution
+	if (to-from)/step > metricsMaxPoints {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "step too small for the requested range")
+		return
+	}
+
+	rows, err := stateDB.QueryContext(r.Context(), `SELECT ts / ? * ? AS bucket, MIN(min), MAX(max), SUM(avg * count) / SUM(count)
+		FROM `+table+` WHERE metric = ? AND ts >= ? AND ts < ? GROUP BY bucket ORDER BY bucket`,
+		step, step, metric, from, to)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	history := MetricHistory{Metric: metric, From: from, To: to, Step: step, Points: []MetricPoint{}}
+	for rows.Next() {
+		var p MetricPoint
+		if err := rows.Scan(&p.Time, &p.Min, &p.Max, &p.Avg); err != nil {
+			respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		history.Points = append(history.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	respondData(w, r, http.StatusOK, history)
+}
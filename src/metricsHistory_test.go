@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 写入两小时前开始的样本：第一分钟 cpu 为 10、30，第二分钟为 50，下一小时为 70
+func recordTestMetrics(t *testing.T) time.Time {
+	t.Helper()
+	base := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	var rec metricsRecorder
+	for _, s := range []struct {
+		offset time.Duration
+		cpu    float64
+	}{
+		{0, 10},
+		{10 * time.Second, 30},
+		{time.Minute, 50},
+		{time.Hour, 70},
+	} {
+		if err := rec.record(base.Add(s.offset), map[string]float64{"cpu": s.cpu}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.flush(); err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestMetricsRecorderRollup(t *testing.T) {
+	useTestStore(t, nil)
+	base := recordTestMetrics(t).Unix()
+
+	var min, max, avg float64
+	var count int
+	err := stateDB.QueryRow("SELECT min, max, avg, count FROM metrics_minute WHERE metric = 'cpu' AND ts = ?", base).
+		Scan(&min, &max, &avg, &count)
+	if err != nil || min != 10 || max != 30 || avg != 20 || count != 2 {
+		t.Errorf("分钟汇总 = %v %v %v %v, %v", min, max, avg, count, err)
+	}
+
+	// 小时汇总按样本数加权平均
+	err = stateDB.QueryRow("SELECT min, max, avg, count FROM metrics_hour WHERE metric = 'cpu' AND ts = ?", base).
+		Scan(&min, &max, &avg, &count)
+	if err != nil || min != 10 || max != 50 || avg != 30 || count != 3 {
+		t.Errorf("小时汇总 = %v %v %v %v, %v", min, max, avg, count, err)
+	}
+	err = stateDB.QueryRow("SELECT avg FROM metrics_hour WHERE metric = 'cpu' AND ts = ?", base+3600).Scan(&avg)
+	if err != nil || avg != 70 {
+		t.Errorf("下一小时汇总 = %v, %v", avg, err)
+	}
+}
+
+func TestMetricsRetention(t *testing.T) {
+	useTestStore(t, nil)
+	old := time.Now().Add(-metricsHourRetention - time.Hour).Unix()
+	for _, table := range []string{"metrics_minute", "metrics_hour"} {
+		if _, err := stateDB.Exec("INSERT INTO "+table+" VALUES ('cpu', ?, 1, 1, 1, 1)", old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := metricsRecorder{}
+	if err := rec.record(time.Now(), map[string]float64{"mem": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"metrics_minute", "metrics_hour"} {
+		var n int
+		stateDB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE ts = ?", old).Scan(&n)
+		if n != 0 {
+			t.Errorf("%s 中的过期数据没有清除", table)
+		}
+	}
+}
+
+func TestMetricsHistoryHandler(t *testing.T) {
+	useTestStore(t, nil)
+	base := recordTestMetrics(t).Unix()
+	query := func(params string) (int, MetricHistory) {
+		w := callHandler(metricsHistoryHandler, http.MethodGet, "/metrics/history?"+params, true)
+		var resp struct {
+			Data MetricHistory `json:"data"`
+		}
+		if w.Code == http.StatusOK {
+			decodeBody(t, w, &resp)
+		}
+		return w.Code, resp.Data
+	}
+
+	// 分钟数据，两分钟一个点
+	code, h := query(fmt.Sprintf("metric=cpu&from=%d&to=%d&step=2m", base, base+7200))
+	if code != http.StatusOK {
+		t.Fatalf("状态码 %d", code)
+	}
+	want := []MetricPoint{{Time: base, Min: 10, Max: 50, Avg: 30}, {Time: base + 3600, Min: 70, Max: 70, Avg: 70}}
+	if h.Step != 120 || !reflect.DeepEqual(h.Points, want) {
+		t.Errorf("step=%d points=%+v", h.Step, h.Points)
+	}
+
+	// step 不足一分钟时取整到一分钟
+	if _, h := query(fmt.Sprintf("metric=cpu&from=%d&to=%d&step=10", base, base+120)); h.Step != 60 || len(h.Points) != 2 {
+		t.Errorf("step=%d points=%+v", h.Step, h.Points)
+	}
+
+	// 小时数据
+	if _, h := query(fmt.Sprintf("metric=cpu&from=%d&to=%d&step=3600", base, base+7200)); !reflect.DeepEqual(h.Points, want) {
+		t.Errorf("小时数据 points=%+v", h.Points)
+	}
+
+	// 默认查询最近 24 小时
+	if _, h := query("metric=cpu"); len(h.Points) != 3 || h.To-h.From != 24*3600 {
+		t.Errorf("默认范围 from=%d to=%d points=%d", h.From, h.To, len(h.Points))
+	}
+
+	for _, params := range []string{
+		"metric=cpu&step=abc",
+		"metric=cpu&from=xyz",
+		fmt.Sprintf("metric=cpu&from=%d&to=%d", base, base),
+		"metric=cpu&from=0&step=60",
+	} {
+		if code, _ := query(params); code != http.StatusBadRequest {
+			t.Errorf("%s: 状态码 %d，期望 400", params, code)
+		}
+	}
+
+	// 不指定 metric 时返回可用的指标
+	w := callHandler(metricsHistoryHandler, http.MethodGet, "/metrics/history", true)
+	var errResp struct {
+		Error apiError `json:"error"`
+	}
+	decodeBody(t, w, &errResp)
+	if w.Code != http.StatusBadRequest || !reflect.DeepEqual(errResp.Error.Details["metrics"], []interface{}{"cpu"}) {
+		t.Errorf("状态码 %d，details=%v", w.Code, errResp.Error.Details)
+	}
+}
+
+func TestNetThroughput(t *testing.T) {
+	prev, err := parseNetDev(procNetDev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	curr := map[string]NetworkStats{
+		"lo":    {RXBytes: 5000, TXBytes: 5000},
+		"wlan0": {RXBytes: 123456 + 2000, TXBytes: 65432 + 1000},
+		"eth0":  {RXBytes: 10, TXBytes: 10}, // 新出现的网卡
+	}
+	got := netThroughput(prev, curr, 2*time.Second)
+	want := map[string]float64{"net.wlan0.rx": 1000, "net.wlan0.tx": 500}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("netThroughput() = %v", got)
+	}
+
+	// 计数器归零（网卡重新加载）时跳过
+	if got := netThroughput(curr, prev, time.Second); len(got) != 0 {
+		t.Errorf("计数器归零时 netThroughput() = %v", got)
+	}
+}
+
+func TestReadTemperatures(t *testing.T) {
+	useSandboxRoot(t, map[string]string{
+		"/sys/class/thermal/thermal_zone0/temp": "45250\n",
+		"/sys/class/thermal/thermal_zone0/type": "cpu-thermal\n",
+		"/sys/class/thermal/thermal_zone1/temp": "38000\n",
+		// 类型相同的温区使用目录名
+		"/sys/class/thermal/thermal_zone2/temp": "50000\n",
+		"/sys/class/thermal/thermal_zone2/type": "soc-thermal\n",
+		"/sys/class/thermal/thermal_zone3/temp": "52000\n",
+		"/sys/class/thermal/thermal_zone3/type": "soc-thermal\n",
+	})
+	want := map[string]float64{"cpu-thermal": 45.25, "thermal_zone1": 38, "thermal_zone2": 50, "thermal_zone3": 52}
+	if got := readTemperatures(); !reflect.DeepEqual(got, want) {
+		t.Errorf("readTemperatures() = %v", got)
+	}
+}
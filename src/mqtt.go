@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,31 +13,6 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-// 默认参数文件路径
-const defaultConfigFile = "./HaPerfMonitor_config.json"
-
-type Config struct {
-	Server   string `json:"server"`
-	Port     string `json:"port"`
-	User     string `json:"user"`
-	Pass     string `json:"pass"`
-	ClientID string `json:"client_id"`
-}
-
-// 读取配置文件
-func loadConfigFile(path string) (*Config, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	var cfg Config
-	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-		return nil, err
-	}
-	return &cfg, nil
-}
-
 func getsysLedStatus(ledName string) map[string]string {
 	// 获取系统LED状态
 	ledStatus := make(map[string]string)
@@ -139,19 +114,19 @@ func resigterLed(ledName string, client *hamqtt.MQTTClient) {
 
 func mqttStartLed(client *hamqtt.MQTTClient) {
 	if client == nil {
-		fmt.Println("MQTT client is nil, cannot register LED sensor")
+		log.Println("MQTT client is nil, cannot register LED sensor")
 		return
 	}
 
 	// 使用 Go 的 Glob 函数匹配路径
-	ledPaths, err := filepath.Glob("/sys/class/leds/*")
+	ledPaths, err := sysFS().Glob("/sys/class/leds/*")
 	if err != nil {
-		fmt.Printf("查找LED设备失败: %v\n", err)
+		log.Printf("查找LED设备失败: %v", err)
 		return
 	}
 
 	if len(ledPaths) == 0 {
-		fmt.Println("未找到任何LED设备")
+		log.Println("未找到任何LED设备")
 		return
 	}
 
@@ -161,20 +136,14 @@ func mqttStartLed(client *hamqtt.MQTTClient) {
 		}
 		ledName := filepath.Base(ledPath) // 提取LED名称
 		resigterLed(ledName, client)
-		fmt.Println("LED " + ledName + " registered successfully")
+		log.Println("LED " + ledName + " registered successfully")
 	}
 
 }
 
-func HaPerMonitor(configPath string) {
-	// 支持 -c 指定参数文件路径
-
-	cfg, err := loadConfigFile(configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "配置文件读取失败: %v\n", err)
-		return
-	}
-
+// 连接 MQTT 并注册传感器，首次连接失败时返回错误并在后台继续重试。
+// ctx 结束后断开连接并关闭返回的 channel
+func HaPerMonitor(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
 	mqttCfg := hamqtt.MQTTConfig{
 		Server:   cfg.Server,
 		Port:     cfg.Port,
@@ -182,23 +151,42 @@ func HaPerMonitor(configPath string) {
 		Pass:     cfg.Pass,
 		ClientID: cfg.ClientID,
 	}
-	var client *hamqtt.MQTTClient
-	var i int
-	for i = 0; i < 20; i++ {
-		client, err = hamqtt.NewMQTTClient(mqttCfg)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "MQTT连接失败: %v\n", err)
-			time.Sleep(10 * time.Second)
-			continue
-		} else {
-			fmt.Println("MQTT连接成功")
-			break
-		}
-	}
-	if i >= 20 {
-		fmt.Println("MQTT连接失败，退出连接")
-		return
+	client, firstErr := hamqtt.NewMQTTClient(mqttCfg)
+	if firstErr != nil {
+		log.Printf("MQTT连接失败: %v", firstErr)
 	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := firstErr
+		for i := 1; err != nil; i++ {
+			if i >= 20 {
+				log.Println("MQTT连接失败，退出连接")
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+			client, err = hamqtt.NewMQTTClient(mqttCfg)
+			if err != nil {
+				log.Printf("MQTT连接失败: %v", err)
+			}
+		}
+		log.Println("MQTT连接成功")
+		defer client.Stop()
+		registerMQTTSensors(client)
+		log.Println("连接成功，开始上报系统信息...")
+
+		<-ctx.Done()
+		log.Println("MQTT断开连接")
+	}()
+	return done, firstErr
+}
+
+func registerMQTTSensors(client *hamqtt.MQTTClient) {
 	client.RegisterSensor(
 		hamqtt.MqttEntity{
 			Name:              "disk_usage",
@@ -209,7 +197,7 @@ func HaPerMonitor(configPath string) {
 		},
 		nil, // no command handler
 		func() interface{} {
-			return getDiskUsage() // return current sensor value
+			return currentSystemInfo().disk // return current sensor value
 		})
 	client.RegisterSensor(
 		hamqtt.MqttEntity{
@@ -231,10 +219,90 @@ func HaPerMonitor(configPath string) {
 		},
 		nil, // no command handler
 		nil)
+	registerSystemSensors(client)
 	mqttStartLed(client)
-	defer client.Stop()
-	fmt.Println("连接成功，开始上报系统信息...")
+}
+
+// 系统信息传感器共用 mqttSystemState 返回的状态，各核和各温区的传感器按注册时检测到的数量创建
+func registerSystemSensors(client *hamqtt.MQTTClient) {
+	info := currentSystemInfo()
+	type sensor struct{ name, desc, class, unit string }
+	sensors := []sensor{
+		{"cpu_usage", "CPU Usage", "", "%"},
+		{"mem_usage", "Memory Usage", "", "%"},
+		{"swap_usage", "Swap Usage", "", "%"},
+		{"load1", "Load Average (1m)", "", ""},
+		{"load5", "Load Average (5m)", "", ""},
+		{"load15", "Load Average (15m)", "", ""},
+		{"uptime", "Uptime", "duration", "s"},
+		{"throttle", "CPU Throttle Reasons", "", ""},
+	}
+	for i := range info.CPUCores {
+		sensors = append(sensors, sensor{fmt.Sprintf("cpu%d_usage", i), fmt.Sprintf("CPU%d Usage", i), "", "%"})
+	}
+	for _, f := range info.CPUFreqs {
+		sensors = append(sensors, sensor{fmt.Sprintf("cpu%d_freq", f.Core), fmt.Sprintf("CPU%d Frequency", f.Core), "frequency", "MHz"})
+	}
+	for zone := range info.Temperatures {
+		sensors = append(sensors, sensor{"temp_" + mqttStateKey(zone), zone + " Temperature", "temperature", "°C"})
+	}
 
-	// 阻塞主线程
-	select {}
+	for i, s := range sensors {
+		var value func() interface{}
+		if i == 0 {
+			value = func() interface{} {
+				return mqttSystemState(currentSystemInfo()) // return current sensor value
+			}
+		}
+		client.RegisterSensor(
+			hamqtt.MqttEntity{
+				Name:              s.name,
+				Description:       s.desc,
+				DeviceClass:       s.class,
+				UnitOfMeasurement: s.unit,
+				ValueTemplate:     "value_json." + s.name,
+			},
+			nil, // no command handler
+			value)
+	}
+}
+
+// 将系统信息展开为一层的键值，供传感器的 value_json 使用
+func mqttSystemState(info SystemInfo) map[string]interface{} {
+	state := map[string]interface{}{
+		"cpu_usage":  info.CPUUsage,
+		"mem_usage":  info.MemUsage,
+		"swap_usage": info.SwapUsage,
+		"load1":      info.Load.Load1,
+		"load5":      info.Load.Load5,
+		"load15":     info.Load.Load15,
+		"uptime":     info.Uptime,
+		"throttle":   "none",
+	}
+	if info.Throttle.Throttled {
+		state["throttle"] = strings.Join(info.Throttle.Reasons, ",")
+	}
+	for i, usage := range info.CPUCores {
+		state[fmt.Sprintf("cpu%d_usage", i)] = usage
+	}
+	for _, f := range info.CPUFreqs {
+		state[fmt.Sprintf("cpu%d_freq", f.Core)] = f.Cur
+	}
+	for zone, temp := range info.Temperatures {
+		state["temp_"+mqttStateKey(zone)] = temp
+	}
+	return state
+}
+
+// 温区类型中的 - 等字符不能出现在 value_json 的属性名中，统一替换为下划线
+func mqttStateKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, name)
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenAPI 文档根据路由表生成，路由的请求体和响应类型通过反射转换为 JSON Schema
+const openAPIPath = "/api/openapi.json"
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	roleType       = reflect.TypeOf(Role(""))
+)
+
+type openAPIBuilder struct {
+	schemas map[string]interface{} // components/schemas
+}
+
+// 生成 OpenAPI 3 文档
+func buildOpenAPI(routes []*apiRoute) map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{}}
+
+	paths := map[string]map[string]interface{}{}
+	for _, rt := range routes {
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]interface{}{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = b.operation(rt)
+	}
+
+	b.schemas["Error"] = map[string]interface{}{
+		"type":     "object",
+		"required": []string{"ok", "error"},
+		"properties": map[string]interface{}{
+			"ok": map[string]interface{}{"type": "boolean", "enum": []bool{false}},
+			"error": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"code", "message"},
+				"properties": map[string]interface{}{
+					"code":    map[string]interface{}{"type": "string", "description": "机器可读的错误码"},
+					"message": map[string]interface{}{"type": "string"},
+					"details": map[string]interface{}{"type": "object", "additionalProperties": true},
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "AssistMgr API",
+			"version": "1",
+		},
+		"servers": []map[string]string{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				// 访问 Token 直接放在 Authorization 头中，不带 Bearer 前缀
+				"token":      map[string]string{"type": "apiKey", "in": "header", "name": "Authorization"},
+				"tokenQuery": map[string]string{"type": "apiKey", "in": "query", "name": "token"},
+				"apiKey":     map[string]string{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	}
+}
+
+func (b *openAPIBuilder) operation(rt *apiRoute) map[string]interface{} {
+	op := map[string]interface{}{
+		"summary":     rt.Summary,
+		"operationId": strings.ToLower(rt.Method) + strings.ReplaceAll(strings.ReplaceAll(rt.Path, "/", "_"), "-", "_"),
+		"tags":        []string{strings.Split(strings.TrimPrefix(rt.Path, "/"), "/")[0]},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "成功",
+				"content":     b.responseContent(rt.Response),
+			},
+			"default": map[string]interface{}{
+				"description": "失败",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]string{"$ref": "#/components/schemas/Error"},
+					},
+				},
+			},
+		},
+	}
+
+	if rt.Public {
+		op["security"] = []map[string][]string{}
+	} else {
+		security := []map[string][]string{{"token": {}}}
+		if rt.TokenQuery {
+			security = append(security, map[string][]string{"tokenQuery": {}})
+		}
+		if rt.Scope != "" {
+			security = append(security, map[string][]string{"apiKey": {}})
+		}
+		op["security"] = security
+		op["x-role"] = rt.Role
+	}
+	if rt.Scope != "" {
+		op["x-api-key-scope"] = rt.Scope
+	}
+	if rt.Legacy != "" {
+		op["x-legacy-path"] = rt.Legacy
+	}
+	// WebSocket 握手成功时返回 101，推送的消息格式放在 x-websocket-message 中
+	if v, ok := rt.Response.(webSocket); ok {
+		responses := op["responses"].(map[string]interface{})
+		delete(responses, "200")
+		responses["101"] = map[string]interface{}{"description": "切换为 WebSocket 协议"}
+		op["x-websocket-message"] = b.schema(reflect.TypeOf(v.Message))
+	}
+
+	if len(rt.Query) > 0 {
+		params := make([]map[string]interface{}, 0, len(rt.Query))
+		for _, q := range rt.Query {
+			params = append(params, map[string]interface{}{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"required":    q.Required,
+				"schema":      map[string]string{"type": "string"},
+			})
+		}
+		op["parameters"] = params
+	}
+
+	if content := b.requestContent(rt.Request); len(content) > 0 {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content,
+		}
+	}
+	return op
+}
+
+// 成功响应的内容类型，事件流的 schema 描述每个事件的 data
+func (b *openAPIBuilder) responseContent(resp interface{}) map[string]interface{} {
+	switch v := resp.(type) {
+	case eventStream:
+		return map[string]interface{}{
+			"text/event-stream": map[string]interface{}{"schema": b.schema(reflect.TypeOf(v.Event))},
+		}
+	case textResponse:
+		return map[string]interface{}{
+			v.ContentType: map[string]interface{}{"schema": map[string]string{"type": "string"}},
+		}
+	case webSocket:
+		return nil
+	}
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"ok", "data"},
+				"properties": map[string]interface{}{
+					"ok":   map[string]interface{}{"type": "boolean", "enum": []bool{true}},
+					"data": b.schema(reflect.TypeOf(resp)),
+				},
+			},
+		},
+	}
+}
+
+// 请求体的各种内容类型
+func (b *openAPIBuilder) requestContent(req interface{}) map[string]interface{} {
+	content := map[string]interface{}{}
+	switch v := req.(type) {
+	case nil, noBody:
+	case textBody:
+		content["text/plain"] = map[string]interface{}{"schema": map[string]string{"type": "string"}}
+	case multipartBody:
+		props := map[string]interface{}{}
+		for _, f := range v.Files {
+			props[f] = map[string]string{"type": "string", "format": "binary"}
+		}
+		content["multipart/form-data"] = map[string]interface{}{"schema": map[string]interface{}{
+			"type":       "object",
+			"required":   v.Files,
+			"properties": props,
+		}}
+	case formBody:
+		content["application/x-www-form-urlencoded"] = map[string]interface{}{"schema": b.schema(reflect.TypeOf(v.Fields))}
+	case requestBodies:
+		for _, r := range v {
+			for k, c := range b.requestContent(r) {
+				content[k] = c
+			}
+		}
+	default:
+		content["application/json"] = map[string]interface{}{"schema": b.schema(reflect.TypeOf(req))}
+	}
+	return content
+}
+
+// Go 类型转换为 JSON Schema，具名结构体放入 components 并返回引用
+func (b *openAPIBuilder) schema(t reflect.Type) interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]string{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case t == roleType:
+		return map[string]interface{}{"type": "string", "enum": []Role{RoleViewer, RoleOperator, RoleAdmin}}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]string{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]string{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]string{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]string{"type": "number"}
+	case reflect.String:
+		return map[string]string{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]string{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			b.schemas[t.Name()] = nil // 先占位，防止递归类型死循环
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return map[string]string{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	b.addFields(t, props, &required)
+	sort.Strings(required)
+
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// 按 encoding/json 的规则展开字段，匿名嵌入的结构体字段提升到外层
+func (b *openAPIBuilder) addFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.addFields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// 返回 OpenAPI 文档，无需登录
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	apiRoutesLock.Lock()
+	routes := append([]*apiRoute(nil), apiRoutes...)
+	apiRoutesLock.Unlock()
+
+	respondJSON(w, http.StatusOK, buildOpenAPI(routes))
+}
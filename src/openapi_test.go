@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 每条路由都必须声明文档所需的摘要、请求体和响应类型
+func TestRoutesHaveSchema(t *testing.T) {
+	seen := map[string]bool{}
+	for _, rt := range apiRouteTable() {
+		name := rt.Method + " " + rt.Path
+		if seen[name] {
+			t.Errorf("%s: 重复注册", name)
+		}
+		seen[name] = true
+
+		if rt.Handler == nil {
+			t.Errorf("%s: 缺少 Handler", name)
+		}
+		if rt.Summary == "" {
+			t.Errorf("%s: 缺少 Summary", name)
+		}
+		if rt.Response == nil {
+			t.Errorf("%s: 缺少 Response", name)
+		}
+		if rt.Method != http.MethodGet && rt.Request == nil {
+			t.Errorf("%s: 缺少 Request，没有请求体时使用 noBody{}", name)
+		}
+		if rt.Method == http.MethodGet && rt.Request != nil {
+			t.Errorf("%s: GET 请求不应有请求体", name)
+		}
+		if !rt.Public && rt.Role == "" {
+			t.Errorf("%s: 需要登录的路由缺少 Role", name)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	routes := apiRouteTable()
+	data, err := json.Marshal(buildOpenAPI(routes))
+	if err != nil {
+		t.Fatalf("序列化 OpenAPI 文档失败: %v", err)
+	}
+
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("解析 OpenAPI 文档失败: %v", err)
+	}
+
+	for _, rt := range routes {
+		if _, ok := doc.Paths[rt.Path][strings.ToLower(rt.Method)]; !ok {
+			t.Errorf("文档中缺少 %s %s", rt.Method, rt.Path)
+		}
+	}
+
+	// 不返回 JSON 的接口同样在文档中，并标明各自的响应类型
+	for path, want := range map[string]string{
+		"/logs/stream": `"text/event-stream"`,
+		"/metrics":     `"text/plain`,
+		"/ws":          `"101"`,
+	} {
+		if op := string(doc.Paths[path]["get"]); !strings.Contains(op, want) {
+			t.Errorf("GET %s 的文档中缺少 %s: %s", path, want, op)
+		}
+	}
+
+	// 所有引用都必须指向已定义的组件
+	const prefix = `"#/components/schemas/`
+	s := string(data)
+	for {
+		i := strings.Index(s, prefix)
+		if i < 0 {
+			break
+		}
+		s = s[i+len(prefix):]
+		name := s[:strings.IndexByte(s, '"')]
+		if raw, ok := doc.Components.Schemas[name]; !ok || string(raw) == "null" {
+			t.Errorf("引用的组件 %s 未定义", name)
+		}
+	}
+}
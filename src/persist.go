@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// 状态文件的持久化。设备经常直接断电，直接 os.WriteFile 可能留下截断的文件，
+// 因此写入时先写临时文件并 fsync，再替换原文件，原文件保留为 .bak；
+// 读取时解析失败则从 .bak 恢复
+
+// 备份文件路径
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+// 原子写入文件，写入前把当前文件保留为 .bak
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return replaceFile(path, data, perm, true)
+}
+
+// 写临时文件 → fsync → rename → fsync 目录。keepBackup 为 true 时先把原文件改名为 .bak，
+// 两次 rename 之间断电时原文件不存在，读取时会从 .bak 恢复
+func replaceFile(path string, data []byte, perm os.FileMode, keepBackup bool) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// 成功 rename 后临时文件已不存在，Remove 不会有影响
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if keepBackup {
+		if err := os.Rename(path, backupPath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rename 只有在目录 fsync 之后才能保证落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// 读取文件并交给 parse 解析。文件不存在、无法读取或 parse 返回错误时尝试 .bak，
+// .bak 解析成功则用它恢复原文件；两者都失败时返回原文件的错误，
+// 文件不存在时可以用 errors.Is(err, fs.ErrNotExist) 判断
+func readFileRecover(path string, parse func(data []byte) error) error {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err = parse(data); err == nil {
+			return nil
+		}
+		err = fmt.Errorf("解析 %s 失败: %w", path, err)
+		log.Print(err)
+	}
+
+	bak := backupPath(path)
+	bakData, bakErr := os.ReadFile(bak)
+	if bakErr != nil {
+		return err
+	}
+	if bakErr := parse(bakData); bakErr != nil {
+		log.Printf("备份文件 %s 同样无效: %v", bak, bakErr)
+		return err
+	}
+
+	log.Printf("%s 已从备份文件恢复", path)
+	perm := fs.FileMode(0600)
+	if info, statErr := os.Stat(bak); statErr == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := replaceFile(path, bakData, perm, false); err != nil {
+		log.Printf("恢复 %s 失败: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// 解析 JSON 对象，用于检查恢复逻辑
+func parseJSONObject(v *map[string]int) func([]byte) error {
+	return func(data []byte) error {
+		*v = nil
+		return json.Unmarshal(data, v)
+	}
+}
+
+func TestWriteFileAtomicKeepsBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	if err := writeFileAtomic(path, []byte(`{"a":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backupPath(path)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("首次写入不应产生 .bak: %v", err)
+	}
+
+	if err := writeFileAtomic(path, []byte(`{"a":2}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, path); got != `{"a":2}` {
+		t.Errorf("文件内容 = %q", got)
+	}
+	if got := readString(t, backupPath(path)); got != `{"a":1}` {
+		t.Errorf(".bak 内容 = %q", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("权限 = %v", info.Mode().Perm())
+	}
+
+	// 不应残留临时文件
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Errorf("目录中有 %d 个文件，期望只有原文件和 .bak", len(entries))
+	}
+}
+
+func TestReadFileRecover(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary string // 为空表示不存在
+		backup  string
+		want    int
+		wantErr bool
+	}{
+		{name: "原文件有效", primary: `{"a":2}`, backup: `{"a":1}`, want: 2},
+		{name: "原文件截断", primary: `{"a":`, backup: `{"a":1}`, want: 1},
+		{name: "原文件缺失", backup: `{"a":1}`, want: 1},
+		{name: "都无效", primary: `{"a":`, backup: `{`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.primary != "" {
+				os.WriteFile(path, []byte(tt.primary), 0600)
+			}
+			os.WriteFile(backupPath(path), []byte(tt.backup), 0600)
+
+			var v map[string]int
+			err := readFileRecover(path, parseJSONObject(&v))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("期望返回错误")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v["a"] != tt.want {
+				t.Errorf("a = %d，期望 %d", v["a"], tt.want)
+			}
+			// 从备份恢复后原文件应与备份一致
+			if tt.want == 1 && readString(t, path) != tt.backup {
+				t.Errorf("原文件没有从备份恢复: %q", readString(t, path))
+			}
+		})
+	}
+}
+
+func TestReadFileRecoverNotExist(t *testing.T) {
+	var v map[string]int
+	err := readFileRecover(filepath.Join(t.TempDir(), "missing.json"), parseJSONObject(&v))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v，期望 fs.ErrNotExist", err)
+	}
+}
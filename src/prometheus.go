@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus 文本格式（0.0.4）的 /metrics 接口。配置了 prometheus.token 时需要
+// Authorization: Bearer <token>，否则无需登录
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 升级状态，upgrade_status 指标对每个状态输出一条，当前状态为 1
+var upgradeStatuses = []string{"idle", "uploading", "downloading", "installing", "done", "failed", "cancelled"}
+
+// 接口耗时直方图的上界（秒），与 Prometheus 客户端库的默认值一致
+var httpDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 一组标签下的接口耗时
+type httpDuration struct {
+	counts []uint64 // 对应 httpDurationBuckets，不累加
+	sum    float64
+	count  uint64
+}
+
+type httpRequestKey struct {
+	route, method, code string
+}
+
+// 接口请求计数和耗时，路由统一使用 /api/v1 下的路径，旧路径计入同一路由
+var (
+	httpStatsLock     sync.Mutex
+	httpRequestCounts = map[httpRequestKey]uint64{}
+	httpDurations     = map[[2]string]*httpDuration{} // 键为路由和请求方法
+)
+
+func observeHTTPRequest(route, method string, status int, elapsed time.Duration) {
+	httpStatsLock.Lock()
+	defer httpStatsLock.Unlock()
+
+	httpRequestCounts[httpRequestKey{route, method, strconv.Itoa(status)}]++
+
+	key := [2]string{route, method}
+	d := httpDurations[key]
+	if d == nil {
+		d = &httpDuration{counts: make([]uint64, len(httpDurationBuckets))}
+		httpDurations[key] = d
+	}
+	seconds := elapsed.Seconds()
+	for i, le := range httpDurationBuckets {
+		if seconds <= le {
+			d.counts[i]++
+			break
+		}
+	}
+	d.sum += seconds
+	d.count++
+}
+
+// 记录状态码，未调用 WriteHeader 时为 200
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WebSocket 升级需要接管连接，记为 101
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// 统计接口请求数和耗时，包括鉴权失败的请求。
+// 旧路径不检查请求方法，与路由不一致的方法统一记为 other，避免任意方法名产生无限多的序列
+func metricsMiddleware(rt *apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	route := apiPrefix + rt.Path
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			method := rt.Method
+			if r.Method != rt.Method {
+				method = "other"
+			}
+			observeHTTPRequest(route, method, status, time.Since(start))
+		}()
+		next(sw, r)
+	}
+}
+
+// 按 Prometheus 文本格式输出，同名指标必须连续写入
+type promWriter struct {
+	buf bytes.Buffer
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	fmt.Fprintf(&p.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labels 依次为标签名和值
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.buf.WriteString(name)
+	if len(labels) > 0 {
+		p.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&p.buf, "%s=\"%s\"", labels[i], promLabelReplacer.Replace(labels[i+1]))
+		}
+		p.buf.WriteByte('}')
+	}
+	p.buf.WriteByte(' ')
+	p.buf.WriteString(formatPromValue(value))
+	p.buf.WriteByte('\n')
+}
+
+// 只有一个样本的指标
+func (p *promWriter) single(name, typ, help string, value float64, labels ...string) {
+	p.header(name, typ, help)
+	p.sample(name, value, labels...)
+}
+
+var promLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatPromValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := getConfig().Prometheus
+	if !cfg.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if cfg.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var p promWriter
+	writeSystemMetrics(&p, r)
+	writeUpgradeMetrics(&p)
+	writeHTTPMetrics(&p)
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.Write(p.buf.Bytes())
+}
+
+func writeSystemMetrics(p *promWriter, r *http.Request) {
+	info := currentSystemInfo()
+	p.single("assismgr_cpu_usage_percent", "gauge", "CPU usage in percent.", info.CPUUsage)
+	p.single("assismgr_memory_usage_percent", "gauge", "Memory usage in percent.", info.MemUsage)
+	p.single("assismgr_disk_usage_percent", "gauge", "Disk usage in percent.", info.DiskUsage)
+
+	if disks := info.disk; disks != nil {
+		const gib = 1 << 30
+		p.single("assismgr_disk_size_bytes", "gauge", "Size of the disk holding the data directory.", disks["total"]*gib)
+		p.single("assismgr_disk_used_bytes", "gauge", "Used bytes on the disk holding the data directory.", disks["usage"]*gib)
+		p.single("assismgr_disk_free_bytes", "gauge", "Free bytes on the disk holding the data directory.", disks["free"]*gib)
+	}
+
+	if stats, err := getAllNetworkStats(); err == nil {
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		p.header("assismgr_network_receive_bytes_total", "counter", "Bytes received per interface.")
+		for _, name := range names {
+			p.sample("assismgr_network_receive_bytes_total", float64(stats[name].RXBytes), "interface", name)
+		}
+		p.header("assismgr_network_transmit_bytes_total", "counter", "Bytes transmitted per interface.")
+		for _, name := range names {
+			p.sample("assismgr_network_transmit_bytes_total", float64(stats[name].TXBytes), "interface", name)
+		}
+	}
+	p.single("assismgr_network_online", "gauge", "Whether the last connectivity check succeeded.", boolValue(isOnlineStatus.Load()))
+
+	type serviceState struct{ active, enabled bool }
+	states := make([]serviceState, len(availableServices))
+	for i, name := range availableServices {
+		states[i] = serviceState{isServiceActive(r.Context(), name), isServiceEnable(r.Context(), name)}
+	}
+	p.header("assismgr_service_active", "gauge", "Whether the systemd service is active.")
+	for i, name := range availableServices {
+		p.sample("assismgr_service_active", boolValue(states[i].active), "service", name)
+	}
+	p.header("assismgr_service_enabled", "gauge", "Whether the systemd service is enabled.")
+	for i, name := range availableServices {
+		p.sample("assismgr_service_enabled", boolValue(states[i].enabled), "service", name)
+	}
+}
+
+func writeUpgradeMetrics(p *promWriter) {
+	upgradeProgressLock.Lock()
+	status, progress := upgradeStatus, upgradeProgress
+	upgradeProgressLock.Unlock()
+	if status == "" {
+		status = "idle"
+	}
+
+	p.header("assismgr_upgrade_status", "gauge", "Current upgrade state, 1 for the active state.")
+	for _, s := range upgradeStatuses {
+		p.sample("assismgr_upgrade_status", boolValue(s == status), "status", s)
+	}
+	p.single("assismgr_upgrade_progress_percent", "gauge", "Progress of the current upgrade.", float64(progress))
+	p.single("assismgr_upgrade_installing", "gauge", "Number of RAUC installs in progress.", float64(raucInstalling.Load()))
+}
+
+func writeHTTPMetrics(p *promWriter) {
+	httpStatsLock.Lock()
+	defer httpStatsLock.Unlock()
+
+	keys := make([]httpRequestKey, 0, len(httpRequestCounts))
+	for k := range httpRequestCounts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	p.header("assismgr_http_requests_total", "counter", "HTTP requests by route, method and status code.")
+	for _, k := range keys {
+		p.sample("assismgr_http_requests_total", float64(httpRequestCounts[k]), "route", k.route, "method", k.method, "code", k.code)
+	}
+
+	durationKeys := make([][2]string, 0, len(httpDurations))
+	for k := range httpDurations {
+		durationKeys = append(durationKeys, k)
+	}
+	sort.Slice(durationKeys, func(i, j int) bool {
+		if durationKeys[i][0] != durationKeys[j][0] {
+			return durationKeys[i][0] < durationKeys[j][0]
+		}
+		return durationKeys[i][1] < durationKeys[j][1]
+	})
+	const name = "assismgr_http_request_duration_seconds"
+	p.header(name, "histogram", "HTTP request latency by route and method.")
+	for _, k := range durationKeys {
+		d := httpDurations[k]
+		var cumulative uint64
+		for i, le := range httpDurationBuckets {
+			cumulative += d.counts[i]
+			p.sample(name+"_bucket", float64(cumulative), "route", k[0], "method", k[1], "le", formatPromValue(le))
+		}
+		p.sample(name+"_bucket", float64(d.count), "route", k[0], "method", k[1], "le", "+Inf")
+		p.sample(name+"_sum", d.sum, "route", k[0], "method", k[1])
+		p.sample(name+"_count", float64(d.count), "route", k[0], "method", k[1])
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromWriter(t *testing.T) {
+	var p promWriter
+	p.single("test_value", "gauge", "Test value.", 1.5, "name", "a\"b\\c\nd")
+	p.sample("test_value", 1e21)
+	want := "# HELP test_value Test value.\n# TYPE test_value gauge\n" +
+		`test_value{name="a\"b\\c\nd"} 1.5` + "\n" +
+		"test_value 1e+21\n"
+	if got := p.buf.String(); got != want {
+		t.Errorf("输出:\n%s\n期望:\n%s", got, want)
+	}
+}
+
+func TestHTTPDurationHistogram(t *testing.T) {
+	const route = "/api/v1/test/histogram"
+	observeHTTPRequest(route, "GET", 200, 3*time.Millisecond)
+	observeHTTPRequest(route, "GET", 200, 200*time.Millisecond)
+	observeHTTPRequest(route, "GET", 500, 20*time.Second)
+
+	var p promWriter
+	writeHTTPMetrics(&p)
+	out := p.buf.String()
+	for _, want := range []string{
+		`assismgr_http_requests_total{route="/api/v1/test/histogram",method="GET",code="200"} 2`,
+		`assismgr_http_requests_total{route="/api/v1/test/histogram",method="GET",code="500"} 1`,
+		`assismgr_http_request_duration_seconds_bucket{route="/api/v1/test/histogram",method="GET",le="0.005"} 1`,
+		`assismgr_http_request_duration_seconds_bucket{route="/api/v1/test/histogram",method="GET",le="0.1"} 1`,
+		`assismgr_http_request_duration_seconds_bucket{route="/api/v1/test/histogram",method="GET",le="0.25"} 2`,
+		`assismgr_http_request_duration_seconds_bucket{route="/api/v1/test/histogram",method="GET",le="10"} 2`,
+		`assismgr_http_request_duration_seconds_bucket{route="/api/v1/test/histogram",method="GET",le="+Inf"} 3`,
+		`assismgr_http_request_duration_seconds_count{route="/api/v1/test/histogram",method="GET"} 3`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("缺少 %q", want)
+		}
+	}
+	if strings.Count(out, "# TYPE assismgr_http_request_duration_seconds histogram") != 1 {
+		t.Error("同一指标的 TYPE 只能出现一次")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// 可以在运行时重启的后台子系统
+type subsystem struct {
+	name    string
+	enabled func(cfg *Config) bool
+	changed func(old, new *Config) bool
+	// 同步完成初始化，后台任务在 ctx 结束后退出并关闭返回的 channel。
+	// 同时返回 channel 和错误表示已启动但首次连接失败，后台继续重试
+	start func(ctx context.Context, cfg *Config) (<-chan struct{}, error)
+
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+func (s *subsystem) run(parent context.Context, cfg *Config) error {
+	ctx, cancel := context.WithCancel(parent)
+	done, err := s.start(ctx, cfg)
+	if done == nil {
+		cancel()
+		return err
+	}
+	s.cancel = cancel
+	s.done = done
+	return err
+}
+
+// 停止子系统并等待后台任务退出
+func (s *subsystem) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+	s.done = nil
+}
+
+func (s *subsystem) running() bool {
+	return s.cancel != nil
+}
+
+// 重载结果
+type ReloadResult struct {
+	Reloaded        []string          `json:"reloaded"`
+	Failed          map[string]string `json:"failed"`
+	Retrying        map[string]string `json:"retrying"` // 已重启但还没有连接成功，值为首次连接的错误
+	RestartRequired []string          `json:"restart_required"`
+}
+
+var (
+	subsystemsLock sync.Mutex
+	subsystemsCtx  context.Context // 所有子系统的父 context，进程退出时取消
+	subsystemsDone bool            // 已调用 stopSubsystems，不再允许重载
+	subsystems     = []*subsystem{
+		{
+			name:    "mqtt",
+			enabled: func(cfg *Config) bool { return cfg.Features.MQTT },
+			changed: func(old, new *Config) bool {
+				return old.Server != new.Server || old.Port != new.Port ||
+					old.User != new.User || old.Pass != new.Pass || old.ClientID != new.ClientID
+			},
+			start: HaPerMonitor,
+		},
+		{
+			name:    "netcheck",
+			enabled: func(cfg *Config) bool { return true },
+			changed: func(old, new *Config) bool { return old.PingHost != new.PingHost },
+			start: func(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					checkInternet(ctx, cfg.PingHost)
+				}()
+				return done, nil
+			},
+		},
+		{
+			name:    "sampler",
+			enabled: func(cfg *Config) bool { return true },
+			changed: func(old, new *Config) bool { return old.SampleInterval != new.SampleInterval },
+			start:   startSystemSampler,
+		},
+		{
+			name:    "metrics",
+			enabled: func(cfg *Config) bool { return cfg.Features.MetricsHistory },
+			changed: func(old, new *Config) bool { return false },
+			start:   startMetricsHistory,
+		},
+		{
+			name:    "serial",
+			enabled: func(cfg *Config) bool { return cfg.Features.Serial },
+			changed: func(old, new *Config) bool { return old.SerialDev != new.SerialDev },
+			start: func(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
+				return startSerialListener(ctx, cfg.SerialDev)
+			},
+		},
+	}
+)
+
+// 启动所有已启用的子系统，ctx 结束时子系统随之退出
+func startSubsystems(ctx context.Context, cfg *Config) {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+
+	subsystemsCtx = ctx
+	for _, s := range subsystems {
+		if !s.enabled(cfg) || s.cancel != nil {
+			continue
+		}
+		if err := s.run(ctx, cfg); err != nil {
+			if s.running() {
+				log.Printf("子系统 %s 已启动，连接失败，后台重试: %v", s.name, err)
+			} else {
+				log.Printf("子系统 %s 启动失败: %v", s.name, err)
+			}
+		}
+	}
+}
+
+// 停止所有子系统并等待后台任务退出
+func stopSubsystems() {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+
+	subsystemsDone = true
+	for _, s := range subsystems {
+		s.stop()
+	}
+}
+
+// 重新读取配置文件，只重启配置发生变化的子系统
+func reloadConfig() (*ReloadResult, error) {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+
+	if subsystemsDone || subsystemsCtx == nil {
+		return nil, errors.New("子系统未运行，无法重载配置")
+	}
+
+	newCfg, err := loadAppConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	oldCfg := getConfig()
+
+	result := &ReloadResult{
+		Reloaded:        []string{},
+		Failed:          map[string]string{},
+		Retrying:        map[string]string{},
+		RestartRequired: []string{},
+	}
+	if oldCfg.Listen != newCfg.Listen {
+		result.RestartRequired = append(result.RestartRequired, "listen")
+	}
+	if oldCfg.StaticDir != newCfg.StaticDir {
+		result.RestartRequired = append(result.RestartRequired, "static_dir")
+	}
+	if oldCfg.Root != newCfg.Root {
+		result.RestartRequired = append(result.RestartRequired, "root")
+	}
+	if oldCfg.DataDir != newCfg.DataDir {
+		result.RestartRequired = append(result.RestartRequired, "data_dir")
+	}
+	if oldCfg.Features.Led != newCfg.Features.Led {
+		result.RestartRequired = append(result.RestartRequired, "features.led")
+	}
+	if oldCfg.TLS != newCfg.TLS {
+		result.RestartRequired = append(result.RestartRequired, "tls")
+	}
+	// 需要重启进程的字段保持原值，其余字段立即生效
+	newCfg.Listen = oldCfg.Listen
+	newCfg.StaticDir = oldCfg.StaticDir
+	newCfg.Root = oldCfg.Root
+	newCfg.DataDir = oldCfg.DataDir
+	newCfg.Features.Led = oldCfg.Features.Led
+	newCfg.TLS = oldCfg.TLS
+	setConfig(newCfg)
+
+	for _, s := range subsystems {
+		wasEnabled, isEnabled := s.enabled(oldCfg), s.enabled(newCfg)
+		if wasEnabled == isEnabled && (!isEnabled || !s.changed(oldCfg, newCfg)) {
+			continue
+		}
+
+		s.stop()
+		if isEnabled {
+			if err := s.run(subsystemsCtx, newCfg); err != nil {
+				if !s.running() {
+					log.Printf("子系统 %s 重载失败: %v", s.name, err)
+					result.Failed[s.name] = err.Error()
+					continue
+				}
+				log.Printf("子系统 %s 已重启，连接失败，后台重试: %v", s.name, err)
+				result.Retrying[s.name] = err.Error()
+			}
+		}
+		log.Printf("子系统 %s 已重载", s.name)
+		result.Reloaded = append(result.Reloaded, s.name)
+	}
+	return result, nil
+}
+
+// 收到 SIGHUP 时重载配置
+func handleReloadSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		log.Println("收到 SIGHUP，重新加载配置")
+		result, err := reloadConfig()
+		if err != nil {
+			log.Printf("重新加载配置失败: %v", err)
+			continue
+		}
+		log.Printf("配置重载完成: reloaded=%v failed=%v retrying=%v restart_required=%v",
+			result.Reloaded, result.Failed, result.Retrying, result.RestartRequired)
+	}
+}
+
+func reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	result, err := reloadConfig()
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeInvalidConfig, err.Error())
+		return
+	}
+	respondData(w, r, http.StatusOK, result)
+}
+
+func initReload() {
+	go handleReloadSignal()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 后台重试连接的子系统仍在运行，不能报告为失败
+func TestReloadRetryingSubsystem(t *testing.T) {
+	oldSubsystems, oldCtx, oldPath, oldCfg := subsystems, subsystemsCtx, configFilePath, getConfig()
+	t.Cleanup(func() {
+		stopSubsystems()
+		subsystemsLock.Lock()
+		subsystems, subsystemsCtx, subsystemsDone = oldSubsystems, oldCtx, false
+		subsystemsLock.Unlock()
+		configFilePath = oldPath
+		setConfig(oldCfg)
+	})
+
+	always := func(*Config) bool { return true }
+	changed := func(old, new *Config) bool { return true }
+	retrying := &subsystem{name: "retrying", enabled: always, changed: changed,
+		start: func(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				<-ctx.Done()
+			}()
+			return done, errors.New("connection refused")
+		},
+	}
+	broken := &subsystem{name: "broken", enabled: always, changed: changed,
+		start: func(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
+			return nil, errors.New("no such device")
+		},
+	}
+	subsystemsLock.Lock()
+	subsystems, subsystemsCtx, subsystemsDone = []*subsystem{retrying, broken}, context.Background(), false
+	subsystemsLock.Unlock()
+	configFilePath = filepath.Join(t.TempDir(), "config.json") // 不存在时使用默认配置
+
+	result, err := reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Reloaded, []string{"retrying"}) ||
+		!reflect.DeepEqual(result.Retrying, map[string]string{"retrying": "connection refused"}) ||
+		!reflect.DeepEqual(result.Failed, map[string]string{"broken": "no such device"}) {
+		t.Errorf("重载结果 = %+v", result)
+	}
+	if !retrying.running() || broken.running() {
+		t.Errorf("running: retrying=%v broken=%v", retrying.running(), broken.running())
+	}
+}
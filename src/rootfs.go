@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 宿主机文件系统。/sys、/proc、/etc 和数据目录等绝对路径都相对于配置的根目录解析，
+// 根目录为 / 时即真实路径；指定为其他目录时守护进程可以在沙箱目录中针对测试数据运行
+type rootFS struct {
+	root string
+}
+
+// 当前配置的根目录
+func sysFS() rootFS {
+	return rootFS{root: getConfig().Root}
+}
+
+// 宿主机上的绝对路径转换为实际访问的路径
+func (r rootFS) Path(name string) string {
+	if r.root == "" || r.root == "/" {
+		return filepath.Clean(name)
+	}
+	// 先按根目录清理路径，.. 不能跳出根目录
+	return filepath.Join(r.root, filepath.Clean("/"+name))
+}
+
+// 只读的 fs.FS 视图，路径为去掉开头 / 的宿主机路径，例如 proc/net/dev
+func (r rootFS) FS() fs.FS {
+	return os.DirFS(r.Path("/"))
+}
+
+func (r rootFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(r.Path(name))
+}
+
+func (r rootFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(r.Path(name), data, perm)
+}
+
+func (r rootFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(r.Path(name), perm)
+}
+
+func (r rootFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(r.Path(name))
+}
+
+func (r rootFS) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(r.Path(name), flag, perm)
+}
+
+// 返回的路径同样是宿主机上的路径，不包含根目录
+func (r rootFS) Glob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(r.Path(pattern))
+	if err != nil || r.root == "" || r.root == "/" {
+		return matches, err
+	}
+	prefix := filepath.Clean(r.root)
+	for i, m := range matches {
+		matches[i] = "/" + strings.TrimPrefix(strings.TrimPrefix(m, prefix), "/")
+	}
+	return matches, nil
+}
+
+// gopsutil 通过环境变量确定 /proc、/sys 和 /etc 的位置，根目录不是 / 时一并指向根目录下
+func exportHostPaths() {
+	fsys := sysFS()
+	if fsys.root == "" || fsys.root == "/" {
+		return
+	}
+	for env, dir := range map[string]string{"HOST_PROC": "/proc", "HOST_SYS": "/sys", "HOST_ETC": "/etc"} {
+		os.Setenv(env, fsys.Path(dir))
+	}
+}
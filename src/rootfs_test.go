@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// 在临时目录中创建宿主机文件，files 的键为宿主机上的绝对路径，值为空时创建目录
+func useSandboxRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if content == "" {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := getConfig()
+	cfg := *old
+	cfg.Root = root
+	setConfig(&cfg)
+	t.Cleanup(func() { setConfig(old) })
+	return root
+}
+
+const procNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+ wlan0: 123456     100    0    0    0     0          0         0    65432      80    0    0    0     0       0          0
+`
+
+func TestRootFSPath(t *testing.T) {
+	if got := (rootFS{root: "/"}).Path("/proc/net/dev"); got != "/proc/net/dev" {
+		t.Errorf("根目录为 / 时 Path = %q", got)
+	}
+	if got := (rootFS{root: "/tmp/sandbox"}).Path("/proc/net/dev"); got != "/tmp/sandbox/proc/net/dev" {
+		t.Errorf("Path = %q", got)
+	}
+	// 路径不能通过 .. 跳出根目录
+	if got := (rootFS{root: "/tmp/sandbox"}).Path("/../etc/passwd"); got != "/tmp/sandbox/etc/passwd" {
+		t.Errorf("Path = %q", got)
+	}
+}
+
+func TestRootFSImplementsFS(t *testing.T) {
+	useSandboxRoot(t, map[string]string{
+		"/proc/net/dev":   procNetDev,
+		"/etc/os-release": "ID=haos\n",
+	})
+	if err := fstest.TestFS(sysFS().FS(), "proc/net/dev", "etc/os-release"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSandboxReads(t *testing.T) {
+	useSandboxRoot(t, map[string]string{
+		"/proc/net/dev":              procNetDev,
+		"/etc/os-release":            "PRETTY_NAME=\"Home Assistant OS\"\nVERSION_ID=\"15.2\"\n",
+		"/proc/cpuinfo":              "processor\t: 0\nmodel name\t: ARMv8 Processor rev 4 (v8l)\n",
+		"/sys/class/leds/sys_led":    "",
+		"/sys/class/leds/user_led":   "",
+		"/sys/block/mmcblk0/size":    "30535680\n",
+		"/sys/class/net/wlan0/flags": "0x1003\n",
+	})
+
+	stats, err := getNetworkStats("wlan0")
+	if err != nil {
+		t.Fatalf("getNetworkStats: %v", err)
+	}
+	if stats != (NetworkStats{RXBytes: 123456, TXBytes: 65432}) {
+		t.Errorf("getNetworkStats = %+v", stats)
+	}
+
+	if got := getOSVersion(); got != "Home Assistant OS (15.2)" {
+		t.Errorf("getOSVersion() = %q", got)
+	}
+	if got := getCPUModel(); got != "ARMv8 Processor rev 4 (v8l)" {
+		t.Errorf("getCPUModel() = %q", got)
+	}
+	if got := getAllLed(); !reflect.DeepEqual(got, []string{"sys_led", "user_led"}) {
+		t.Errorf("getAllLed() = %v", got)
+	}
+	if data, err := sysFS().ReadFile("/sys/block/mmcblk0/size"); err != nil || string(data) != "30535680\n" {
+		t.Errorf("读取 /sys/block 失败: %q, %v", data, err)
+	}
+}
+
+func TestSandboxWrites(t *testing.T) {
+	root := useSandboxRoot(t, nil)
+
+	if want := filepath.Join(root, getConfig().DataDir, "user.json"); dataPath("user.json") != want {
+		t.Errorf("dataPath = %q，期望 %q", dataPath("user.json"), want)
+	}
+
+	if err := sysFS().MkdirAll("/opt/config/frp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysFS().WriteFile("/opt/config/frp/frpc.toml", []byte("serverAddr = \"x\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "opt/config/frp/frpc.toml")); err != nil {
+		t.Errorf("文件没有写入根目录: %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import "net/http"
+
+// 服务操作共用的查询参数
+var serviceNameParam = apiParam{Name: "name", Description: "服务名称", Required: true}
+
+// 全部 REST 接口。Path 为 /api/v1 下的路径，Legacy 为兼容的旧路径；
+// Request/Response 用于生成 OpenAPI 文档，每条路由都必须填写
+func apiRouteTable() []*apiRoute {
+	return []*apiRoute{
+		// 认证
+		{
+			Method: http.MethodPost, Path: "/auth/login", Legacy: "/login", Public: true,
+			Summary: "登录，启用两步验证时需要 otp", Handler: loginHandler,
+			Request: LoginRequest{}, Response: LoginResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/auth/refresh", Legacy: "/token/refresh", Public: true,
+			Summary: "使用刷新 Token 换取新的访问 Token", Handler: refreshTokenHandler,
+			Request: RefreshRequest{}, Response: LoginResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/auth/logout", Legacy: "/logout", Role: RoleViewer, AllowPasswordChange: true,
+			Summary: "注销当前 Token 和会话", Handler: logoutHandler,
+			Request: noBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/auth/password", Legacy: "/change-password", Role: RoleViewer, AllowPasswordChange: true,
+			Summary: "修改密码，其他会话全部下线", Handler: changePasswordHandler,
+			Request: PasswordChangeRequest{}, Response: PasswordChangeResponse{},
+		},
+		{
+			Method: http.MethodGet, Path: "/sessions", Legacy: "/sessions", Role: RoleViewer,
+			Summary: "会话列表", Handler: listSessionsHandler,
+			Query:    []apiParam{{Name: "all", Description: "为 1 时列出所有用户的会话（仅 admin）"}},
+			Response: SessionList{},
+		},
+		{
+			Method: http.MethodPost, Path: "/sessions/revoke", Legacy: "/session/revoke", Role: RoleViewer,
+			Summary: "注销会话，普通用户只能注销自己的会话", Handler: revokeSessionHandler,
+			Request: IDRequest{}, Response: MessageResponse{},
+		},
+
+		// 两步验证
+		{
+			Method: http.MethodGet, Path: "/2fa", Legacy: "/2fa/status", Role: RoleViewer,
+			Summary: "两步验证状态", Handler: totpStatusHandler,
+			Response: TOTPStatus{},
+		},
+		{
+			Method: http.MethodPost, Path: "/2fa/setup", Legacy: "/2fa/setup", Role: RoleViewer,
+			Summary: "生成新的两步验证密钥", Handler: totpSetupHandler,
+			Request: noBody{}, Response: TOTPSetupResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/2fa/enable", Legacy: "/2fa/enable", Role: RoleViewer,
+			Summary: "验证码确认后启用两步验证，返回恢复码", Handler: totpEnableHandler,
+			Request: TOTPRequest{}, Response: RecoveryCodesResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/2fa/disable", Legacy: "/2fa/disable", Role: RoleViewer,
+			Summary: "关闭两步验证，需要密码和验证码", Handler: totpDisableHandler,
+			Request: TOTPRequest{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/2fa/recovery-codes", Legacy: "/2fa/recovery-codes", Role: RoleViewer,
+			Summary: "重新生成恢复码", Handler: totpRecoveryCodesHandler,
+			Request: TOTPRequest{}, Response: RecoveryCodesResponse{},
+		},
+
+		// 用户管理
+		{
+			Method: http.MethodGet, Path: "/users", Legacy: "/users", Role: RoleAdmin,
+			Summary: "用户列表", Handler: listUsersHandler,
+			Response: UserList{},
+		},
+		{
+			Method: http.MethodPost, Path: "/users/add", Legacy: "/user/add", Role: RoleAdmin,
+			Summary: "新建用户", Handler: addUserHandler,
+			Request: UserRequest{}, Response: UserInfo{},
+		},
+		{
+			Method: http.MethodPost, Path: "/users/update", Legacy: "/user/update", Role: RoleAdmin,
+			Summary: "修改用户的角色、邮箱，重置密码或两步验证", Handler: updateUserHandler,
+			Request: UserRequest{}, Response: UserInfo{},
+		},
+		{
+			Method: http.MethodPost, Path: "/users/delete", Legacy: "/user/delete", Role: RoleAdmin,
+			Summary: "删除用户", Handler: deleteUserHandler,
+			Request: UserRequest{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/users/revoke-sessions", Legacy: "/user/revoke-sessions", Role: RoleAdmin,
+			Summary: "强制用户下线，username 为空时强制所有用户下线", Handler: revokeSessionsHandler,
+			Request: RevokeSessionsRequest{}, Response: MessageResponse{},
+		},
+
+		// API Key
+		{
+			Method: http.MethodGet, Path: "/apikeys", Legacy: "/apikeys", Role: RoleAdmin,
+			Summary: "API Key 列表和可用范围", Handler: listAPIKeysHandler,
+			Response: APIKeyList{},
+		},
+		{
+			Method: http.MethodPost, Path: "/apikeys/add", Legacy: "/apikey/add", Role: RoleAdmin,
+			Summary: "创建 API Key，完整密钥只返回一次", Handler: addAPIKeyHandler,
+			Request: APIKeyRequest{}, Response: APIKeyCreated{},
+		},
+		{
+			Method: http.MethodPost, Path: "/apikeys/revoke", Legacy: "/apikey/revoke", Role: RoleAdmin,
+			Summary: "注销 API Key", Handler: revokeAPIKeyHandler,
+			Request: IDRequest{}, Response: MessageResponse{},
+		},
+
+		// 审计日志
+		{
+			Method: http.MethodGet, Path: "/audit", Legacy: "/audit", Role: RoleAdmin,
+			Summary: "查询审计日志，按时间倒序分页", Handler: auditQueryHandler,
+			Query: []apiParam{
+				{Name: "user", Description: "用户名"},
+				{Name: "action", Description: "路由前缀"},
+				{Name: "ip", Description: "来源 IP"},
+				{Name: "result", Description: "ok 或 error"},
+				{Name: "since", Description: "起始时间，Unix 秒或 RFC 3339"},
+				{Name: "until", Description: "结束时间，Unix 秒或 RFC 3339"},
+				{Name: "limit", Description: "每页条数，默认 50，最多 500"},
+				{Name: "offset", Description: "跳过的条数"},
+			},
+			Response: AuditPage{},
+		},
+
+		// 系统状态
+		{
+			Method: http.MethodGet, Path: "/version", Legacy: "/version", Role: RoleViewer, Scope: "status",
+			Summary: "系统版本信息", Handler: versionHandler,
+			Response: VersionInfo{},
+		},
+		{
+			Method: http.MethodGet, Path: "/netstatus", Legacy: "/netstatus", Role: RoleViewer, Scope: "status",
+			Summary: "网络连通性和网速", Handler: netStauts,
+			Response: NetWorkStatus{},
+		},
+		{
+			Method: http.MethodGet, Path: "/metrics/history", Legacy: "/metrics/history", Role: RoleViewer, Scope: "status",
+			Summary: "查询系统指标历史，不指定 metric 时在错误详情中返回可用的指标", Handler: metricsHistoryHandler,
+			Query: []apiParam{
+				{Name: "metric", Description: "指标名，例如 cpu、mem、disk、net.wlan0.rx、temp.cpu-thermal", Required: true},
+				{Name: "from", Description: "起始时间，Unix 秒或 RFC 3339，默认 24 小时前"},
+				{Name: "to", Description: "结束时间，Unix 秒或 RFC 3339，默认当前时间"},
+				{Name: "step", Description: "每个点的区间长度，秒或 Go duration（如 5m），默认 60；不少于 3600 时使用小时数据"},
+			},
+			Response: MetricHistory{},
+		},
+		{
+			Method: http.MethodGet, Path: "/logs/server", Legacy: "/serverlogs", Role: RoleOperator, Scope: "status",
+			Summary: "程序日志", Handler: getServerLogs,
+			Response: LogResponse{},
+		},
+		{
+			Method: http.MethodGet, Path: "/logs/system", Legacy: "/systemlogs", Role: RoleViewer, Scope: "status",
+			Summary: "最近 100 条系统日志", Handler: getSystemLogs,
+			Response: LogResponse{},
+		},
+		{
+			Method: http.MethodGet, Path: "/logs/stream", Legacy: "/logstream", Role: RoleViewer, Scope: "status",
+			Summary: "以 Server-Sent Events 跟踪新日志", Handler: streamLogs,
+			Query: []apiParam{
+				{Name: "source", Description: "journal（默认）或 daemon（本程序日志）"},
+				{Name: "unit", Description: "只输出该 systemd 单元的日志，仅 journal"},
+				{Name: "priority", Description: "只输出不低于该级别的日志，0-7 或 emerg、alert、crit、err、warning、notice、info、debug"},
+				{Name: "since", Description: "从该时间开始补发历史日志，Unix 秒或 RFC 3339"},
+				{Name: "lines", Description: "开始跟踪前补发的最近日志行数，默认 10，指定 since 时默认不限，最多 1000"},
+			},
+			Response: eventStream{Event: LogLine{}},
+		},
+		{
+			Method: http.MethodGet, Path: "/ws", Legacy: "/ws", Role: RoleViewer, Scope: "status", TokenQuery: true,
+			Summary: "WebSocket 连接，订阅系统信息、升级进度等事件", Handler: wsHandler,
+			Query: []apiParam{
+				{Name: "token", Description: "访问 Token，浏览器无法设置 Authorization 头时使用"},
+			},
+			Response: webSocket{Message: wsMessage{}},
+		},
+		{
+			Method: http.MethodGet, Path: "/metrics", Legacy: "/metrics", Public: true,
+			Summary: "Prometheus 指标，未启用时返回 404，配置了 token 时需要 Bearer 认证", Handler: prometheusHandler,
+			Response: textResponse{ContentType: prometheusContentType},
+		},
+
+		// LED
+		{
+			Method: http.MethodGet, Path: "/led", Legacy: "/ledstatus", Role: RoleViewer, Scope: "led",
+			Summary: "LED 状态", Handler: getLedHandler,
+			Response: Ledstatus{},
+		},
+		{
+			Method: http.MethodPost, Path: "/led", Legacy: "/ledstatus", Role: RoleOperator, Scope: "led",
+			Summary: "设置 LED 状态，ON 或 OFF", Handler: setLedHandler,
+			Request: Ledstatus{}, Response: Ledstatus{},
+		},
+
+		// 服务管理
+		{
+			Method: http.MethodGet, Path: "/services", Legacy: "/services", Role: RoleViewer, Scope: "services",
+			Summary: "服务列表", Handler: getServices,
+			Response: []Service{},
+		},
+		{
+			Method: http.MethodPost, Path: "/services/install", Legacy: "/service/install", Role: RoleAdmin, Scope: "services",
+			Summary: "安装服务", Handler: installService,
+			Query:   []apiParam{serviceNameParam},
+			Request: noBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/services/enable", Legacy: "/service/enable", Role: RoleOperator, Scope: "services",
+			Summary: "设置服务开机启动", Handler: enableService,
+			Query:   []apiParam{serviceNameParam, {Name: "status", Description: "enable 启用，其他值禁用", Required: true}},
+			Request: noBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/services/ctrl", Legacy: "/service/ctrl", Role: RoleOperator, Scope: "services",
+			Summary: "启动或停止服务", Handler: ctrlService,
+			Query:   []apiParam{serviceNameParam, {Name: "ctrl", Description: "start 启动，其他值停止"}},
+			Request: noBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/services/restart", Legacy: "/service/restart", Role: RoleOperator, Scope: "services",
+			Summary: "重启服务", Handler: restartService,
+			Query:   []apiParam{serviceNameParam},
+			Request: noBody{}, Response: MessageResponse{},
+		},
+
+		// 无线网络
+		{
+			Method: http.MethodGet, Path: "/wifi/ap", Legacy: "/ap-status", Role: RoleViewer, Scope: "network",
+			Summary: "热点状态", Handler: apStatusHandler,
+			Response: APStatus{},
+		},
+		{
+			Method: http.MethodPost, Path: "/wifi/ap/toggle", Legacy: "/toggle-ap", Role: RoleOperator, Scope: "network",
+			Summary: "开启或关闭热点", Handler: toggleAPHandler,
+			Request: noBody{}, Response: ToggleAPResponse{},
+		},
+		{
+			Method: http.MethodGet, Path: "/wifi/scan", Legacy: "/scan", Role: RoleOperator, Scope: "network",
+			Summary: "扫描无线网络", Handler: handleWLANScan,
+			Response: WifiScanResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/wifi/connect", Legacy: "/connect", Role: RoleOperator, Scope: "network",
+			Summary: "连接无线网络", Handler: handleConnectWLAN,
+			Request: formBody{Fields: WifiConnectRequest{}}, Response: WifiConnectResponse{},
+		},
+
+		// frpc 配置
+		{
+			Method: http.MethodGet, Path: "/frpc/config", Legacy: "/sysconfig/get", Role: RoleOperator, Scope: "sysconfig",
+			Summary: "读取 frpc 配置文件", Handler: getConfigHandler,
+			Response: ConfigContent{},
+		},
+		{
+			Method: http.MethodPost, Path: "/frpc/config", Legacy: "/sysconfig/save", Role: RoleOperator, Scope: "sysconfig",
+			Summary: "保存 frpc 配置文件，请求体为文件内容", Handler: saveConfigHandler,
+			Request: textBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/frpc/restart", Legacy: "/sysconfig/restart", Role: RoleOperator, Scope: "sysconfig",
+			Summary: "重启 frpc", Handler: restartFrpcHandler,
+			Request: noBody{}, Response: MessageResponse{},
+		},
+
+		// 系统升级
+		{
+			Method: http.MethodPost, Path: "/upgrade", Legacy: "/upload_update", Role: RoleAdmin, Scope: "upgrade",
+			Summary: "上传升级包或提交升级包的下载地址，完成后在后台安装", Handler: uploadUpdateHandler,
+			Request:  requestBodies{multipartBody{Files: []string{"updateFile"}}, UpgradeURLRequest{}},
+			Response: UpgradeStatus{},
+		},
+		{
+			Method: http.MethodGet, Path: "/upgrade", Legacy: "/upgrade_progress", Role: RoleViewer, Scope: "upgrade",
+			Summary: "升级进度", Handler: upgradeProgressHandler,
+			Response: UpgradeProgress{},
+		},
+		{
+			Method: http.MethodPost, Path: "/upgrade/cancel", Legacy: "/cancel_upgrade", Role: RoleAdmin, Scope: "upgrade",
+			Summary: "取消正在进行的升级", Handler: cancelUpgradeHandler,
+			Request: noBody{}, Response: UpgradeStatus{},
+		},
+
+		// 系统操作
+		{
+			Method: http.MethodPost, Path: "/system/reboot", Legacy: "/reboot", Role: RoleAdmin, Scope: "system",
+			Summary: "重启系统", Handler: rebootSystem,
+			Request: noBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/system/reset", Legacy: "/reset", Role: RoleAdmin, Scope: "system",
+			Summary: "恢复出厂设置", Handler: resetSystem,
+			Request: noBody{}, Response: MessageResponse{},
+		},
+		{
+			Method: http.MethodPost, Path: "/config/reload", Legacy: "/config/reload", Role: RoleAdmin, Scope: "config",
+			Summary: "重新加载配置文件", Handler: reloadConfigHandler,
+			Request: noBody{}, Response: ReloadResult{},
+		},
+
+		// HTTPS 证书
+		{
+			Method: http.MethodGet, Path: "/tls/cert", Legacy: "/tls/cert", Role: RoleViewer, Scope: "tls",
+			Summary: "当前证书信息", Handler: tlsCertHandler,
+			Response: CertInfo{},
+		},
+		{
+			Method: http.MethodPost, Path: "/tls/upload", Legacy: "/tls/upload", Role: RoleAdmin, Scope: "tls",
+			Summary: "上传 PEM 格式的证书和私钥，立即生效", Handler: tlsUploadHandler,
+			Request: multipartBody{Files: []string{"cert", "key"}}, Response: CertInfo{},
+		},
+		{
+			Method: http.MethodPost, Path: "/tls/regenerate", Legacy: "/tls/regenerate", Role: RoleAdmin, Scope: "tls",
+			Summary: "重新生成自签名证书", Handler: tlsRegenerateHandler,
+			Request: noBody{}, Response: CertInfo{},
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+// 外部命令（systemctl、wpa_cli、rauc、led-control 等）统一通过 Runner 执行，
+// 测试时替换为脚本化的假实现，不需要真实的系统环境
+type Runner interface {
+	// 执行命令，返回标准输出
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+	// 执行命令，返回标准输出和标准错误
+	CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error)
+	// 执行命令，标准输出逐行交给 onLine 处理，ctx 结束时终止命令
+	Stream(ctx context.Context, onLine func(line string), name string, args ...string) error
+}
+
+// 使用 os/exec 执行命令
+type execRunner struct{}
+
+func (execRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+func (execRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func (execRunner) Stream(ctx context.Context, onLine func(line string), name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("创建输出管道失败: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	return cmd.Wait()
+}
+
+var (
+	runnerLock sync.RWMutex
+	runner     Runner = execRunner{}
+)
+
+func getRunner() Runner {
+	runnerLock.RLock()
+	defer runnerLock.RUnlock()
+	return runner
+}
+
+// 替换命令执行器，返回原来的执行器
+func setRunner(r Runner) Runner {
+	runnerLock.Lock()
+	defer runnerLock.Unlock()
+	old := runner
+	runner = r
+	return old
+}
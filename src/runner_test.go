@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 命令失败时返回的错误，与 exec.ExitError 的信息一致
+var errExitStatus1 = errors.New("exit status 1")
+
+// 脚本化的命令执行器：记录执行过的命令，按 on 设置的结果返回，未设置的命令返回错误
+type fakeRunner struct {
+	mu      sync.Mutex
+	calls   []string
+	scripts map[string]fakeResult
+}
+
+type fakeResult struct {
+	Output string
+	Err    error
+	Block  bool // Stream 输出完后阻塞，直到 ctx 结束
+}
+
+// 创建假执行器并替换全局执行器，测试结束后恢复
+func newFakeRunner(t *testing.T) *fakeRunner {
+	t.Helper()
+	f := &fakeRunner{scripts: map[string]fakeResult{}}
+	old := setRunner(f)
+	t.Cleanup(func() { setRunner(old) })
+	return f
+}
+
+// 设置命令的结果，cmdline 为以空格连接的命令和参数
+func (f *fakeRunner) on(cmdline, output string, err error) *fakeRunner {
+	return f.onResult(cmdline, fakeResult{Output: output, Err: err})
+}
+
+func (f *fakeRunner) onResult(cmdline string, res fakeResult) *fakeRunner {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[cmdline] = res
+	return f
+}
+
+// 已执行的命令，按执行顺序
+func (f *fakeRunner) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeRunner) run(ctx context.Context, name string, args []string) fakeResult {
+	cmdline := strings.Join(append([]string{name}, args...), " ")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, cmdline)
+	if err := ctx.Err(); err != nil {
+		return fakeResult{Err: err}
+	}
+	res, ok := f.scripts[cmdline]
+	if !ok {
+		return fakeResult{Err: fmt.Errorf("未设置的命令 %q: %w", cmdline, errExitStatus1)}
+	}
+	return res
+}
+
+func (f *fakeRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	res := f.run(ctx, name, args)
+	return []byte(res.Output), res.Err
+}
+
+func (f *fakeRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return f.Output(ctx, name, args...)
+}
+
+func (f *fakeRunner) Stream(ctx context.Context, onLine func(line string), name string, args ...string) error {
+	res := f.run(ctx, name, args)
+	if res.Output != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(res.Output, "\n"), "\n") {
+			onLine(line)
+		}
+	}
+	if res.Block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return res.Err
+}
+
+// 断言执行过的命令
+func assertCommands(t *testing.T, f *fakeRunner, want ...string) {
+	t.Helper()
+	got := f.commands()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("执行的命令:\n  %s\n期望:\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+}
+
+func TestFakeRunner(t *testing.T) {
+	f := newFakeRunner(t).on("systemctl is-active nginx", "active\n", nil)
+
+	out, err := getRunner().Output(context.Background(), "systemctl", "is-active", "nginx")
+	if err != nil || string(out) != "active\n" {
+		t.Errorf("Output = %q, %v", out, err)
+	}
+	if _, err := getRunner().Output(context.Background(), "reboot"); !errors.Is(err, errExitStatus1) {
+		t.Errorf("未设置的命令应返回错误，得到 %v", err)
+	}
+	assertCommands(t, f, "systemctl is-active nginx", "reboot")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 系统信息由一个后台协程按 sample_interval 统一采样，WebSocket 连接、MQTT 传感器、
+// Prometheus 接口和指标历史都读取最新的快照或订阅推送，连接数不影响采样开销。
+// 每次采样同时推送 WebSocket 的 system 和 network 主题
+
+// 磁盘使用率变化缓慢，且需要执行 lsblk，每分钟采样一次
+const diskSampleInterval = time.Minute
+
+// 保存最新快照并推送给所有订阅者
+type sysInfoBroadcaster struct {
+	mu     sync.Mutex
+	latest *SystemInfo // 采样未运行时为 nil
+	subs   map[chan SystemInfo]struct{}
+}
+
+var sysInfoHub = &sysInfoBroadcaster{subs: make(map[chan SystemInfo]struct{})}
+
+// 推送快照。每个订阅者只缓存一份，处理不过来的订阅者会丢弃旧快照，不会阻塞采样
+func (b *sysInfoBroadcaster) publish(info SystemInfo) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latest = &info
+	for ch := range b.subs {
+		select {
+		case ch <- info:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- info
+		}
+	}
+}
+
+// 订阅快照推送，已有快照时立即收到一份。调用返回的函数取消订阅
+func (b *sysInfoBroadcaster) subscribe() (<-chan SystemInfo, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan SystemInfo, 1)
+	if b.latest != nil {
+		ch <- *b.latest
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, ch)
+	}
+}
+
+func (b *sysInfoBroadcaster) snapshot() (SystemInfo, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.latest == nil {
+		return SystemInfo{}, false
+	}
+	return *b.latest, true
+}
+
+// 采样停止后不再提供旧快照
+func (b *sysInfoBroadcaster) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latest = nil
+}
+
+// network 主题的事件
+type NetworkEvent struct {
+	Online     bool                        `json:"online"`
+	Interfaces map[string]InterfaceTraffic `json:"interfaces"`
+}
+
+// 网卡的累计收发字节数和上一个采样间隔内的速率（字节/秒）
+type InterfaceTraffic struct {
+	RXBytes uint64  `json:"rx_bytes"`
+	TXBytes uint64  `json:"tx_bytes"`
+	RXRate  float64 `json:"rx_rate"`
+	TXRate  float64 `json:"tx_rate"`
+}
+
+// 最近一次采样的网络状态，采样未运行时为 nil
+var (
+	networkStateLock sync.Mutex
+	networkState     *NetworkEvent
+)
+
+func getNetworkState() (NetworkEvent, bool) {
+	networkStateLock.Lock()
+	defer networkStateLock.Unlock()
+	if networkState == nil {
+		return NetworkEvent{}, false
+	}
+	return *networkState, true
+}
+
+func setNetworkState(state *NetworkEvent) {
+	networkStateLock.Lock()
+	defer networkStateLock.Unlock()
+	networkState = state
+}
+
+// 根据两次读取的计数器计算速率，第一次采样或计数器归零时速率为 0
+func newNetworkEvent(prev, curr map[string]NetworkStats, elapsed time.Duration, online bool) NetworkEvent {
+	ev := NetworkEvent{Online: online, Interfaces: make(map[string]InterfaceTraffic, len(curr))}
+	for name, c := range curr {
+		t := InterfaceTraffic{RXBytes: c.RXBytes, TXBytes: c.TXBytes}
+		if p, ok := prev[name]; ok && elapsed > 0 && c.RXBytes >= p.RXBytes && c.TXBytes >= p.TXBytes {
+			t.RXRate = float64(c.RXBytes-p.RXBytes) / elapsed.Seconds()
+			t.TXRate = float64(c.TXBytes-p.TXBytes) / elapsed.Seconds()
+		}
+		ev.Interfaces[name] = t
+	}
+	return ev
+}
+
+// 最新的系统信息。采样未运行时（例如启动过程中）直接读取一次
+func currentSystemInfo() SystemInfo {
+	if info, ok := sysInfoHub.snapshot(); ok {
+		return info
+	}
+	return getSystemInfo()
+}
+
+// 采样子系统，启动后立即采样一次
+func startSystemSampler(ctx context.Context, cfg *Config) (<-chan struct{}, error) {
+	return runSystemSampler(ctx, time.Duration(cfg.SampleInterval)*time.Second), nil
+}
+
+func runSystemSampler(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sysInfoHub.reset()
+		defer setNetworkState(nil)
+
+		var disk map[string]float64
+		var diskTime time.Time
+		var prevNet map[string]NetworkStats
+		var prevTime time.Time
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := time.Now(); ; {
+			if now.Sub(diskTime) >= diskSampleInterval {
+				disk, diskTime = getDiskUsage(), now
+			}
+			info := readSystemInfo(now, disk)
+			sysInfoHub.publish(info)
+			publishEvent(topicSystem, topicSystem, info)
+
+			if stats, err := getAllNetworkStats(); err == nil {
+				state := newNetworkEvent(prevNet, stats, now.Sub(prevTime), isOnlineStatus.Load())
+				prevNet, prevTime = stats, now
+				setNetworkState(&state)
+				publishEvent(topicNetwork, topicNetwork, state)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case now = <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSysInfoBroadcaster(t *testing.T) {
+	b := &sysInfoBroadcaster{subs: make(map[chan SystemInfo]struct{})}
+	if _, ok := b.snapshot(); ok {
+		t.Fatal("未采样时不应有快照")
+	}
+
+	slow, cancelSlow := b.subscribe()
+	defer cancelSlow()
+	b.publish(SystemInfo{Time: 1})
+
+	// 新订阅者立即收到最新快照
+	fast, cancelFast := b.subscribe()
+	if got := (<-fast).Time; got != 1 {
+		t.Errorf("订阅时收到 %d，期望 1", got)
+	}
+
+	// 没有及时读取的订阅者只保留最新的一份，不阻塞推送
+	b.publish(SystemInfo{Time: 2})
+	b.publish(SystemInfo{Time: 3})
+	if got := (<-slow).Time; got != 3 {
+		t.Errorf("慢订阅者收到 %d，期望 3", got)
+	}
+	if got := (<-fast).Time; got != 3 {
+		t.Errorf("订阅者收到 %d，期望 3", got)
+	}
+
+	cancelFast()
+	b.publish(SystemInfo{Time: 4})
+	select {
+	case info := <-fast:
+		t.Errorf("取消订阅后仍收到 %d", info.Time)
+	default:
+	}
+
+	b.reset()
+	if _, ok := b.snapshot(); ok {
+		t.Error("reset 后不应有快照")
+	}
+}
+
+func TestSystemSampler(t *testing.T) {
+	useSandboxRoot(t, map[string]string{"/proc/uptime": "100.5 0\n"})
+	newFakeRunner(t)
+
+	updates, unsubscribe := sysInfoHub.subscribe()
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runSystemSampler(ctx, time.Hour)
+
+	// 启动后立即采样一次，不等待第一个间隔
+	select {
+	case info := <-updates:
+		if info.Uptime != 100 || info.Time == 0 {
+			t.Errorf("采样结果 %+v", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("启动后没有立即采样")
+	}
+	if info := currentSystemInfo(); info.Uptime != 100 {
+		t.Errorf("currentSystemInfo() 应返回采样结果: %+v", info)
+	}
+
+	cancel()
+	<-done
+	if _, ok := sysInfoHub.snapshot(); ok {
+		t.Error("采样停止后不应保留旧快照")
+	}
+}
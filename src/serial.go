@@ -5,10 +5,10 @@ import (
 	"bytes"
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 )
@@ -27,13 +27,12 @@ func registerCommand(name string, handler CommandHandler) {
 // 监听串口并分发命令
 var writer *bufio.Writer
 
-func SerialListenLoop(dev string) {
-	f, err := os.OpenFile(dev, os.O_RDWR, 0600)
-	if err != nil {
-		log.Printf("打开串口失败: %v", err)
-		return
-	}
+func SerialListenLoop(ctx context.Context, f *os.File) {
 	defer f.Close()
+	// ctx 结束时关闭串口，让阻塞的读取返回
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+
 	reader := bufio.NewReader(f)
 	writer = bufio.NewWriter(f)
 
@@ -46,7 +45,9 @@ func SerialListenLoop(dev string) {
 			if err == io.EOF {
 				continue
 			}
-			log.Printf("串口读取错误: %v", err)
+			if ctx.Err() == nil {
+				log.Printf("串口读取错误: %v", err)
+			}
 			break
 		}
 
@@ -99,16 +100,13 @@ func messageOutput(msg string) {
 }
 
 func getipaddr() string {
-	cmd := exec.Command("hostname", "-I")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := getRunner().Output(context.Background(), "hostname", "-I")
 	if err != nil {
 		log.Printf("获取IP地址失败: %v", err)
 
 		return ""
 	}
-	ip := strings.TrimSpace(out.String())
+	ip := strings.TrimSpace(string(out))
 	if ip == "" {
 		log.Println("未获取到IP地址")
 		return ""
@@ -149,30 +147,24 @@ func wifiCommand(args []string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "nmcli", "device", "wifi", "connect", *ssid, "password", *password)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := getRunner().Output(ctx, "nmcli", "device", "wifi", "connect", *ssid, "password", *password)
 	if err != nil {
-		log.Printf("nmcli连接失败: %v, 输出: %s", err, out.String())
-		messageOutput("nmcli连接失败: " + err.Error() + ", 输出: " + out.String())
+		log.Printf("nmcli连接失败: %v, 输出: %s", err, out)
+		messageOutput("nmcli连接失败: " + err.Error() + ", 输出: " + string(out))
 		return
 	}
-	log.Printf("nmcli连接成功: %s", out.String())
+	log.Printf("nmcli连接成功: %s", out)
 
 	// ifconfig检查IP
 	for i := 0; i < 10; i++ {
-		cmd = exec.Command("ifconfig")
-		out.Reset()
-		cmd.Stdout = &out
-		err = cmd.Run()
+		out, err = getRunner().Output(context.Background(), "ifconfig")
 		if err != nil {
 			log.Printf("ifconfig失败: %v", err)
 			return
 		}
-		if strings.Contains(out.String(), "inet ") {
+		if strings.Contains(string(out), "inet ") {
 			// 获取并打印所有IP地址
-			lines := strings.Split(out.String(), "\n")
+			lines := strings.Split(string(out), "\n")
 			for _, line := range lines {
 				line = strings.TrimSpace(line)
 				if strings.HasPrefix(line, "inet ") {
@@ -188,34 +180,42 @@ func wifiCommand(args []string) {
 		}
 	}
 	// ping测试
-	cmd = exec.Command("ping", "-c", "2", "www.baidu.com")
-	out.Reset()
-	cmd.Stdout = &out
-	err = cmd.Run()
+	out, err = getRunner().Output(context.Background(), "ping", "-c", "2", "www.baidu.com")
 	if err != nil {
-		log.Printf("ping失败: %v, 输出: %s", err, out.String())
+		log.Printf("ping失败: %v, 输出: %s", err, out)
 		return
 	}
-	log.Printf("网络连通: %s", out.String())
+	log.Printf("网络连通: %s", out)
 }
 
 // 初始化注册所有命令
 func InitSerialCommands() {
-	cmd := exec.Command("modprobe", "g_serial")
-	if err := cmd.Run(); err != nil {
+	registerCommand("wifi", wifiCommand)
+	registerCommand("ipaddr", ipcmd)
+	registerCommand("help", helpCommand)
+}
+
+// 加载 g_serial 模块并启动串口监听，ctx 结束后关闭串口并关闭返回的 channel
+func startSerialListener(ctx context.Context, dev string) (<-chan struct{}, error) {
+	if _, err := getRunner().Output(ctx, "modprobe", "g_serial"); err != nil {
 		log.Printf("加载g_serial模块失败: %v", err)
-		return
+		return nil, fmt.Errorf("加载g_serial模块失败: %w", err)
 	}
 	log.Println("g_serial模块加载成功")
 
-	// 注册核心命令
-	registerCommand("wifi", wifiCommand)
-	registerCommand("ipaddr", ipcmd)
-	registerCommand("help", helpCommand)
+	f, err := sysFS().OpenFile(dev, os.O_RDWR, 0600)
+	if err != nil {
+		log.Printf("打开串口失败: %v", err)
+		return nil, fmt.Errorf("打开串口 %s 失败: %w", dev, err)
+	}
 
-	// 启动串口监听
-	go SerialListenLoop("/dev/ttyGS0")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		SerialListenLoop(ctx, f)
+	}()
 	log.Println("串口监听已启动")
+	return done, nil
 }
 
 // 帮助命令
@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"log"
 	"net/http"
-	"os/exec"
 	"strings"
 )
 
@@ -28,52 +26,40 @@ var availableServices = []string{
 	"frps",
 }
 
-func initServiceMgr() {
-	// 定义 /services 接口
-	handleAuthRoute("/services", getServices)
-
-	// 定义 /service/install 接口
-	handleAuthRoute("/service/install", installService)
-
-	// 定义 /service/remove 接口
-	handleAuthRoute("/service/enable", enableService)
-
-	// 定义 /service/stop 接口
-	handleAuthRoute("/service/ctrl", ctrlService)
-
-	// 定义 /service/restart 接口
-	handleAuthRoute("/service/restart", restartService)
-
-}
-
 // 获取服务列表
 func getServices(w http.ResponseWriter, r *http.Request) {
+	// 返回 JSON 响应
+	respondData(w, r, http.StatusOK, listServices(r.Context()))
+}
+
+func listServices(ctx context.Context) []Service {
 	var services []Service
 
 	// 检查每个服务是否已安装
 	for _, name := range availableServices {
+		services = append(services, getService(ctx, name))
+	}
+	return services
+}
 
-		enable := isServiceEnable(name)
-		active := isServiceActive(name)
-		services = append(services, Service{
-			Name:      name,
-			IsEnableD: enable,
-			IsActive:  active,
-		})
+func getService(ctx context.Context, name string) Service {
+	return Service{
+		Name:      name,
+		IsEnableD: isServiceEnable(ctx, name),
+		IsActive:  isServiceActive(ctx, name),
 	}
+}
 
-	// 返回 JSON 响应
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(services); err != nil {
-		log.Printf("JSON 编码失败: %v\n", err)
-		http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+// 服务操作后推送该服务的状态，没有订阅时不查询
+func publishServiceState(name string) {
+	if hasSubscribers(topicServices) {
+		publishEvent(topicServices, topicServices+"/"+name, getService(context.Background(), name))
 	}
 }
 
 // 检查服务是否已安装
-func isServiceEnable(name string) bool {
-	cmd := exec.Command("systemctl", "is-enabled", name)
-	output, err := cmd.Output()
+func isServiceEnable(ctx context.Context, name string) bool {
+	output, err := getRunner().Output(ctx, "systemctl", "is-enabled", name)
 	if err != nil {
 		return false
 	}
@@ -81,9 +67,8 @@ func isServiceEnable(name string) bool {
 	return result == "enabled"
 }
 
-func isServiceActive(name string) bool {
-	cmd := exec.Command("systemctl", "is-active", name)
-	output, err := cmd.Output()
+func isServiceActive(ctx context.Context, name string) bool {
+	output, err := getRunner().Output(ctx, "systemctl", "is-active", name)
 	if err != nil {
 		return false
 	}
@@ -95,22 +80,20 @@ func isServiceActive(name string) bool {
 func installService(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("name")
 	if service == "" {
-		http.Error(w, "服务名称不能为空", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "服务名称不能为空")
 		return
 	}
 
-	cmd := exec.Command("apt", "install", "-y", service)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	// 客户端断开时不中断安装
+	_, err := getRunner().Output(context.Background(), "apt", "install", "-y", service)
 	if err != nil {
 		log.Printf("安装服务 %s 失败: %v\n", service, err)
-		http.Error(w, "安装服务失败", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "安装服务失败")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("服务安装成功"))
+	publishServiceState(service)
+	respondText(w, r, "服务安装成功")
 }
 
 // 设置服务
@@ -118,74 +101,65 @@ func enableService(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("name")
 	status := r.URL.Query().Get("status")
 	if service == "" {
-		http.Error(w, "服务名称不能为空", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "服务名称不能为空")
 		return
 	}
 	if status == "" {
-		http.Error(w, "服务状态错误", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "服务状态错误")
 		return
 	}
 
-	if status != "enbale" {
+	if status != "enable" {
 		status = "disable"
 	}
-	cmd := exec.Command("systemctl", status, service)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	_, err := getRunner().Output(context.Background(), "systemctl", status, service)
 	if err != nil {
 		log.Printf("服务 %s %s 失败: %v\n", status, service, err)
-		http.Error(w, "操作服务失败", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "操作服务失败")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("服务操作成功"))
+	publishServiceState(service)
+	respondText(w, r, "服务操作成功")
 }
 
 // 停止服务
 func ctrlService(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("name")
 	if service == "" {
-		http.Error(w, "服务名称不能为空", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "服务名称不能为空")
 		return
 	}
 	ctrl := r.URL.Query().Get("ctrl")
 	if ctrl != "start" {
 		ctrl = "stop"
 	}
-	cmd := exec.Command("sudo", "systemctl", ctrl, service)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	_, err := getRunner().Output(context.Background(), "sudo", "systemctl", ctrl, service)
 	if err != nil {
 		log.Printf("%s服务 %s 失败: %v\n", ctrl, service, err)
-		http.Error(w, "操作服务失败", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "操作服务失败")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("服务操作成功"))
+	publishServiceState(service)
+	respondText(w, r, "服务操作成功")
 }
 
 // 重启服务
 func restartService(w http.ResponseWriter, r *http.Request) {
 	service := r.URL.Query().Get("name")
 	if service == "" {
-		http.Error(w, "服务名称不能为空", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "服务名称不能为空")
 		return
 	}
 
-	cmd := exec.Command("sudo", "systemctl", "restart", service)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	_, err := getRunner().Output(context.Background(), "sudo", "systemctl", "restart", service)
 	if err != nil {
 		log.Printf("重启服务 %s 失败: %v\n", service, err)
-		http.Error(w, "重启服务失败", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, "重启服务失败")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("服务重启成功"))
+	publishServiceState(service)
+	respondText(w, r, "服务重启成功")
 }
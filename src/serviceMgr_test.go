@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 直接调用处理函数，api 为 true 时按 /api/v1 请求处理
+func callHandler(h http.HandlerFunc, method, target string, api bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if api {
+		r = r.WithContext(context.WithValue(r.Context(), "api", true))
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("解析响应失败: %v, 响应: %s", err, w.Body.String())
+	}
+}
+
+func TestGetServices(t *testing.T) {
+	newFakeRunner(t).
+		on("systemctl is-enabled nginx", "enabled\n", nil).
+		on("systemctl is-active nginx", "active\n", nil).
+		on("systemctl is-enabled frpc", "enabled\n", nil).
+		on("systemctl is-active frpc", "inactive\n", errExitStatus1)
+
+	w := callHandler(getServices, http.MethodGet, "/services", false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d", w.Code)
+	}
+	var services []Service
+	decodeBody(t, w, &services)
+	if len(services) != len(availableServices) {
+		t.Fatalf("返回 %d 个服务，期望 %d 个", len(services), len(availableServices))
+	}
+
+	got := map[string]Service{}
+	for _, s := range services {
+		got[s.Name] = s
+	}
+	if s := got["nginx"]; !s.IsEnableD || !s.IsActive {
+		t.Errorf("nginx = %+v，期望已启用且运行中", s)
+	}
+	if s := got["frpc"]; !s.IsEnableD || s.IsActive {
+		t.Errorf("frpc = %+v，期望已启用但未运行", s)
+	}
+	if s := got["hostapd"]; s.IsEnableD || s.IsActive {
+		t.Errorf("hostapd = %+v，命令失败时应为未启用", s)
+	}
+}
+
+func TestServiceHandlers(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		script  map[string]error
+		status  int
+		command string
+	}{
+		{"安装", installService, "/service/install?name=nginx",
+			map[string]error{"apt install -y nginx": nil}, http.StatusOK, "apt install -y nginx"},
+		{"安装失败", installService, "/service/install?name=nginx",
+			map[string]error{"apt install -y nginx": errExitStatus1}, http.StatusInternalServerError, "apt install -y nginx"},
+		{"启用", enableService, "/service/enable?name=frpc&status=enable",
+			map[string]error{"systemctl enable frpc": nil}, http.StatusOK, "systemctl enable frpc"},
+		{"禁用", enableService, "/service/enable?name=frpc&status=disable",
+			map[string]error{"systemctl disable frpc": nil}, http.StatusOK, "systemctl disable frpc"},
+		{"启动", ctrlService, "/service/ctrl?name=frpc&ctrl=start",
+			map[string]error{"sudo systemctl start frpc": nil}, http.StatusOK, "sudo systemctl start frpc"},
+		{"停止", ctrlService, "/service/ctrl?name=frpc&ctrl=stop",
+			map[string]error{"sudo systemctl stop frpc": nil}, http.StatusOK, "sudo systemctl stop frpc"},
+		{"重启", restartService, "/service/restart?name=frpc",
+			map[string]error{"sudo systemctl restart frpc": nil}, http.StatusOK, "sudo systemctl restart frpc"},
+		{"重启失败", restartService, "/service/restart?name=frpc",
+			map[string]error{"sudo systemctl restart frpc": errExitStatus1}, http.StatusInternalServerError, "sudo systemctl restart frpc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRunner(t)
+			for cmd, err := range tt.script {
+				f.on(cmd, "", err)
+			}
+
+			w := callHandler(tt.handler, http.MethodPost, tt.target, true)
+			if w.Code != tt.status {
+				t.Errorf("状态码 %d，期望 %d，响应: %s", w.Code, tt.status, w.Body.String())
+			}
+			var resp apiResponse
+			decodeBody(t, w, &resp)
+			if resp.OK != (tt.status == http.StatusOK) {
+				t.Errorf("ok = %v，响应: %s", resp.OK, w.Body.String())
+			}
+			if !resp.OK && (resp.Error == nil || resp.Error.Code != errCodeCommandFailed) {
+				t.Errorf("错误码应为 %s，响应: %s", errCodeCommandFailed, w.Body.String())
+			}
+			assertCommands(t, f, tt.command)
+		})
+	}
+}
+
+func TestServiceHandlersRequireName(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"install": installService,
+		"enable":  enableService,
+		"ctrl":    ctrlService,
+		"restart": restartService,
+	}
+	for name, h := range handlers {
+		f := newFakeRunner(t)
+		w := callHandler(h, http.MethodPost, "/service/"+name, false)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: 状态码 %d，期望 400", name, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("%s: 旧路径的错误应为纯文本，Content-Type = %q", name, ct)
+		}
+		assertCommands(t, f)
+	}
+}
+
+func TestServiceHandlerLegacyText(t *testing.T) {
+	newFakeRunner(t).on("sudo systemctl restart frpc", "", nil)
+
+	w := callHandler(restartService, http.MethodPost, "/service/restart?name=frpc", false)
+	if w.Code != http.StatusOK || w.Body.String() != "服务重启成功" {
+		t.Errorf("旧路径应返回纯文本，得到 %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 刷新 Token 有效期，每次刷新都会轮换并重新计时
+var refreshTokenExp = time.Hour * 24 * 7
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// 登录会话，每次登录创建一个，刷新 Token 只保存哈希
+type Session struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	RefreshHash string `json:"refresh_hash"`
+	// 上一次轮换前的刷新 Token，再次出现说明被盗用
+	PrevRefreshHash string `json:"prev_refresh_hash,omitempty"`
+	IssuedAt        int64  `json:"issued_at"`
+	ExpiresAt       int64  `json:"expires_at"`
+	LastSeen        int64  `json:"last_seen"`
+	LastIP          string `json:"last_ip"`
+	UserAgent       string `json:"user_agent"`
+}
+
+// 返回给客户端的会话信息
+type SessionInfo struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	IssuedAt  int64  `json:"issued_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	LastSeen  int64  `json:"last_seen"`
+	LastIP    string `json:"last_ip"`
+	UserAgent string `json:"user_agent"`
+	Current   bool   `json:"current"`
+}
+
+var (
+	sessionMutex sync.Mutex
+	sessions     = map[string]*Session{}
+)
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// 客户端 IP，不信任代理头
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func loadSessions() {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	rows, err := stateDB.Query(`SELECT id, username, refresh_hash, prev_refresh_hash, issued_at, expires_at, last_seen, last_ip, user_agent
+		FROM sessions`)
+	if err != nil {
+		log.Printf("读取会话失败: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	sessions = map[string]*Session{}
+	for rows.Next() {
+		s := &Session{}
+		if err := rows.Scan(&s.ID, &s.Username, &s.RefreshHash, &s.PrevRefreshHash, &s.IssuedAt, &s.ExpiresAt, &s.LastSeen, &s.LastIP, &s.UserAgent); err != nil {
+			log.Printf("读取会话失败: %v", err)
+			return
+		}
+		sessions[s.ID] = s
+	}
+}
+
+// 写入 save 中的会话、删除 remove 中的会话，同时清除过期会话，调用方需持有 sessionMutex。
+// 内存中的会话由调用方修改，这里只同步到数据库
+func writeSessions(save []*Session, remove []string) error {
+	now := time.Now().Unix()
+	for id, s := range sessions {
+		if s.ExpiresAt < now {
+			delete(sessions, id)
+		}
+	}
+
+	return storeTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM sessions WHERE expires_at < ?", now); err != nil {
+			return err
+		}
+		for _, id := range remove {
+			if _, err := tx.Exec("DELETE FROM sessions WHERE id = ?", id); err != nil {
+				return err
+			}
+		}
+		for _, s := range save {
+			_, err := tx.Exec(`INSERT INTO sessions (id, username, refresh_hash, prev_refresh_hash, issued_at, expires_at, last_seen, last_ip, user_agent)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(id) DO UPDATE SET refresh_hash = excluded.refresh_hash, prev_refresh_hash = excluded.prev_refresh_hash,
+				expires_at = excluded.expires_at, last_seen = excluded.last_seen, last_ip = excluded.last_ip, user_agent = excluded.user_agent`,
+				s.ID, s.Username, s.RefreshHash, s.PrevRefreshHash, s.IssuedAt, s.ExpiresAt, s.LastSeen, s.LastIP, s.UserAgent)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 生成新的刷新 Token，格式为 <会话ID>.<随机串>
+func (s *Session) rotate(r *http.Request) string {
+	secret := newTokenID()
+	now := time.Now()
+	s.PrevRefreshHash = s.RefreshHash
+	s.RefreshHash = hashRefreshSecret(secret)
+	s.ExpiresAt = now.Add(refreshTokenExp).Unix()
+	s.LastSeen = now.Unix()
+	s.LastIP = clientIP(r)
+	s.UserAgent = r.UserAgent()
+	return s.ID + "." + secret
+}
+
+// 登录时创建会话，返回刷新 Token
+func createSession(username string, r *http.Request) (*Session, string, error) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	s := &Session{
+		ID:       newTokenID(),
+		Username: username,
+		IssuedAt: time.Now().Unix(),
+	}
+	refresh := s.rotate(r)
+	sessions[s.ID] = s
+	if err := writeSessions([]*Session{s}, nil); err != nil {
+		delete(sessions, s.ID)
+		return nil, "", err
+	}
+	copied := *s
+	return &copied, refresh, nil
+}
+
+// 校验并轮换刷新 Token，旧的刷新 Token 立即失效。
+// 上一个已轮换的刷新 Token 再次使用视为被盗用，整个会话作废；其他不匹配的随机串只拒绝，
+// 否则知道会话 ID 的人就能注销别人的会话
+func refreshSession(refreshToken string, r *http.Request) (*Session, string, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil, "", errInvalidRefreshToken
+	}
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	s, exists := sessions[id]
+	if !exists || s.ExpiresAt < time.Now().Unix() {
+		return nil, "", errInvalidRefreshToken
+	}
+	hash := hashRefreshSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(s.RefreshHash), []byte(hash)) != 1 {
+		if s.PrevRefreshHash != "" && subtle.ConstantTimeCompare([]byte(s.PrevRefreshHash), []byte(hash)) == 1 {
+			// 先落盘再从内存中删除，失败时会话保留，再次使用该 Token 时重试注销
+			if err := writeSessions(nil, []string{id}); err != nil {
+				log.Printf("会话 %s (%s) 的刷新Token被重复使用，注销会话失败: %v", id, s.Username, err)
+				return nil, "", err
+			}
+			delete(sessions, id)
+			log.Printf("会话 %s (%s) 的刷新Token被重复使用，已注销该会话", id, s.Username)
+		}
+		return nil, "", errInvalidRefreshToken
+	}
+
+	refresh := s.rotate(r)
+	if err := writeSessions([]*Session{s}, nil); err != nil {
+		return nil, "", err
+	}
+	copied := *s
+	return &copied, refresh, nil
+}
+
+func sessionExists(id string) bool {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	s, ok := sessions[id]
+	return ok && s.ExpiresAt >= time.Now().Unix()
+}
+
+// 记录会话最近一次访问，只更新内存，刷新 Token 时一起落盘
+func touchSession(id string, r *http.Request) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	if s, ok := sessions[id]; ok {
+		s.LastSeen = time.Now().Unix()
+		s.LastIP = clientIP(r)
+		s.UserAgent = r.UserAgent()
+	}
+}
+
+func deleteSession(id string) error {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	if _, ok := sessions[id]; !ok {
+		return nil
+	}
+	delete(sessions, id)
+	return writeSessions(nil, []string{id})
+}
+
+// 删除用户的所有会话，keepID 指定的会话除外；username 为空时删除所有用户的会话
+func deleteUserSessions(username, keepID string) error {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	var remove []string
+	for id, s := range sessions {
+		if id != keepID && (username == "" || s.Username == username) {
+			delete(sessions, id)
+			remove = append(remove, id)
+		}
+	}
+	return writeSessions(nil, remove)
+}
+
+// 列出会话，username 为空时列出所有用户的会话
+func listSessions(username, currentID string) []SessionInfo {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	now := time.Now().Unix()
+	infos := []SessionInfo{}
+	for _, s := range sessions {
+		if s.ExpiresAt < now || (username != "" && s.Username != username) {
+			continue
+		}
+		infos = append(infos, SessionInfo{
+			ID:        s.ID,
+			Username:  s.Username,
+			IssuedAt:  s.IssuedAt,
+			ExpiresAt: s.ExpiresAt,
+			LastSeen:  s.LastSeen,
+			LastIP:    s.LastIP,
+			UserAgent: s.UserAgent,
+			Current:   s.ID == currentID,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].LastSeen > infos[j].LastSeen })
+	return infos
+}
+
+func currentClaims(r *http.Request) *TokenClaims {
+	claims, _ := r.Context().Value("claims").(*TokenClaims)
+	return claims
+}
+
+func currentSessionID(r *http.Request) string {
+	if claims := currentClaims(r); claims != nil {
+		return claims.SessionID
+	}
+	return ""
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SessionList struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// 使用刷新 Token 换取新的访问 Token 和刷新 Token
+func refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return
+	}
+
+	session, refresh, err := refreshSession(req.RefreshToken, r)
+	if errors.Is(err, errInvalidRefreshToken) {
+		respondError(w, r, http.StatusUnauthorized, errCodeInvalidToken, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to refresh session")
+		return
+	}
+	user, err := findUser(session.Username)
+	if err != nil {
+		deleteSession(session.ID)
+		respondError(w, r, http.StatusUnauthorized, errCodeInvalidToken, "Invalid refresh token")
+		return
+	}
+
+	token, err := generateToken(user, session.ID)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate token")
+		return
+	}
+	respondData(w, r, http.StatusOK, LoginResponse{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(tokenExp.Seconds()),
+		Role:         user.Role,
+	})
+}
+
+// 会话列表，管理员可以通过 ?all=1 查看所有用户的会话
+func listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	if r.URL.Query().Get("all") == "1" {
+		if !requireRole(w, r, RoleAdmin) {
+			return
+		}
+		username = ""
+	}
+	respondData(w, r, http.StatusOK, SessionList{
+		Sessions: listSessions(username, currentSessionID(r)),
+	})
+}
+
+// 注销单个会话，普通用户只能注销自己的会话
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req IDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return
+	}
+
+	sessionMutex.Lock()
+	s, ok := sessions[req.ID]
+	owner := ""
+	if ok {
+		owner = s.Username
+	}
+	sessionMutex.Unlock()
+	if !ok {
+		respondError(w, r, http.StatusNotFound, errCodeNotFound, "Session not found")
+		return
+	}
+	if owner != currentUsername(r) && !requireRole(w, r, RoleAdmin) {
+		return
+	}
+
+	if err := deleteSession(req.ID); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to revoke session")
+		return
+	}
+	respondData(w, r, http.StatusOK, MessageResponse{Message: "Session revoked"})
+}
+
+func initSessions() {
+	loadSessions()
+}
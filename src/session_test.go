@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRefreshSession(t *testing.T) {
+	useTestStore(t, nil)
+	loadSessions()
+	r := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+
+	s, first, err := createSession("admin", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话 ID 正确但随机串不对：拒绝，会话保留
+	if _, _, err := refreshSession(s.ID+".guessed", r); err == nil {
+		t.Fatal("错误的刷新 Token 应被拒绝")
+	}
+	if !sessionExists(s.ID) {
+		t.Fatal("随机串不匹配时不应注销会话")
+	}
+
+	second := first
+	if _, second, err = refreshSession(first, r); err != nil || second == first || !strings.HasPrefix(second, s.ID+".") {
+		t.Fatalf("刷新: %q, %v", second, err)
+	}
+
+	// 重启后仍能识别上一个刷新 Token，再次使用时注销整个会话
+	loadSessions()
+	if _, _, err := refreshSession(first, r); err == nil {
+		t.Fatal("已轮换的刷新 Token 应被拒绝")
+	}
+	if sessionExists(s.ID) {
+		t.Error("重复使用已轮换的刷新 Token 后应注销会话")
+	}
+	if _, _, err := refreshSession(second, r); err == nil {
+		t.Error("会话注销后最新的刷新 Token 也应失效")
+	}
+}
+
+// 注销被盗用的会话失败时返回错误，不能当作普通的无效刷新 Token
+func TestRefreshSessionRevokeError(t *testing.T) {
+	useTestStore(t, nil)
+	loadSessions()
+	r := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+
+	s, first, err := createSession("admin", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := refreshSession(first, r); err != nil {
+		t.Fatal(err)
+	}
+
+	closeStore()
+	if _, _, err := refreshSession(first, r); err == nil || errors.Is(err, errInvalidRefreshToken) {
+		t.Errorf("注销会话失败时返回 %v，期望数据库错误", err)
+	}
+	if !sessionExists(s.ID) {
+		t.Error("注销失败时会话不应从内存中删除")
+	}
+}
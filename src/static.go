@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"assismgr/public"
+)
+
+// 静态文件默认使用打包进二进制的 public 目录，-s / static_dir 指定目录时从磁盘读取（开发调试用）
+var (
+	staticFS       fs.FS = public.FS
+	staticEmbedded       = true
+)
+
+// 支持的预压缩编码，按优先级排列
+var staticEncodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// 没有预压缩文件时在内存中进行 gzip 压缩的文件类型
+var staticCompressible = map[string]bool{
+	".html": true,
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".svg":  true,
+	".ico":  true,
+}
+
+const staticMinCompress = 512
+
+// 静态文件的一种编码版本
+type staticVariant struct {
+	data []byte
+	etag string
+}
+
+type staticAsset struct {
+	modTime  time.Time
+	variants map[string]*staticVariant // key 为 Content-Encoding，原始内容为 ""
+}
+
+var (
+	staticCacheLock sync.Mutex
+	staticCache     = map[string]*staticAsset{}
+)
+
+func initStatic(dir string) {
+	if dir == "" {
+		log.Println("使用内置的 Web 界面")
+		return
+	}
+	log.Println("使用静态文件目录", dir)
+	staticFS = os.DirFS(dir)
+	staticEmbedded = false
+}
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// 读取静态文件及其预压缩版本。内置文件不会变化，读取后缓存；磁盘目录每次重新读取，方便调试
+func loadStaticAsset(name string) (*staticAsset, error) {
+	if staticEmbedded {
+		staticCacheLock.Lock()
+		defer staticCacheLock.Unlock()
+		if a, ok := staticCache[name]; ok {
+			return a, nil
+		}
+	}
+
+	info, err := fs.Stat(staticFS, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	data, err := fs.ReadFile(staticFS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// ETag 使用原始内容的哈希，不同编码加后缀区分
+	sum := sha256.Sum256(data)
+	tag := hex.EncodeToString(sum[:8])
+	a := &staticAsset{
+		modTime:  info.ModTime(),
+		variants: map[string]*staticVariant{"": {data: data, etag: strconv.Quote(tag)}},
+	}
+	for _, enc := range staticEncodings {
+		if b, err := fs.ReadFile(staticFS, name+enc.ext); err == nil {
+			a.variants[enc.name] = &staticVariant{data: b, etag: strconv.Quote(tag + "-" + enc.name)}
+		}
+	}
+	if _, ok := a.variants["gzip"]; !ok && staticCompressible[path.Ext(name)] && len(data) >= staticMinCompress {
+		if gz := gzipBytes(data); len(gz) < len(data) {
+			a.variants["gzip"] = &staticVariant{data: gz, etag: strconv.Quote(tag + "-gzip")}
+		}
+	}
+
+	if staticEmbedded {
+		staticCache[name] = a
+	}
+	return a, nil
+}
+
+// 客户端是否接受指定编码（q=0 表示不接受）
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// 页面每次都向服务器校验，其他资源缓存一段时间；带 ?v= 版本参数的请求可以长期缓存
+func staticCacheControl(r *http.Request, name string) string {
+	switch {
+	case r.URL.Query().Get("v") != "":
+		return "public, max-age=31536000, immutable"
+	case path.Ext(name) == ".html":
+		return "no-cache"
+	default:
+		return "public, max-age=600"
+	}
+}
+
+// 返回静态文件，根据 Accept-Encoding 选择预压缩版本，支持 ETag 条件请求
+func serveStaticFile(w http.ResponseWriter, r *http.Request, name string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	a, err := loadStaticAsset(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	v := a.variants[""]
+	if len(a.variants) > 1 {
+		h.Add("Vary", "Accept-Encoding")
+		for _, enc := range staticEncodings {
+			if cv, ok := a.variants[enc.name]; ok && acceptsEncoding(r, enc.name) {
+				v = cv
+				h.Set("Content-Encoding", enc.name)
+				break
+			}
+		}
+	}
+	// 压缩后的内容无法自动识别类型，按扩展名设置
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("ETag", v.etag)
+	h.Set("Cache-Control", staticCacheControl(r, name))
+	http.ServeContent(w, r, name, a.modTime, bytes.NewReader(v.data))
+}
+
+// /static/ 路由
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	serveStaticFile(w, r, strings.TrimPrefix(r.URL.Path, "/static/"))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 用户、会话、API Key、Token 注销列表、审计日志、LED 状态、其他设置和系统指标历史
+// 保存在同一个 SQLite 数据库中。
+// 表结构通过 storeMigrations 按顺序升级，已执行到的版本记录在 PRAGMA user_version 中
+var stateDB *sql.DB
+
+var errSettingNotFound = errors.New("setting not found")
+
+// settings 表中的键
+const (
+	settingJWTKey    = "jwt_key"    // JWT 签名密钥
+	settingLedStatus = "led_status" // 用户设置的 LED 开关，ON 或 OFF
+)
+
+func stateDBPath() string {
+	return dataPath("state.db")
+}
+
+// 一次表结构升级，在事务中执行，失败时整体回滚
+type storeMigration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+// 只能在末尾追加，已发布的升级不能修改
+var storeMigrations = []storeMigration{
+	{name: "create tables", up: execMigration(`
+CREATE TABLE users (
+	username             TEXT PRIMARY KEY,
+	password_hash        TEXT    NOT NULL,
+	email                TEXT    NOT NULL DEFAULT '',
+	role                 TEXT    NOT NULL,
+	token_generation     INTEGER NOT NULL DEFAULT 0,
+	must_change_password INTEGER NOT NULL DEFAULT 0,
+	totp_secret          TEXT    NOT NULL DEFAULT '',
+	totp_pending         TEXT    NOT NULL DEFAULT '',
+	totp_last_step       INTEGER NOT NULL DEFAULT 0,
+	recovery_codes       TEXT    NOT NULL DEFAULT '[]',
+	created_at           INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE sessions (
+	id           TEXT PRIMARY KEY,
+	username     TEXT    NOT NULL,
+	refresh_hash TEXT    NOT NULL,
+	issued_at    INTEGER NOT NULL,
+	expires_at   INTEGER NOT NULL,
+	last_seen    INTEGER NOT NULL,
+	last_ip      TEXT    NOT NULL,
+	user_agent   TEXT    NOT NULL,
+
+	prev_refresh_hash TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX sessions_username ON sessions(username);
+CREATE TABLE led_states (
+	name TEXT PRIMARY KEY,
+	mode TEXT NOT NULL
+);
+CREATE TABLE settings (
+	key   TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+`)},
+	{name: "import legacy files", up: importLegacyFiles},
+	{name: "create metrics tables", up: execMigration(`
+CREATE TABLE metrics_minute (
+	metric TEXT    NOT NULL,
+	ts     INTEGER NOT NULL,
+	min    REAL    NOT NULL,
+	max    REAL    NOT NULL,
+	avg    REAL    NOT NULL,
+	count  INTEGER NOT NULL,
+	PRIMARY KEY (metric, ts)
+) WITHOUT ROWID;
+CREATE INDEX metrics_minute_ts ON metrics_minute(ts);
+CREATE TABLE metrics_hour (
+	metric TEXT    NOT NULL,
+	ts     INTEGER NOT NULL,
+	min    REAL    NOT NULL,
+	max    REAL    NOT NULL,
+	avg    REAL    NOT NULL,
+	count  INTEGER NOT NULL,
+	PRIMARY KEY (metric, ts)
+) WITHOUT ROWID;
+CREATE INDEX metrics_hour_ts ON metrics_hour(ts);
+`)},
+	{name: "create api key, revoked token and audit tables", up: execMigration(`
+CREATE TABLE api_keys (
+	id         TEXT PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	owner      TEXT    NOT NULL,
+	scopes     TEXT    NOT NULL,
+	hash       TEXT    NOT NULL,
+	created_at INTEGER NOT NULL,
+	last_used  INTEGER NOT NULL DEFAULT 0,
+	last_ip    TEXT    NOT NULL DEFAULT ''
+);
+CREATE TABLE revoked_tokens (
+	id         TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX revoked_tokens_expires_at ON revoked_tokens(expires_at);
+CREATE TABLE audit_log (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	time     INTEGER NOT NULL,
+	username TEXT    NOT NULL,
+	auth     TEXT    NOT NULL,
+	action   TEXT    NOT NULL,
+	method   TEXT    NOT NULL,
+	params   TEXT    NOT NULL,
+	ip       TEXT    NOT NULL,
+	status   INTEGER NOT NULL,
+	result   TEXT    NOT NULL
+);
+CREATE INDEX audit_log_time ON audit_log(time);
+CREATE INDEX audit_log_username ON audit_log(username);
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+-- 只保留最近的 50000 条：超出的记录在插入时删除，其余记录不允许删除
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+WHEN OLD.id > (SELECT MAX(id) FROM audit_log) - 50000
+BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+CREATE TRIGGER audit_log_retention AFTER INSERT ON audit_log
+BEGIN DELETE FROM audit_log WHERE id <= NEW.id - 50000; END;
+`)},
+}
+
+func execMigration(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// 打开状态数据库并执行未完成的升级。设备经常直接断电，使用 synchronous=FULL 保证已提交的事务落盘
+func initStore() error {
+	db, err := sql.Open("sqlite3", stateDBPath()+"?_busy_timeout=5000&_journal_mode=WAL&_synchronous=FULL")
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+	if err := migrateStore(db); err != nil {
+		db.Close()
+		return err
+	}
+	stateDB = db
+	return nil
+}
+
+func closeStore() {
+	if stateDB != nil {
+		stateDB.Close()
+	}
+}
+
+// 执行 user_version 之后的所有升级
+func migrateStore(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("读取数据库版本失败: %w", err)
+	}
+	if version > len(storeMigrations) {
+		return fmt.Errorf("数据库版本 %d 高于程序支持的版本 %d", version, len(storeMigrations))
+	}
+
+	for i := version; i < len(storeMigrations); i++ {
+		m := storeMigrations[i]
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("数据库升级 %d（%s）失败: %w", i+1, m.name, err)
+		}
+		// user_version 保存在数据库文件头中，随事务一起提交
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("数据库已升级到版本 %d: %s", i+1, m.name)
+	}
+	return nil
+}
+
+// 在事务中执行 fn，fn 返回错误时回滚
+func storeTx(fn func(tx *sql.Tx) error) error {
+	tx, err := stateDB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// 读取设置，不存在时返回 errSettingNotFound
+func getSetting(key string) ([]byte, error) {
+	var value []byte
+	err := stateDB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSettingNotFound
+	}
+	return value, err
+}
+
+func setSetting(key string, value []byte) error {
+	_, err := stateDB.Exec(
+		"INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+		key, value,
+	)
+	return err
+}
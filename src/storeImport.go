@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// 旧版本把状态分别保存在数据目录下的多个文件中，升级数据库时导入一次。
+// 旧文件保留不动，便于回退到旧版本，导入后不再读取
+const (
+	legacyUserFile     = "user.json"          // 唯一的用户，视为管理员
+	legacyLedStateFile = "assismgr/ledstatus" // 各 LED 的模式
+	legacyLedFile      = "ledstatus"          // 用户设置的 LED 开关
+	legacyKeyFile      = "assismgr-key"
+)
+
+// 用户和密钥无法读取时中止升级，避免以默认账号启动；LED 状态丢失不影响使用，只记录日志
+func importLegacyFiles(tx *sql.Tx) error {
+	var users []User
+	data, err := os.ReadFile(dataPath(legacyUserFile))
+	if err == nil {
+		var user *User
+		if user, err = parseLegacyUser(data); err == nil {
+			users = append(users, *user)
+		}
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("读取 %s 失败: %w", legacyUserFile, err)
+	}
+	if err := insertUsers(tx, users); err != nil {
+		return err
+	}
+
+	key, err := os.ReadFile(dataPath(legacyKeyFile))
+	if err == nil && len(key) == 0 {
+		err = errors.New("JWT key file is empty")
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("读取 %s 失败: %w", legacyKeyFile, err)
+	}
+	if err == nil {
+		if _, err := tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?)", settingJWTKey, key); err != nil {
+			return err
+		}
+	}
+
+	var states map[string]string
+	data, err = os.ReadFile(dataPath(legacyLedStateFile))
+	if err == nil {
+		err = json.Unmarshal(data, &states)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("导入 %s 失败: %v", legacyLedStateFile, err)
+	}
+	if err := insertLedStates(tx, states); err != nil {
+		return err
+	}
+
+	var status string
+	data, err = os.ReadFile(dataPath(legacyLedFile))
+	if err == nil {
+		status = strings.ToUpper(strings.TrimSpace(string(data)))
+		if status != "ON" && status != "OFF" {
+			err = fmt.Errorf("无效的LED状态 %q", status)
+		}
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("导入 %s 失败: %v", legacyLedFile, err)
+	}
+	if err == nil {
+		if _, err := tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?)", settingLedStatus, []byte(status)); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("已导入旧数据文件: %d 个用户，%d 个 LED 状态", len(users), len(states))
+	return nil
+}
+
+// 旧版本的 user.json 只保存一个用户，导入为管理员
+func parseLegacyUser(data []byte) (*User, error) {
+	var user User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, err
+	}
+	if user.Username == "" {
+		return nil, errors.New("user file contains no user")
+	}
+	user.Role = RoleAdmin
+	return &user, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// 在临时根目录中写入数据目录下的文件并打开状态数据库，files 的键为相对数据目录的路径
+func useTestStore(t *testing.T, files map[string]string) {
+	t.Helper()
+	useSandboxRoot(t, nil)
+	for name, content := range files {
+		p := dataPath(name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(dataPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	old := stateDB
+	if err := initStore(); err != nil {
+		t.Fatalf("initStore: %v", err)
+	}
+	t.Cleanup(func() {
+		closeStore()
+		stateDB = old
+	})
+}
+
+func TestMigrateStore(t *testing.T) {
+	useTestStore(t, nil)
+
+	var version int
+	if err := stateDB.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(storeMigrations) {
+		t.Errorf("user_version = %d，期望 %d", version, len(storeMigrations))
+	}
+
+	// 已是最新版本时不再执行升级
+	if err := migrateStore(stateDB); err != nil {
+		t.Errorf("重复执行升级: %v", err)
+	}
+
+	// 数据库来自更新的版本时拒绝启动
+	if _, err := stateDB.Exec("PRAGMA user_version = " + strconv.Itoa(len(storeMigrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateStore(stateDB); err == nil {
+		t.Error("数据库版本高于程序支持的版本时应返回错误")
+	}
+}
+
+// 数据库连接上累计改动的行数，stateDB 只有一个连接
+func totalChanges(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := stateDB.QueryRow("SELECT total_changes()").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	useTestStore(t, nil)
+
+	users := []User{
+		{Username: "admin", PasswordHash: "hash", Role: RoleAdmin, TokenGeneration: 3, TOTPSecret: "SECRET", TOTPLastStep: 42, RecoveryCodes: []string{"a", "b"}},
+		{Username: "viewer", PasswordHash: "hash2", Email: "v@example.com", Role: RoleViewer, MustChangePassword: true},
+	}
+	userMutex.Lock()
+	err := writeUsers(nil, users)
+	got, readErr := readUsers()
+	userMutex.Unlock()
+	if err != nil || readErr != nil {
+		t.Fatalf("writeUsers: %v, readUsers: %v", err, readErr)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("readUsers() = %+v，期望 %+v", got, users)
+	}
+
+	// 只写入变化的行：修改一个用户、删除一个用户各改动一行，修改后保持创建顺序
+	for _, tt := range []struct {
+		name string
+		fn   func(users []User) []User
+		want []string
+	}{
+		{"修改", func(users []User) []User { users[0].Email = "a@example.com"; return users }, []string{"admin", "viewer"}},
+		{"删除", func(users []User) []User { return users[:1] }, []string{"admin"}},
+	} {
+		before := totalChanges(t)
+		if err := modifyUsers(func(users []User) ([]User, error) { return tt.fn(users), nil }); err != nil {
+			t.Fatal(err)
+		}
+		if n := totalChanges(t) - before; n != 1 {
+			t.Errorf("%s: 改动了 %d 行，期望 1", tt.name, n)
+		}
+		got, _ := loadUsers()
+		var names []string
+		for _, u := range got {
+			names = append(names, u.Username)
+		}
+		if !reflect.DeepEqual(names, tt.want) || got[0].Email != "a@example.com" {
+			t.Errorf("%s: 用户 = %+v", tt.name, got)
+		}
+	}
+
+	// 会话同样逐行写入
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	s1, _, err := createSession("admin", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := createSession("admin", r); err != nil {
+		t.Fatal(err)
+	}
+	before := totalChanges(t)
+	if err := deleteSession(s1.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	stateDB.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&count)
+	if n := totalChanges(t) - before; n != 1 || count != 1 {
+		t.Errorf("注销会话改动了 %d 行，剩余 %d 个会话", n, count)
+	}
+
+	if err := writeLedStates(map[string]string{"sys_led": "heartbeat", "user_led": "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveLedStatus("user_led", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if states, err := readLedStates(); err != nil || !reflect.DeepEqual(states, map[string]string{"sys_led": "heartbeat", "user_led": "on"}) {
+		t.Errorf("readLedStates() = %v, %v", states, err)
+	}
+
+	if got := getStoredLedStatus(); got != "ON" {
+		t.Errorf("未设置时 getStoredLedStatus() = %q", got)
+	}
+	if err := saveStoredLedStatus("OFF"); err != nil {
+		t.Fatal(err)
+	}
+	if got := getStoredLedStatus(); got != "OFF" {
+		t.Errorf("getStoredLedStatus() = %q", got)
+	}
+}
+
+func TestImportLegacyFiles(t *testing.T) {
+	useTestStore(t, map[string]string{
+		legacyUserFile:              `{"username": "root", "password_hash": "h"}`,
+		legacyLedStateFile:          `{"sys_led": "heartbeat", "user_led": "off"}`,
+		legacyLedFile:               "off\n",
+		legacyKeyFile:               "legacy-secret",
+		"unrelated/should-not-read": "x",
+	})
+
+	userMutex.Lock()
+	users, err := readUsers()
+	userMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "root" || users[0].Role != RoleAdmin {
+		t.Errorf("导入的用户 = %+v，旧版本的用户应视为管理员", users)
+	}
+
+	if states, err := readLedStates(); err != nil || !reflect.DeepEqual(states, map[string]string{"sys_led": "heartbeat", "user_led": "off"}) {
+		t.Errorf("导入的LED状态 = %v, %v", states, err)
+	}
+	if got := getStoredLedStatus(); got != "OFF" {
+		t.Errorf("导入的LED开关 = %q", got)
+	}
+	if key, err := getSetting(settingJWTKey); err != nil || string(key) != "legacy-secret" {
+		t.Errorf("导入的密钥 = %q, %v", key, err)
+	}
+
+	// 旧文件保留，便于回退
+	if _, err := os.Stat(dataPath(legacyKeyFile)); err != nil {
+		t.Errorf("旧文件被删除: %v", err)
+	}
+}
+
+func TestImportCorruptUsersFails(t *testing.T) {
+	useSandboxRoot(t, nil)
+	if err := os.MkdirAll(dataPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataPath(legacyUserFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := stateDB
+	defer func() { stateDB = old }()
+	if err := initStore(); err == nil {
+		closeStore()
+		t.Fatal("用户文件损坏时应中止升级，不能以默认账号启动")
+	}
+
+	// 升级失败时整体回滚，修复文件后可以重新导入
+	if err := os.WriteFile(dataPath(legacyUserFile), []byte(`{"username": "admin", "password_hash": "h"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := initStore(); err != nil {
+		t.Fatalf("修复后 initStore: %v", err)
+	}
+	defer closeStore()
+	var n int
+	if err := stateDB.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil || n != 1 {
+		t.Errorf("用户数 = %d, %v", n, err)
+	}
+}
+
+func TestAuditLogRetention(t *testing.T) {
+	useTestStore(t, nil)
+	tx, err := stateDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < auditMaxRows+10; i++ {
+		if _, err := tx.Exec(`INSERT INTO audit_log (time, username, auth, action, method, params, ip, status, result)
+			VALUES (?, 'admin', 'password', '/api/v1/auth/login', 'POST', '{}', '10.0.0.2', 401, 'wrong password')`, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出上限的最早记录被删除
+	var n, first int64
+	if err := stateDB.QueryRow("SELECT COUNT(*), MIN(id) FROM audit_log").Scan(&n, &first); err != nil {
+		t.Fatal(err)
+	}
+	if n != auditMaxRows || first != 11 {
+		t.Errorf("保留 %d 条，最早的 ID %d", n, first)
+	}
+
+	// 保留的记录仍然只允许追加
+	if _, err := stateDB.Exec("DELETE FROM audit_log WHERE id = ?", first); err == nil {
+		t.Error("审计日志应拒绝删除")
+	}
+	if _, err := stateDB.Exec("UPDATE audit_log SET result = 'ok'"); err == nil {
+		t.Error("审计日志应拒绝修改")
+	}
+}
@@ -1,77 +1,82 @@
 package main
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 )
 
-const configPath = "/opt/config/frp/frpc.toml"
+func restartFrpcHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := getRunner().Output(context.Background(), "systemctl", "restart", "frpc")
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, err.Error())
+		return
+	}
+	respondText(w, r, "restart frp successfully")
+}
 
-func sysconfigInit() {
-	handleAuthRoute("/sysconfig/get", getConfigHandler)
-	handleAuthRoute("/sysconfig/save", saveConfigHandler)
-	handleAuthRoute("/sysconfig/restart", restartFrpcHandler)
+type ConfigContent struct {
+	Content string `json:"content"`
 }
 
-func restartFrpcHandler(w http.ResponseWriter, r *http.Request) {
-	cmd := exec.Command("systemctl", "restart", "frpc")
-	err := cmd.Run()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// 返回 frpc 配置文件内容，旧路径返回纯文本，/api/v1 返回 {"content": ...}
+func respondConfigContent(w http.ResponseWriter, r *http.Request, data []byte) {
+	if isAPIRequest(r) {
+		respondData(w, r, http.StatusOK, ConfigContent{Content: string(data)})
 		return
 	}
-	w.Write([]byte("restart frp successfully"))
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(data)
 }
 
 func getConfigHandler(w http.ResponseWriter, r *http.Request) {
 	// 确保配置文件目录存在
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		http.Error(w, "Failed to create config directory", http.StatusInternalServerError)
+	if err := sysFS().MkdirAll(filepath.Dir(getConfig().FrpcConfig), 0755); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to create config directory")
 		return
 	}
 
 	// 读取配置文件
-	data, err := os.ReadFile(configPath)
+	data, err := sysFS().ReadFile(getConfig().FrpcConfig)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// 文件不存在则返回空内容
-			w.Write([]byte(""))
+			respondConfigContent(w, r, nil)
 			return
 		}
-		http.Error(w, "Failed to read config file", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to read config file")
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write(data)
+	respondConfigContent(w, r, data)
 }
 
+// 保存 frpc 配置文件，请求体为配置文件内容
 func saveConfigHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Failed to read request body")
 		return
 	}
 
 	// 确保配置文件目录存在
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		http.Error(w, "Failed to create config directory", http.StatusInternalServerError)
+	if err := sysFS().MkdirAll(filepath.Dir(getConfig().FrpcConfig), 0755); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to create config directory")
 		return
 	}
 
-	// 写入配置文件
-	if err := os.WriteFile(configPath, body, 0644); err != nil {
-		http.Error(w, "Failed to save config file", http.StatusInternalServerError)
+	// 写入配置文件，保留上一份配置为 .bak
+	if err := writeFileAtomic(sysFS().Path(getConfig().FrpcConfig), body, 0644); err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to save config file")
 		return
 	}
 
-	w.Write([]byte("Config saved successfully"))
+	respondText(w, r, "Config saved successfully")
 }
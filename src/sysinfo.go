@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SystemInfo 中用于诊断开发板的扩展信息：负载、运行时间、各核频率和降频状态。
+// 均从 /proc 和 /sys 读取，读取失败的项保持零值
+
+// 最近 1、5、15 分钟的平均负载
+type LoadAvg struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// 单个 CPU 核的频率，单位 MHz
+type CPUFreq struct {
+	Core int     `json:"core"`
+	Cur  float64 `json:"cur_mhz"`
+	Max  float64 `json:"max_mhz"` // 硬件支持的最高频率
+}
+
+// 降频状态。Reasons 为当前生效的原因：
+// thermal（温控降频）、freq_capped（频率上限低于硬件最高频率）、
+// under_voltage、throttled、soft_temp_limit（后三项来自树莓派的 vcgencmd）
+type ThrottleInfo struct {
+	Throttled bool     `json:"throttled"`
+	Reasons   []string `json:"reasons"`
+	Occurred  []string `json:"occurred,omitempty"` // 开机以来出现过的原因，仅树莓派
+}
+
+// vcgencmd get_throttled 的标志位，低 4 位为当前状态，16~19 位为开机以来是否出现过
+var vcgencmdThrottleBits = []string{"under_voltage", "freq_capped", "throttled", "soft_temp_limit"}
+
+// 读取 /proc/loadavg
+func readLoadAvg() (LoadAvg, error) {
+	data, err := sysFS().ReadFile("/proc/loadavg")
+	if err != nil {
+		return LoadAvg{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return LoadAvg{}, fmt.Errorf("invalid loadavg format")
+	}
+	var load [3]float64
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return LoadAvg{}, err
+		}
+	}
+	return LoadAvg{Load1: load[0], Load5: load[1], Load15: load[2]}, nil
+}
+
+// 读取 /proc/uptime 中的开机时长，单位秒
+func readUptime() (uint64, error) {
+	data, err := sysFS().ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid uptime format")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(seconds), nil
+}
+
+// 读取以 kHz 为单位的 cpufreq 文件，返回 MHz
+func readKHz(path string) (float64, bool) {
+	data, err := sysFS().ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	khz, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, false
+	}
+	return khz / 1000, true
+}
+
+// 读取各核当前频率，按核编号排序。不支持 cpufreq 的核（例如已下线）跳过
+func readCPUFreqs() []CPUFreq {
+	dirs, _ := sysFS().Glob("/sys/devices/system/cpu/cpu[0-9]*")
+	freqs := []CPUFreq{}
+	for _, dir := range dirs {
+		core, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "cpu"))
+		if err != nil {
+			continue
+		}
+		cur, ok := readKHz(dir + "/cpufreq/scaling_cur_freq")
+		if !ok {
+			continue
+		}
+		max, _ := readKHz(dir + "/cpufreq/cpuinfo_max_freq")
+		freqs = append(freqs, CPUFreq{Core: core, Cur: cur, Max: max})
+	}
+	sort.Slice(freqs, func(i, j int) bool { return freqs[i].Core < freqs[j].Core })
+	return freqs
+}
+
+// 汇总降频状态：CPU 相关的冷却设备处于非零档位时为温控降频，
+// 任一核的 scaling_max_freq 低于 cpuinfo_max_freq 时为频率受限，树莓派上再合并 vcgencmd 的结果
+func readThrottle(ctx context.Context) ThrottleInfo {
+	reasons := map[string]bool{}
+
+	devices, _ := sysFS().Glob("/sys/class/thermal/cooling_device*")
+	for _, dev := range devices {
+		typ, err := sysFS().ReadFile(dev + "/type")
+		if err != nil || !strings.Contains(strings.ToLower(string(typ)), "cpu") {
+			continue
+		}
+		state, err := sysFS().ReadFile(dev + "/cur_state")
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(string(state))); err == nil && n > 0 {
+			reasons["thermal"] = true
+		}
+	}
+
+	dirs, _ := sysFS().Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq")
+	for _, dir := range dirs {
+		limit, ok1 := readKHz(dir + "/scaling_max_freq")
+		max, ok2 := readKHz(dir + "/cpuinfo_max_freq")
+		if ok1 && ok2 && limit < max {
+			reasons["freq_capped"] = true
+		}
+	}
+
+	var info ThrottleInfo
+	if flags, ok := readVcgencmdThrottled(ctx); ok {
+		for i, name := range vcgencmdThrottleBits {
+			if flags&(1<<i) != 0 {
+				reasons[name] = true
+			}
+			if flags&(1<<(16+i)) != 0 {
+				info.Occurred = append(info.Occurred, name)
+			}
+		}
+	}
+
+	info.Reasons = []string{}
+	for name := range reasons {
+		info.Reasons = append(info.Reasons, name)
+	}
+	sort.Strings(info.Reasons)
+	info.Throttled = len(info.Reasons) > 0
+	return info
+}
+
+// 执行 vcgencmd get_throttled，输出形如 throttled=0x50005。非树莓派上命令不存在，返回 false
+func readVcgencmdThrottled(ctx context.Context) (uint64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	out, err := getRunner().Output(ctx, "vcgencmd", "get_throttled")
+	if err != nil {
+		return 0, false
+	}
+	_, value, ok := strings.Cut(strings.TrimSpace(string(out)), "=")
+	if !ok {
+		return 0, false
+	}
+	flags, err := strconv.ParseUint(strings.TrimPrefix(value, "0x"), 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return flags, true
+}
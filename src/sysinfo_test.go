@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// 两个核的频率信息：cpu0 的频率上限被调低，cpu2 不支持 cpufreq
+var cpuFreqFiles = map[string]string{
+	"/sys/devices/system/cpu/cpu0/cpufreq/scaling_cur_freq": "600000\n",
+	"/sys/devices/system/cpu/cpu0/cpufreq/scaling_max_freq": "1200000\n",
+	"/sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq": "1500000\n",
+	"/sys/devices/system/cpu/cpu1/cpufreq/scaling_cur_freq": "1500000\n",
+	"/sys/devices/system/cpu/cpu1/cpufreq/scaling_max_freq": "1500000\n",
+	"/sys/devices/system/cpu/cpu1/cpufreq/cpuinfo_max_freq": "1500000\n",
+	"/sys/devices/system/cpu/cpu2/online":                   "0\n",
+	"/sys/devices/system/cpu/cpufreq":                       "",
+}
+
+func TestReadLoadAvgAndUptime(t *testing.T) {
+	useSandboxRoot(t, map[string]string{
+		"/proc/loadavg": "0.52 0.31 0.12 1/123 4567\n",
+		"/proc/uptime":  "3725.48 7000.12\n",
+	})
+	if got, err := readLoadAvg(); err != nil || got != (LoadAvg{0.52, 0.31, 0.12}) {
+		t.Errorf("readLoadAvg() = %+v, %v", got, err)
+	}
+	if got, err := readUptime(); err != nil || got != 3725 {
+		t.Errorf("readUptime() = %d, %v", got, err)
+	}
+
+	useSandboxRoot(t, map[string]string{"/proc/loadavg": "garbage\n"})
+	if _, err := readLoadAvg(); err == nil {
+		t.Error("格式错误时应返回错误")
+	}
+}
+
+func TestReadCPUFreqs(t *testing.T) {
+	useSandboxRoot(t, cpuFreqFiles)
+	want := []CPUFreq{{Core: 0, Cur: 600, Max: 1500}, {Core: 1, Cur: 1500, Max: 1500}}
+	if got := readCPUFreqs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("readCPUFreqs() = %+v", got)
+	}
+}
+
+func TestReadThrottle(t *testing.T) {
+	t.Run("未降频", func(t *testing.T) {
+		useSandboxRoot(t, map[string]string{
+			"/sys/class/thermal/cooling_device0/type":      "cpufreq-cpu0\n",
+			"/sys/class/thermal/cooling_device0/cur_state": "0\n",
+		})
+		newFakeRunner(t)
+		got := readThrottle(context.Background())
+		if got.Throttled || len(got.Reasons) != 0 || got.Occurred != nil {
+			t.Errorf("readThrottle() = %+v", got)
+		}
+	})
+
+	t.Run("温控降频和频率受限", func(t *testing.T) {
+		files := map[string]string{
+			"/sys/class/thermal/cooling_device0/type":      "cpufreq-cpu0\n",
+			"/sys/class/thermal/cooling_device0/cur_state": "2\n",
+			// 风扇不是 CPU 降频
+			"/sys/class/thermal/cooling_device1/type":      "pwm-fan\n",
+			"/sys/class/thermal/cooling_device1/cur_state": "3\n",
+		}
+		for k, v := range cpuFreqFiles {
+			files[k] = v
+		}
+		useSandboxRoot(t, files)
+		newFakeRunner(t)
+		got := readThrottle(context.Background())
+		if !got.Throttled || !reflect.DeepEqual(got.Reasons, []string{"freq_capped", "thermal"}) {
+			t.Errorf("readThrottle() = %+v", got)
+		}
+	})
+
+	t.Run("树莓派", func(t *testing.T) {
+		useSandboxRoot(t, nil)
+		// 当前欠压，开机以来出现过欠压和温度软限制
+		newFakeRunner(t).on("vcgencmd get_throttled", "throttled=0x90001\n", nil)
+		got := readThrottle(context.Background())
+		want := ThrottleInfo{Throttled: true, Reasons: []string{"under_voltage"}, Occurred: []string{"under_voltage", "soft_temp_limit"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("readThrottle() = %+v", got)
+		}
+	})
+}
+
+func TestMQTTSystemState(t *testing.T) {
+	info := SystemInfo{
+		CPUUsage:     40,
+		CPUCores:     []float64{30, 50},
+		CPUFreqs:     []CPUFreq{{Core: 1, Cur: 1500, Max: 1500}},
+		Load:         LoadAvg{Load1: 1.5},
+		Uptime:       60,
+		Temperatures: map[string]float64{"cpu-thermal": 45.5},
+		Throttle:     ThrottleInfo{Throttled: true, Reasons: []string{"freq_capped", "thermal"}},
+	}
+	state := mqttSystemState(info)
+	for key, want := range map[string]interface{}{
+		"cpu_usage":        40.0,
+		"cpu0_usage":       30.0,
+		"cpu1_usage":       50.0,
+		"cpu1_freq":        1500.0,
+		"load1":            1.5,
+		"uptime":           uint64(60),
+		"temp_cpu_thermal": 45.5,
+		"throttle":         "freq_capped,thermal",
+	} {
+		if state[key] != want {
+			t.Errorf("state[%q] = %v，期望 %v", key, state[key], want)
+		}
+	}
+	if _, ok := state["cpu0_freq"]; ok {
+		t.Error("不支持 cpufreq 的核不应有频率")
+	}
+
+	if got := mqttSystemState(SystemInfo{})["throttle"]; got != "none" {
+		t.Errorf("未降频时 throttle = %v", got)
+	}
+}
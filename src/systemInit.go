@@ -4,12 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"strings"
 	"unsafe"
 )
 
@@ -18,63 +16,76 @@ const (
 	OFF bool = false
 )
 
-func handleGetStatus(w http.ResponseWriter) {
-	data, err := os.ReadFile("/mnt/data/ledstatus")
+// LED 状态
+type Ledstatus struct {
+	STATUS string `json:"status"`
+}
+
+// 查询 LED 状态
+func getLedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	status, err := readStoredLedStatus()
 	if err != nil {
-		if os.IsNotExist(err) {
-			sendErrorResponse(w, http.StatusNotFound, "状态文件不存在")
+		if errors.Is(err, errSettingNotFound) {
+			sendErrorResponse(w, r, http.StatusNotFound, errCodeNotFound, "没有保存的状态")
 		} else {
-			sendErrorResponse(w, http.StatusInternalServerError, "无法读取状态")
+			sendErrorResponse(w, r, http.StatusInternalServerError, errCodeInternal, "无法读取状态")
 		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status": "%s"}`, strings.ToUpper(string(data)))
+	respondData(w, r, http.StatusOK, Ledstatus{STATUS: status})
 }
 
-type Ledstatus struct {
-	STATUS string `json:status`
-}
+// 设置 LED 状态，请求体为 {"status": "ON"} 或 {"status": "OFF"}
+func setLedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 	// 读取请求体
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		sendErrorResponse(w, http.StatusBadRequest, "无效的请求体")
-		fmt.Println("无效的请求体")
+		sendErrorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, "无效的请求体")
 		return
 	}
-	fmt.Println(string(body))
 	defer r.Body.Close()
 
 	// 解析状态
 	var statusJson Ledstatus
-	// status := strings.ToLower(strings.TrimSpace(string(body)))
 	json.Unmarshal(body, &statusJson)
 	if statusJson.STATUS != "ON" && statusJson.STATUS != "OFF" {
-		sendErrorResponse(w, http.StatusBadRequest, "无效的状态值，必须为on或off")
+		sendErrorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, "无效的状态值，必须为on或off")
 		return
 	}
 
 	// 转换状态并执行控制
 	if err := switchLed(statusJson.STATUS == "ON"); err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "状态更新失败")
+		sendErrorResponse(w, r, http.StatusInternalServerError, errCodeInternal, "状态更新失败")
+		log.Println(err)
+		return
+	}
+
+	// 保存用户的选择：updateLed 据此在网络正常时保持 LED 关闭，GET /ledstatus 也读取这里。
+	// 不保存的话关闭的 LED 会在下次状态变化时被重新点亮
+	if err := saveStoredLedStatus(statusJson.STATUS); err != nil {
+		sendErrorResponse(w, r, http.StatusInternalServerError, errCodeInternal, "状态保存失败")
 		log.Println(err)
 		return
 	}
 
 	// 返回更新后的状态
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status": "%s"}`, strings.ToUpper(statusJson.STATUS))
+	respondData(w, r, http.StatusOK, statusJson)
 }
 
-func sendErrorResponse(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	fmt.Fprintf(w, `{"error": {"code": %d, "message": "%s"}}`, code, message)
+// /ledstatus 的错误响应。旧路径保持原来的 {"error": {"code": 状态码, "message": 信息}} 格式
+func sendErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if isAPIRequest(r) {
+		respondError(w, r, status, code, message)
+		return
+	}
+	respondJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "message": message},
+	})
 }
 
 type BootControl struct {
@@ -84,24 +95,23 @@ type BootControl struct {
 	Reserved       [13]byte // 对齐到 16 字节
 }
 
+// 启动标记保存在 data_dir/bootfile。早期版本写入 /mnt/ata/bootfile 却从 /mnt/data/bootfile 读取，
+// 写入的标记从未被读到；现在读写同一个文件，默认 data_dir 下即原来读取的位置
 func WriteBootControl(bc *BootControl) error {
 	// 将结构体指针转换为字节数组指针
 	size := int(unsafe.Sizeof(*bc))
 	byteSlice := (*[16]byte)(unsafe.Pointer(bc))[:size:size]
 
 	// 原子写入（避免系统崩溃导致数据半写入）
-	return os.WriteFile("/mnt/ata/bootfile", byteSlice, 0644)
+	return writeFileAtomic(dataPath("bootfile"), byteSlice, 0644)
 }
 
 func systemStartUp() {
-	data, err := os.ReadFile("/mnt/data/bootfile")
-	if err != nil {
-		log.Println(err)
-	}
-
-	buf := bytes.NewReader(data)
 	var bc BootControl
-	err = binary.Read(buf, binary.LittleEndian, &bc) // 根据实际字节序选择
+	err := readFileRecover(dataPath("bootfile"), func(data []byte) error {
+		bc = BootControl{}
+		return binary.Read(bytes.NewReader(data), binary.LittleEndian, &bc) // 根据实际字节序选择
+	})
 	if err != nil {
 		log.Println(err)
 	}
@@ -1,9 +1,8 @@
 package main
 
 import (
+	"context"
 	"net/http"
-	"os"
-	"os/exec"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -11,7 +10,7 @@ import (
 )
 
 func getOSVersion() string {
-	data, err := os.ReadFile("/etc/os-release")
+	data, err := sysFS().ReadFile("/etc/os-release")
 	if err != nil {
 		return "unknown"
 	}
@@ -39,8 +38,7 @@ type LinuxSystemInfo struct {
 }
 
 func getLinuxSystemInfo() LinuxSystemInfo {
-	cmd := exec.Command("uname", "-a")
-	out, err := cmd.CombinedOutput()
+	out, err := getRunner().CombinedOutput(context.Background(), "uname", "-a")
 	if err != nil {
 		return LinuxSystemInfo{"unknown", "unknown", "unknown"}
 	}
@@ -75,7 +73,7 @@ func getLinuxSystemInfo() LinuxSystemInfo {
 
 func getCPUModel() string {
 	// 尝试从/proc/cpuinfo获取
-	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
+	if data, err := sysFS().ReadFile("/proc/cpuinfo"); err == nil {
 		lines := strings.Split(string(data), "\n")
 		for _, line := range lines {
 			if strings.Contains(line, "model name") {
@@ -88,49 +86,52 @@ func getCPUModel() string {
 	}
 
 	// 尝试从lscpu获取
-	if cmd := exec.Command("lscpu"); cmd != nil {
-		out, err := cmd.CombinedOutput()
-		if err == nil {
-			lines := strings.Split(string(out), "\n")
-			models := make(map[string]bool)
-			for _, line := range lines {
-				if strings.Contains(line, "Model name:") {
-					parts := strings.Split(line, ":")
-					if len(parts) > 1 {
-						models[strings.TrimSpace(parts[1])] = true
-					}
+	if out, err := getRunner().CombinedOutput(context.Background(), "lscpu"); err == nil {
+		lines := strings.Split(string(out), "\n")
+		models := make(map[string]bool)
+		for _, line := range lines {
+			if strings.Contains(line, "Model name:") {
+				parts := strings.Split(line, ":")
+				if len(parts) > 1 {
+					models[strings.TrimSpace(parts[1])] = true
 				}
 			}
-			if len(models) > 0 {
-				var uniqueModels []string
-				for model := range models {
-					uniqueModels = append(uniqueModels, model)
-				}
-				return strings.Join(uniqueModels, " + ")
+		}
+		if len(models) > 0 {
+			var uniqueModels []string
+			for model := range models {
+				uniqueModels = append(uniqueModels, model)
 			}
+			return strings.Join(uniqueModels, " + ")
 		}
 	}
 
 	// 回退到设备树检查
-	if _, err := os.Stat("/sys/firmware/devicetree/base/compatible"); err == nil {
-		if data, err := os.ReadFile("/sys/firmware/devicetree/base/compatible"); err == nil {
-			return string(data)
-		}
+	if data, err := sysFS().ReadFile("/sys/firmware/devicetree/base/compatible"); err == nil {
+		return string(data)
 	}
 
 	return "unknown"
 }
 
+// 版本信息
+type VersionInfo struct {
+	Version      string `json:"version"`
+	LinuxVersion string `json:"linux_version"`
+	BuildTime    string `json:"build_time"`
+	Arch         string `json:"arch"`
+	CPUInfo      string `json:"cpu_info"`
+}
+
 func versionHandler(w http.ResponseWriter, r *http.Request) {
 	sysInfo := getLinuxSystemInfo()
 	cpuInfo := getCPUModel() + " (" + strconv.Itoa(runtime.NumCPU()) + " Cores)"
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{
-		"version": "` + getOSVersion() + `",
-		"linux_version": "` + sysInfo.Version + `",
-		"build_time": "` + sysInfo.BuildTime + `",
-		"arch": "` + sysInfo.Arch + `",
-		"cpu_info": "` + cpuInfo + `"
-	}`))
+	respondData(w, r, http.StatusOK, VersionInfo{
+		Version:      getOSVersion(),
+		LinuxVersion: sysInfo.Version,
+		BuildTime:    sysInfo.BuildTime,
+		Arch:         sysInfo.Arch,
+		CPUInfo:      cpuInfo,
+	})
 }
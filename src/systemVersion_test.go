@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestGetLinuxSystemInfo(t *testing.T) {
+	tests := []struct {
+		name  string
+		uname string
+		err   error
+		want  LinuxSystemInfo
+	}{
+		{
+			name:  "两位数日期",
+			uname: "Linux hass 6.12.0-haos #26 SMP Thu Jun 26 22:04:55 CST 2025 aarch64 GNU/Linux\n",
+			want:  LinuxSystemInfo{Version: "6.12.0-haos", BuildTime: "Thu Jun 26 22:04:55 CST 2025", Arch: "aarch64"},
+		},
+		{
+			name:  "PREEMPT 和一位数日期",
+			uname: "Linux hass 6.12.0-haos #2 SMP PREEMPT Thu Jul  3 14:13:02 UTC 2025 aarch64 GNU/Linux\n",
+			want:  LinuxSystemInfo{Version: "6.12.0-haos", BuildTime: "Thu Jul  3 14:13:02 UTC 2025", Arch: "aarch64"},
+		},
+		{
+			name:  "x86_64",
+			uname: "Linux dev 6.8.0-45-generic #45-Ubuntu SMP PREEMPT_DYNAMIC Fri Aug 30 12:02:04 UTC 2024 x86_64 x86_64 x86_64 GNU/Linux\n",
+			want:  LinuxSystemInfo{Version: "6.8.0-45-generic", BuildTime: "Fri Aug 30 12:02:04 UTC 2024", Arch: "x86_64"},
+		},
+		{
+			name:  "字段不足",
+			uname: "Linux hass 6.12.0\n",
+			want:  LinuxSystemInfo{},
+		},
+		{
+			name: "命令失败",
+			err:  errExitStatus1,
+			want: LinuxSystemInfo{"unknown", "unknown", "unknown"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeRunner(t).on("uname -a", tt.uname, tt.err)
+			if got := getLinuxSystemInfo(); got != tt.want {
+				t.Errorf("getLinuxSystemInfo() = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
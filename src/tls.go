@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自签名证书有效期
+const selfSignedValidity = time.Hour * 24 * 365 * 10
+
+// 上传证书的大小限制
+const maxCertUpload = 1 << 20
+
+var (
+	tlsCertLock sync.RWMutex
+	tlsCert     *tls.Certificate
+)
+
+// 证书和私钥作为一对保存在 tls 目录中。替换时先在 tls.new 中写入并校验新的一对，
+// 再把 tls 改名为 tls.old、tls.new 改名为 tls，任何时刻断电都不会留下不匹配的证书和私钥
+func tlsDir() string {
+	return dataPath("tls")
+}
+
+func tlsCertPath() string {
+	return filepath.Join(tlsDir(), "cert.pem")
+}
+
+func tlsKeyPath() string {
+	return filepath.Join(tlsDir(), "key.pem")
+}
+
+// 证书信息
+type CertInfo struct {
+	Subject    string    `json:"subject"`
+	Issuer     string    `json:"issuer"`
+	DNSNames   []string  `json:"dns_names"`
+	IPs        []string  `json:"ip_addresses"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+	SelfSigned bool      `json:"self_signed"`
+	SHA256     string    `json:"sha256"`
+}
+
+func certInfo(leaf *x509.Certificate) CertInfo {
+	sum := sha256.Sum256(leaf.Raw)
+	ips := make([]string, 0, len(leaf.IPAddresses))
+	for _, ip := range leaf.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return CertInfo{
+		Subject:    leaf.Subject.String(),
+		Issuer:     leaf.Issuer.String(),
+		DNSNames:   append([]string{}, leaf.DNSNames...),
+		IPs:        ips,
+		NotBefore:  leaf.NotBefore,
+		NotAfter:   leaf.NotAfter,
+		SelfSigned: bytes.Equal(leaf.RawIssuer, leaf.RawSubject),
+		SHA256:     hex.EncodeToString(sum[:]),
+	}
+}
+
+// 生成本设备的自签名证书，每台设备使用独立的随机密钥，证书包含主机名和当前所有 IP
+func generateSelfSignedCert() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "assismgr"
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"AssistMgr"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{hostname, hostname + ".local", "localhost"},
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				template.IPAddresses = append(template.IPAddresses, ipnet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// 校验证书和私钥是否匹配且未过期
+func parseCertificate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+	return &cert, nil
+}
+
+// 读取目录中的证书和私钥
+func readCertificateDir(dir string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(certPEM, keyPEM)
+}
+
+// 保存证书并立即生效，已建立的连接不受影响。原来的证书保留在 tls.old
+func installCertificate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	if _, err := parseCertificate(certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	staging := tlsDir() + ".new"
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return nil, err
+	}
+	if err := replaceFile(filepath.Join(staging, "key.pem"), keyPEM, 0600, false); err != nil {
+		return nil, err
+	}
+	if err := replaceFile(filepath.Join(staging, "cert.pem"), certPEM, 0644, false); err != nil {
+		return nil, err
+	}
+	// 从磁盘读回校验，确认写入的是完整且匹配的一对
+	cert, err := readCertificateDir(staging)
+	if err != nil {
+		return nil, fmt.Errorf("校验写入的证书失败: %w", err)
+	}
+
+	old := tlsDir() + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tlsDir(), old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.Rename(staging, tlsDir()); err != nil {
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(tlsDir())); err != nil {
+		return nil, err
+	}
+
+	setTLSCertificate(cert)
+	return cert, nil
+}
+
+func setTLSCertificate(cert *tls.Certificate) {
+	tlsCertLock.Lock()
+	tlsCert = cert
+	tlsCertLock.Unlock()
+}
+
+// 读取已保存的证书，没有证书时生成自签名证书。
+// 已有的证书无效（例如上传的证书已过期）时不会覆盖，而是改名为 tls.invalid-<时间> 保留，
+// 之后生成自签名证书，保证设备仍可通过 HTTPS 访问
+func loadTLSCertificate() error {
+	cert, err := readCertificateDir(tlsDir())
+	if err == nil {
+		setTLSCertificate(cert)
+		return nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		// 替换证书的两次改名之间断电时 tls 目录不存在，tls.new 中是已校验的新证书
+		for _, dir := range []string{tlsDir() + ".new", tlsDir() + ".old"} {
+			if cert, err := readCertificateDir(dir); err == nil && !dirExists(tlsDir()) {
+				if err := os.Rename(dir, tlsDir()); err != nil {
+					return err
+				}
+				log.Printf("TLS 证书已从 %s 恢复", dir)
+				setTLSCertificate(cert)
+				return nil
+			}
+		}
+	}
+	if dirExists(tlsDir()) {
+		backup := fmt.Sprintf("%s.invalid-%s", tlsDir(), time.Now().Format("20060102-150405"))
+		if renameErr := os.Rename(tlsDir(), backup); renameErr != nil {
+			return fmt.Errorf("TLS 证书无效（%v），且无法备份: %w", err, renameErr)
+		}
+		log.Printf("警告: TLS 证书无效: %v。原证书已移动到 %s，将使用新生成的自签名证书，请重新上传有效的证书", err, backup)
+	}
+
+	cert, err = regenerateCertificate()
+	if err != nil {
+		return err
+	}
+	log.Printf("已生成自签名证书，SHA256 指纹: %s", certInfo(cert.Leaf).SHA256)
+	return nil
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func regenerateCertificate() (*tls.Certificate, error) {
+	certPEM, keyPEM, err := generateSelfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	return installCertificate(certPEM, keyPEM)
+}
+
+func getTLSCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	tlsCertLock.RLock()
+	defer tlsCertLock.RUnlock()
+
+	if tlsCert == nil {
+		return nil, errors.New("no certificate installed")
+	}
+	return tlsCert, nil
+}
+
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getTLSCertificate,
+	}
+}
+
+// HTTP 请求重定向到 HTTPS 监听端口
+func redirectToHTTPS(tlsListen string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsListen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// 使用临时重定向，关闭 HTTPS 后浏览器不会继续跳转
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+// 当前证书信息
+func tlsCertHandler(w http.ResponseWriter, r *http.Request) {
+	tlsCertLock.RLock()
+	cert := tlsCert
+	tlsCertLock.RUnlock()
+
+	if cert == nil {
+		respondError(w, r, http.StatusNotFound, errCodeNotFound, "No certificate installed")
+		return
+	}
+	respondData(w, r, http.StatusOK, certInfo(cert.Leaf))
+}
+
+// 上传证书，multipart 表单字段 cert 和 key 均为 PEM 格式，证书可以包含中间证书链
+func tlsUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCertUpload)
+	if err := r.ParseMultipartForm(maxCertUpload); err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid form: "+err.Error())
+		return
+	}
+
+	var files [2][]byte
+	for i, name := range []string{"cert", "key"} {
+		f, _, err := r.FormFile(name)
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Missing "+name+" file")
+			return
+		}
+		files[i], err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	if _, err := parseCertificate(files[0], files[1]); err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid certificate: "+err.Error())
+		return
+	}
+	cert, err := installCertificate(files[0], files[1])
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to save certificate: "+err.Error())
+		return
+	}
+	respondData(w, r, http.StatusOK, certInfo(cert.Leaf))
+}
+
+// 重新生成自签名证书，替换上传的证书
+func tlsRegenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cert, err := regenerateCertificate()
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate certificate: "+err.Error())
+		return
+	}
+	respondData(w, r, http.StatusOK, certInfo(cert.Leaf))
+}
+
+func initTLS(cfg *Config) error {
+	if cfg.TLS.Enabled {
+		if err := loadTLSCertificate(); err != nil {
+			return fmt.Errorf("加载 TLS 证书失败: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 生成已过期的证书，模拟上传后过期的证书
+func expiredCertPEM(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "uploaded.example"},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(-24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func useTestTLS(t *testing.T) {
+	t.Helper()
+	useTestStore(t, nil)
+	t.Cleanup(func() { setTLSCertificate(nil) })
+}
+
+func TestInstallCertificate(t *testing.T) {
+	useTestTLS(t)
+	if err := loadTLSCertificate(); err != nil {
+		t.Fatalf("首次启动: %v", err)
+	}
+	first, _ := getTLSCertificate(nil)
+
+	certPEM, keyPEM, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed, err := installCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("installCertificate: %v", err)
+	}
+
+	// 新证书生效并可从磁盘读回，原来的一对保留在 tls.old，不留下临时目录
+	cert, err := readCertificateDir(tlsDir())
+	if err != nil || certInfo(cert.Leaf).SHA256 == certInfo(first.Leaf).SHA256 {
+		t.Errorf("tls 目录中的证书 = %v, %v", cert, err)
+	}
+	if old, err := readCertificateDir(tlsDir() + ".old"); err != nil || certInfo(old.Leaf).SHA256 != certInfo(first.Leaf).SHA256 {
+		t.Errorf("tls.old 中应为原证书: %v", err)
+	}
+	if dirExists(tlsDir() + ".new") {
+		t.Error("替换后不应保留 tls.new")
+	}
+
+	// 不匹配的证书和私钥不会写入
+	_, otherKey, _ := generateSelfSignedCert()
+	if _, err := installCertificate(certPEM, otherKey); err == nil {
+		t.Error("证书和私钥不匹配时应返回错误")
+	}
+	if cert, err := readCertificateDir(tlsDir()); err != nil || certInfo(cert.Leaf).SHA256 != certInfo(installed.Leaf).SHA256 {
+		t.Errorf("失败后 tls 目录应保持不变: %v", err)
+	}
+}
+
+func TestLoadTLSCertificateKeepsInvalid(t *testing.T) {
+	useTestTLS(t)
+	certPEM, keyPEM := expiredCertPEM(t)
+	if err := os.MkdirAll(tlsDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(tlsCertPath(), certPEM, 0644)
+	os.WriteFile(tlsKeyPath(), keyPEM, 0600)
+
+	if err := loadTLSCertificate(); err != nil {
+		t.Fatalf("loadTLSCertificate: %v", err)
+	}
+	if cert, _ := getTLSCertificate(nil); cert == nil || !certInfo(cert.Leaf).SelfSigned || cert.Leaf.Subject.CommonName == "uploaded.example" {
+		t.Errorf("应改用新生成的自签名证书")
+	}
+
+	// 过期的上传证书移到 tls.invalid-<时间>，内容不变
+	backups, _ := filepath.Glob(tlsDir() + ".invalid-*")
+	if len(backups) != 1 {
+		t.Fatalf("备份目录 %v", backups)
+	}
+	if got, _ := os.ReadFile(filepath.Join(backups[0], "cert.pem")); string(got) != string(certPEM) {
+		t.Error("备份的证书内容不一致")
+	}
+}
+
+func TestLoadTLSCertificateRecoversStaged(t *testing.T) {
+	useTestTLS(t)
+	certPEM, keyPEM, err := generateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟 tls 改名为 tls.old 之后、tls.new 改名之前断电
+	staged := tlsDir() + ".new"
+	os.MkdirAll(staged, 0700)
+	os.WriteFile(filepath.Join(staged, "cert.pem"), certPEM, 0644)
+	os.WriteFile(filepath.Join(staged, "key.pem"), keyPEM, 0600)
+
+	if err := loadTLSCertificate(); err != nil {
+		t.Fatalf("loadTLSCertificate: %v", err)
+	}
+	if got, _ := os.ReadFile(tlsCertPath()); string(got) != string(certPEM) {
+		t.Error("应使用 tls.new 中已校验的证书")
+	}
+}
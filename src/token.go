@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// 已注销的 Token，key 为 jti，value 为 Token 过期时间，过期后从列表中清除
+var (
+	revokedMutex  sync.Mutex
+	revokedTokens = map[string]int64{}
+)
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate token id: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// 启动时读取注销列表
+func loadRevokedTokens() {
+	revokedMutex.Lock()
+	defer revokedMutex.Unlock()
+
+	rows, err := stateDB.Query("SELECT id, expires_at FROM revoked_tokens WHERE expires_at >= ?", time.Now().Unix())
+	if err != nil {
+		log.Printf("读取Token注销列表失败: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	revokedTokens = map[string]int64{}
+	for rows.Next() {
+		var id string
+		var exp int64
+		if err := rows.Scan(&id, &exp); err != nil {
+			log.Printf("读取Token注销列表失败: %v", err)
+			return
+		}
+		revokedTokens[id] = exp
+	}
+}
+
+// 注销单个 Token，同时清除已过期的条目
+func revokeToken(claims *TokenClaims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	revokedMutex.Lock()
+	defer revokedMutex.Unlock()
+
+	now := time.Now().Unix()
+	err := storeTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", now); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO revoked_tokens (id, expires_at) VALUES (?, ?) ON CONFLICT(id) DO NOTHING",
+			claims.ID, claims.ExpiresAt.Unix())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for id, exp := range revokedTokens {
+		if exp < now {
+			delete(revokedTokens, id)
+		}
+	}
+	revokedTokens[claims.ID] = claims.ExpiresAt.Unix()
+	return nil
+}
+
+func isTokenRevoked(id string) bool {
+	revokedMutex.Lock()
+	defer revokedMutex.Unlock()
+
+	_, ok := revokedTokens[id]
+	return ok
+}
+
+// 使指定用户已签发的所有 Token 和会话失效
+func revokeUserTokens(username string) error {
+	err := updateUser(username, func(u *User) error {
+		u.TokenGeneration++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteUserSessions(username, "")
+}
+
+// 使所有用户已签发的 Token 和会话失效
+func revokeAllTokens() error {
+	err := modifyUsers(func(users []User) ([]User, error) {
+		for i := range users {
+			users[i].TokenGeneration++
+		}
+		return users, nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteUserSessions("", "")
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RFC 6238 TOTP 参数，与常见的验证器 App 默认值一致
+const (
+	totpIssuer        = "assismgr"
+	totpPeriod        = 30 // 秒
+	totpDigits        = 6
+	totpSkew          = 1  // 允许前后各偏差一个周期
+	recoveryCodeCount = 10 // 每次生成的恢复码数量
+)
+
+var (
+	errInvalidOTP     = errors.New("invalid two-factor code")
+	errWrongPassword  = errors.New("invalid password")
+	errTOTPEnabled    = errors.New("two-factor authentication already enabled")
+	errTOTPNotEnabled = errors.New("two-factor authentication not enabled")
+	errTOTPNotPending = errors.New("two-factor setup not started")
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+func (u *User) totpEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+// 生成 160 位随机共享密钥（base32 编码）
+func newTOTPSecret() string {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		panic("failed to generate totp secret: " + err.Error())
+	}
+	return totpEncoding.EncodeToString(secret)
+}
+
+// 计算指定时间步的验证码
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// 校验验证码，返回匹配的时间步。时间步不大于 lastStep 的验证码已使用过，拒绝重放
+func verifyTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauth:// URI，验证器 App 扫描二维码后导入
+func totpURI(username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + v.Encode()
+}
+
+// 去掉用户输入中的空格和连字符
+func normalizeOTP(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// 恢复码为 10 字节（80 位）随机数，Base32 编码后按 4 位分组，例如 abcd-efgh-ijkl-mnop
+const recoveryCodeBytes = 10
+
+// 恢复码和密码一样使用 bcrypt 保存，拿到用户数据库也无法离线穷举
+func hashRecoveryCode(code string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(normalizeOTP(code)), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// 校验恢复码。早期版本的恢复码为 40 位、以 SHA-256 保存，仍可使用，
+// 但建议重新生成
+func matchRecoveryCode(hash, code string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return len(code) == totpEncoding.EncodedLen(recoveryCodeBytes) &&
+			bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil
+	}
+	sum := sha256.Sum256([]byte(code))
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hex.EncodeToString(sum[:]))) == 1
+}
+
+// 生成一组恢复码，返回明文（只展示一次）和保存到用户文件中的哈希
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(raw))
+		var groups []string
+		for i := 0; i < len(s); i += 4 {
+			groups = append(groups, s[i:min(i+4, len(s))])
+		}
+		code := strings.Join(groups, "-")
+		hash, err := hashRecoveryCode(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// 校验第二因素，可以是验证码或恢复码。调用方需在 updateUser 中调用以保存状态：
+// 验证码记录时间步防止重放，恢复码使用后作废
+func (u *User) checkSecondFactor(code string) error {
+	if !u.totpEnabled() {
+		return errTOTPNotEnabled
+	}
+	code = normalizeOTP(code)
+	if step, ok := verifyTOTP(u.TOTPSecret, code, u.TOTPLastStep, time.Now()); ok {
+		u.TOTPLastStep = step
+		return nil
+	}
+	// 6 位数字的验证码不会是恢复码，避免每次输错验证码都计算 bcrypt
+	if len(code) == totpDigits {
+		return errInvalidOTP
+	}
+	for i, h := range u.RecoveryCodes {
+		if matchRecoveryCode(h, code) {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return errInvalidOTP
+}
+
+func verifySecondFactor(username, code string) error {
+	return updateUser(username, func(u *User) error {
+		return u.checkSecondFactor(code)
+	})
+}
+
+func respondTOTPError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidOTP):
+		respondError(w, r, http.StatusUnauthorized, errCodeInvalidOTP, err.Error())
+	case errors.Is(err, errWrongPassword):
+		respondError(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, err.Error())
+	case errors.Is(err, errTOTPEnabled):
+		respondError(w, r, http.StatusConflict, errCodeConflict, err.Error())
+	case errors.Is(err, errTOTPNotEnabled), errors.Is(err, errTOTPNotPending):
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+	default:
+		respondUserError(w, r, err)
+	}
+}
+
+// 两步验证操作的请求，Code 为验证码或恢复码，关闭时还需要 Password
+type TOTPRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password,omitempty"`
+}
+
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCode     string `json:"qr_code"` // PNG 格式的 data URI
+}
+
+type RecoveryCodesResponse struct {
+	Message       string   `json:"message,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func decodeTOTPRequest(w http.ResponseWriter, r *http.Request) (*TOTPRequest, bool) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return nil, false
+	}
+	var req TOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return nil, false
+	}
+	return &req, true
+}
+
+// 两步验证状态
+func totpStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := findUser(currentUsername(r))
+	if err != nil {
+		respondUserError(w, r, err)
+		return
+	}
+	respondData(w, r, http.StatusOK, TOTPStatus{
+		Enabled:           user.totpEnabled(),
+		RecoveryCodesLeft: len(user.RecoveryCodes),
+	})
+}
+
+// 开始绑定：生成新密钥并返回 otpauth URI 和二维码，输入验证码确认后才生效
+func totpSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	username := currentUsername(r)
+	secret := newTOTPSecret()
+	err := updateUser(username, func(u *User) error {
+		if u.totpEnabled() {
+			return errTOTPEnabled
+		}
+		u.TOTPPending = secret
+		return nil
+	})
+	if err != nil {
+		respondTOTPError(w, r, err)
+		return
+	}
+
+	uri := totpURI(username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate QR code")
+		return
+	}
+	respondData(w, r, http.StatusOK, TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// 确认绑定，返回恢复码
+func totpEnableHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeTOTPRequest(w, r)
+	if !ok {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate recovery codes")
+		return
+	}
+	err = updateUser(currentUsername(r), func(u *User) error {
+		if u.totpEnabled() {
+			return errTOTPEnabled
+		}
+		if u.TOTPPending == "" {
+			return errTOTPNotPending
+		}
+		step, ok := verifyTOTP(u.TOTPPending, normalizeOTP(req.Code), 0, time.Now())
+		if !ok {
+			return errInvalidOTP
+		}
+		u.TOTPSecret = u.TOTPPending
+		u.TOTPPending = ""
+		u.TOTPLastStep = step
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		respondTOTPError(w, r, err)
+		return
+	}
+	respondData(w, r, http.StatusOK, RecoveryCodesResponse{
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	})
+}
+
+// 关闭两步验证，需要同时验证密码和验证码（或恢复码）
+func totpDisableHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeTOTPRequest(w, r)
+	if !ok {
+		return
+	}
+	err := updateUser(currentUsername(r), func(u *User) error {
+		if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)); err != nil {
+			return errWrongPassword
+		}
+		if err := u.checkSecondFactor(req.Code); err != nil {
+			return err
+		}
+		u.TOTPSecret = ""
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+		return nil
+	})
+	if err != nil {
+		respondTOTPError(w, r, err)
+		return
+	}
+	respondData(w, r, http.StatusOK, MessageResponse{Message: "Two-factor authentication disabled"})
+}
+
+// 重新生成恢复码，旧的恢复码全部作废
+func totpRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeTOTPRequest(w, r)
+	if !ok {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate recovery codes")
+		return
+	}
+	err = updateUser(currentUsername(r), func(u *User) error {
+		if err := u.checkSecondFactor(req.Code); err != nil {
+			return err
+		}
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		respondTOTPError(w, r, err)
+		return
+	}
+	respondData(w, r, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
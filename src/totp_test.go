@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil || len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("newRecoveryCodes() = %d, %d, %v", len(codes), len(hashes), err)
+	}
+	if len(codes[0]) != 19 || strings.Count(codes[0], "-") != 3 {
+		t.Errorf("恢复码格式 %q", codes[0])
+	}
+	if !strings.HasPrefix(hashes[0], "$2") {
+		t.Errorf("恢复码应使用 bcrypt 保存: %q", hashes[0])
+	}
+
+	legacy := sha256.Sum256([]byte("abcdefgh"))
+	u := &User{Username: "admin", TOTPSecret: "JBSWY3DPEHPK3PXP", RecoveryCodes: append(hashes[:2:2], hex.EncodeToString(legacy[:]))}
+
+	// 输入不区分大小写、可以不带连字符，每个恢复码只能使用一次
+	code := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if err := u.checkSecondFactor(code); err != nil {
+		t.Fatalf("恢复码校验失败: %v", err)
+	}
+	if err := u.checkSecondFactor(code); err != errInvalidOTP {
+		t.Errorf("重复使用恢复码返回 %v", err)
+	}
+	if len(u.RecoveryCodes) != 2 {
+		t.Errorf("剩余 %d 个恢复码，期望 2", len(u.RecoveryCodes))
+	}
+
+	// 早期版本以 SHA-256 保存的恢复码仍可使用
+	if err := u.checkSecondFactor("abcd-efgh"); err != nil {
+		t.Errorf("旧恢复码校验失败: %v", err)
+	}
+	if err := u.checkSecondFactor("000000"); err != errInvalidOTP {
+		t.Errorf("错误的验证码返回 %v", err)
+	}
+}
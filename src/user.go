@@ -1,24 +1,87 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"reflect"
+	"slices"
 	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// 首次启动时创建的默认密码，使用默认密码登录后必须先修改密码
+const defaultPassword = "123456"
+
 var (
 	userMutex sync.Mutex
+
+	errUserNotFound = errors.New("user not found")
+	errUserExists   = errors.New("user already exists")
+	errLastAdmin    = errors.New("at least one admin is required")
+)
+
+// 用户角色，权限依次递增
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // 只读
+	RoleOperator Role = "operator" // 日常运维：服务启停、网络、LED
+	RoleAdmin    Role = "admin"    // 重启、恢复出厂、升级、用户管理
 )
 
+var roleLevel = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+func (r Role) valid() bool {
+	_, ok := roleLevel[r]
+	return ok
+}
+
+// 是否拥有 required 角色的权限
+func (r Role) allows(required Role) bool {
+	return roleLevel[r] >= roleLevel[required]
+}
+
 type User struct {
 	Username     string `json:"username"`
 	PasswordHash string `json:"password_hash"`
 	Email        string `json:"email,omitempty"`
+	Role         Role   `json:"role"`
+	// 每次修改密码或强制下线时递增，使已签发的 Token 失效
+	TokenGeneration int `json:"token_generation"`
+	// 仍在使用默认密码，修改前只能访问标记了 AllowPasswordChange 的路由
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+
+	// 两步验证（TOTP），TOTPSecret 非空表示已启用；TOTPPending 为待确认的新密钥
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	TOTPPending  string `json:"totp_pending,omitempty"`
+	TOTPLastStep int64  `json:"totp_last_step,omitempty"` // 最近一次使用的时间步，防止验证码重放
+	// 恢复码的 bcrypt 哈希（早期版本为 SHA-256），使用后删除
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// 创建时间（Unix 秒），早于该时间签发的 Token 属于同名的已删除用户，视为无效
+	CreatedAt int64 `json:"created_at,omitempty"`
+}
+
+// 返回给客户端的用户信息，不包含密码哈希
+type UserInfo struct {
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Role     Role   `json:"role"`
+
+	TOTPEnabled bool `json:"totp_enabled"`
+}
+
+func (u *User) info() UserInfo {
+	return UserInfo{Username: u.Username, Email: u.Email, Role: u.Role, TOTPEnabled: u.totpEnabled()}
 }
 
 type PasswordChangeRequest struct {
@@ -26,152 +89,543 @@ type PasswordChangeRequest struct {
 	NewPassword string `json:"newPassword"`
 }
 
-func initUser() {
-	// 初始化用户文件
-	if err := initUserFile(); err != nil {
-		panic("Failed to initialize user file: " + err.Error())
-	}
+type PasswordChangeResponse struct {
+	Message string `json:"message"`
+	Token   string `json:"token"` // 当前会话的新 Token
+}
 
-	// 认证路由
+type UserList struct {
+	Users []UserInfo `json:"users"`
+}
 
-	handleAuthRoute("/change-password", changePasswordHandler)
-	handleAuthRoute("/logout", logoutHandler)
+// 强制下线请求，Username 为空表示所有用户
+type RevokeSessionsRequest struct {
+	Username string `json:"username"`
+}
 
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Role     Role   `json:"role,omitempty"`
+
+	// 关闭该用户的两步验证，用于丢失验证器和恢复码的情况
+	ResetTOTP bool `json:"reset_totp,omitempty"`
+}
+
+func initUser() {
+	// 初始化用户，数据库中没有用户时创建默认管理员
+	if err := initUserFile(); err != nil {
+		panic("Failed to initialize users: " + err.Error())
+	}
 }
 
 func initUserFile() error {
 	userMutex.Lock()
 	defer userMutex.Unlock()
 
-	if _, err := os.Stat("/mnt/data/user.json"); os.IsNotExist(err) {
-		defaultPass := "123456"
-		hash, _ := bcrypt.GenerateFromPassword([]byte(defaultPass), bcrypt.DefaultCost)
+	users, err := readUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		hash, _ := bcrypt.GenerateFromPassword([]byte(defaultPassword), bcrypt.DefaultCost)
+
+		return writeUsers(nil, []User{{
+			Username:           "admin",
+			PasswordHash:       string(hash),
+			Role:               RoleAdmin,
+			MustChangePassword: true,
+		}})
+	}
+	// 旧版本升级上来仍在使用默认密码的用户同样需要修改密码
+	old := cloneUsers(users)
+	for i := range users {
+		u := &users[i]
+		if !u.MustChangePassword && bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(defaultPassword)) == nil {
+			log.Printf("用户 %s 仍在使用默认密码，登录后必须修改密码", u.Username)
+			u.MustChangePassword = true
+		}
+	}
+	return writeUsers(old, users)
+}
 
-		user := User{
-			Username:     "admin",
-			PasswordHash: string(hash),
+// readUsers 从数据库读取所有用户，按创建顺序排列，调用方需持有 userMutex
+func readUsers() ([]User, error) {
+	rows, err := stateDB.Query(`SELECT username, password_hash, email, role, token_generation, must_change_password,
+		totp_secret, totp_pending, totp_last_step, recovery_codes, created_at FROM users ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		var codes string
+		if err := rows.Scan(&u.Username, &u.PasswordHash, &u.Email, &u.Role, &u.TokenGeneration, &u.MustChangePassword,
+			&u.TOTPSecret, &u.TOTPPending, &u.TOTPLastStep, &codes, &u.CreatedAt); err != nil {
+			return nil, err
 		}
+		if err := json.Unmarshal([]byte(codes), &u.RecoveryCodes); err != nil {
+			return nil, fmt.Errorf("用户 %s 的恢复码无效: %w", u.Username, err)
+		}
+		if len(u.RecoveryCodes) == 0 {
+			u.RecoveryCodes = nil
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
 
-		data, _ := json.MarshalIndent(user, "", "  ")
-		return os.WriteFile("/mnt/data/user.json", data, 0600)
+// writeUsers 比较修改前读到的 old 和修改后的 users，只写入新增或变化的用户并删除已移除的用户，
+// 调用方需持有 userMutex
+func writeUsers(old, users []User) error {
+	before := make(map[string]User, len(old))
+	for _, u := range old {
+		before[u.Username] = u
+	}
+	return storeTx(func(tx *sql.Tx) error {
+		for _, u := range users {
+			prev, ok := before[u.Username]
+			delete(before, u.Username)
+			if ok && reflect.DeepEqual(prev, u) {
+				continue
+			}
+			if err := upsertUser(tx, u); err != nil {
+				return err
+			}
+		}
+		for username := range before {
+			if _, err := tx.Exec("DELETE FROM users WHERE username = ?", username); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 已存在的用户原地更新，保持 rowid 即创建顺序不变
+func upsertUser(tx *sql.Tx, u User) error {
+	codes, err := recoveryCodesJSON(u)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO users (username, password_hash, email, role, token_generation, must_change_password,
+		totp_secret, totp_pending, totp_last_step, recovery_codes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET password_hash = excluded.password_hash, email = excluded.email,
+		role = excluded.role, token_generation = excluded.token_generation, must_change_password = excluded.must_change_password,
+		totp_secret = excluded.totp_secret, totp_pending = excluded.totp_pending, totp_last_step = excluded.totp_last_step,
+		recovery_codes = excluded.recovery_codes`,
+		u.Username, u.PasswordHash, u.Email, u.Role, u.TokenGeneration, u.MustChangePassword,
+		u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, codes, u.CreatedAt)
+	return err
+}
+
+func insertUsers(tx *sql.Tx, users []User) error {
+	for _, u := range users {
+		codes, err := recoveryCodesJSON(u)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO users (username, password_hash, email, role, token_generation, must_change_password,
+			totp_secret, totp_pending, totp_last_step, recovery_codes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			u.Username, u.PasswordHash, u.Email, u.Role, u.TokenGeneration, u.MustChangePassword,
+			u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, codes, u.CreatedAt)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func loadUser() (*User, error) {
+func recoveryCodesJSON(u User) (string, error) {
+	if u.RecoveryCodes == nil {
+		return "[]", nil
+	}
+	codes, err := json.Marshal(u.RecoveryCodes)
+	return string(codes), err
+}
+
+// 修改前的副本，恢复码也复制一份，避免原地修改后和副本比较不出变化
+func cloneUsers(users []User) []User {
+	copied := slices.Clone(users)
+	for i := range copied {
+		copied[i].RecoveryCodes = slices.Clone(copied[i].RecoveryCodes)
+	}
+	return copied
+}
+
+func loadUsers() ([]User, error) {
 	userMutex.Lock()
 	defer userMutex.Unlock()
 
-	data, err := os.ReadFile("/mnt/data/user.json")
+	return readUsers()
+}
+
+func findUser(username string) (*User, error) {
+	users, err := loadUsers()
 	if err != nil {
 		return nil, err
 	}
+	for i := range users {
+		if users[i].Username == username {
+			return &users[i], nil
+		}
+	}
+	return nil, errUserNotFound
+}
 
-	var user User
-	if err := json.Unmarshal(data, &user); err != nil {
-		return nil, err
+func countAdmins(users []User) int {
+	n := 0
+	for _, u := range users {
+		if u.Role == RoleAdmin {
+			n++
+		}
 	}
-	return &user, nil
+	return n
 }
 
-func saveUser(user *User) error {
+// modifyUsers 在锁内读取、修改并写回用户列表
+func modifyUsers(fn func(users []User) ([]User, error)) error {
 	userMutex.Lock()
 	defer userMutex.Unlock()
 
-	data, err := json.MarshalIndent(user, "", "  ")
+	users, err := readUsers()
 	if err != nil {
 		return err
 	}
-	return os.WriteFile("/mnt/data/user.json", data, 0600)
+	old := cloneUsers(users)
+	users, err = fn(users)
+	if err != nil {
+		return err
+	}
+	if countAdmins(users) == 0 {
+		return errLastAdmin
+	}
+	return writeUsers(old, users)
+}
+
+// updateUser 修改指定用户并保存
+func updateUser(username string, fn func(u *User) error) error {
+	return modifyUsers(func(users []User) ([]User, error) {
+		for i := range users {
+			if users[i].Username == username {
+				return users, fn(&users[i])
+			}
+		}
+		return nil, errUserNotFound
+	})
+}
+
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// 从请求上下文中获取当前用户名
+func currentUsername(r *http.Request) string {
+	username, _ := r.Context().Value("username").(string)
+	return username
+}
+
+func currentRole(r *http.Request) Role {
+	role, _ := r.Context().Value("role").(Role)
+	return role
+}
+
+// 检查当前用户是否拥有指定角色，没有权限时返回 403
+func requireRole(w http.ResponseWriter, r *http.Request, role Role) bool {
+	if currentRole(r).allows(role) {
+		return true
+	}
+	respondError(w, r, http.StatusForbidden, errCodeForbidden, "Permission denied")
+	return false
 }
 
-func loginHandler_test(w http.ResponseWriter, r *http.Request) {
-	var creds struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+// 用户操作失败时按错误类型返回对应的状态码和错误码
+func respondUserError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		respondError(w, r, http.StatusNotFound, errCodeNotFound, err.Error())
+	case errors.Is(err, errUserExists):
+		respondError(w, r, http.StatusConflict, errCodeConflict, err.Error())
+	case errors.Is(err, errLastAdmin):
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
+	default:
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, err.Error())
 	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
 		return
 	}
 
-	user, err := loadUser()
+	user, err := findUser(currentUsername(r))
 	if err != nil {
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "User not found")
 		return
 	}
 
-	if user.Username != creds.Username {
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+	// 验证旧密码
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.OldPassword)); err != nil {
+		respondError(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, "Invalid old password")
+		return
+	}
+	if req.NewPassword == defaultPassword || req.NewPassword == req.OldPassword {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "New password must differ from the old and default password")
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+	// 生成新哈希
+	newHash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate password")
 		return
 	}
 
-	// 生成JWT
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   user.Username,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+	// 更新用户信息，同时使该用户已签发的所有 Token 失效
+	var updated User
+	err = updateUser(user.Username, func(u *User) error {
+		u.PasswordHash = newHash
+		u.TokenGeneration++
+		u.MustChangePassword = false
+		updated = *u
+		return nil
 	})
+	if err != nil {
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to save user")
+		return
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// 为当前会话签发新 Token，其他会话全部注销
+	sessionID := currentSessionID(r)
+	if err := deleteUserSessions(updated.Username, sessionID); err != nil {
+		log.Printf("注销用户 %s 的其他会话失败: %v", updated.Username, err)
+	}
+	token, err := generateToken(&updated, sessionID)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate token")
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": tokenString,
+	respondData(w, r, http.StatusOK, PasswordChangeResponse{
+		Message: "Password updated successfully",
+		Token:   token,
 	})
 }
 
-func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var req PasswordChangeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// 注销当前 Token 和会话，客户端同时应删除本地存储的token
+	if claims := currentClaims(r); claims != nil {
+		if err := revokeToken(claims); err != nil {
+			respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to revoke token")
+			return
+		}
+		if err := deleteSession(claims.SessionID); err != nil {
+			respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to revoke session")
+			return
+		}
 	}
 
-	user, err := loadUser()
+	respondData(w, r, http.StatusOK, MessageResponse{Message: "Logged out successfully"})
+}
+
+// 用户列表
+func listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := loadUsers()
 	if err != nil {
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to load users")
 		return
 	}
 
-	// 验证旧密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.OldPassword)); err != nil {
-		http.Error(w, "Invalid old password", http.StatusUnauthorized)
+	infos := make([]UserInfo, 0, len(users))
+	for i := range users {
+		infos = append(infos, users[i].info())
+	}
+	respondData(w, r, http.StatusOK, UserList{Users: infos})
+}
+
+func decodeUserRequest(w http.ResponseWriter, r *http.Request) (*UserRequest, bool) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return nil, false
+	}
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return nil, false
+	}
+	if req.Role != "" && !req.Role.valid() {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("Invalid role %q", req.Role))
+		return nil, false
+	}
+	// 默认密码人人皆知，管理员不能把它设为任何用户的密码
+	if req.Password == defaultPassword {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Password must differ from the default password")
+		return nil, false
+	}
+	return &req, true
+}
+
+// 新建用户
+func addUserHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUserRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleViewer
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
 
-	// 生成新哈希
-	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	user := User{
+		Username:     req.Username,
+		PasswordHash: hash,
+		Email:        req.Email,
+		Role:         req.Role,
+		CreatedAt:    time.Now().Unix(),
+	}
+	err = modifyUsers(func(users []User) ([]User, error) {
+		for _, u := range users {
+			if u.Username == user.Username {
+				return nil, errUserExists
+			}
+		}
+		return append(users, user), nil
+	})
 	if err != nil {
-		http.Error(w, "Failed to generate password", http.StatusInternalServerError)
+		respondUserError(w, r, err)
 		return
 	}
+	respondData(w, r, http.StatusOK, user.info())
+}
 
-	// 更新用户信息
-	user.PasswordHash = string(newHash)
-	if err := saveUser(user); err != nil {
-		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+// 修改用户的角色、邮箱，重置密码或两步验证
+func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUserRequest(w, r)
+	if !ok {
 		return
 	}
+	var hash string
+	if req.Password != "" {
+		var err error
+		if hash, err = hashPassword(req.Password); err != nil {
+			respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to generate password")
+			return
+		}
+	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Password updated successfully",
+	// 重置密码或修改角色后，该用户已签发的 Token 和会话全部失效，与修改密码一致
+	var updated User
+	var revoked bool
+	err := updateUser(req.Username, func(u *User) error {
+		revoked = hash != "" || (req.Role != "" && req.Role != u.Role)
+		if revoked {
+			u.TokenGeneration++
+		}
+		if req.Role != "" {
+			u.Role = req.Role
+		}
+		if req.Email != "" {
+			u.Email = req.Email
+		}
+		if hash != "" {
+			u.PasswordHash = hash
+		}
+		if req.ResetTOTP {
+			u.TOTPSecret = ""
+			u.TOTPPending = ""
+			u.TOTPLastStep = 0
+			u.RecoveryCodes = nil
+		}
+		updated = *u
+		return nil
 	})
+	if err != nil {
+		respondUserError(w, r, err)
+		return
+	}
+	if revoked {
+		if err := deleteUserSessions(req.Username, ""); err != nil {
+			log.Printf("注销用户 %s 的会话失败: %v", req.Username, err)
+		}
+	}
+	respondData(w, r, http.StatusOK, updated.info())
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	// 客户端应删除本地存储的token
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Logged out successfully",
+// 删除用户，不能删除自己
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUserRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Username == currentUsername(r) {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Cannot delete current user")
+		return
+	}
+
+	err := modifyUsers(func(users []User) ([]User, error) {
+		for i := range users {
+			if users[i].Username == req.Username {
+				return append(users[:i], users[i+1:]...), nil
+			}
+		}
+		return nil, errUserNotFound
 	})
+	if err != nil {
+		respondUserError(w, r, err)
+		return
+	}
+
+	// 会话和 API Key 不随用户删除，需要单独清除，否则同名用户重新创建后它们会再次生效
+	if err := deleteUserSessions(req.Username, ""); err != nil {
+		log.Printf("删除用户 %s 的会话失败: %v", req.Username, err)
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to delete sessions")
+		return
+	}
+	if err := deleteUserAPIKeys(req.Username); err != nil {
+		log.Printf("删除用户 %s 的 API Key 失败: %v", req.Username, err)
+		respondError(w, r, http.StatusInternalServerError, errCodeInternal, "Failed to delete API keys")
+		return
+	}
+	respondData(w, r, http.StatusOK, MessageResponse{Message: "User deleted"})
+}
+
+// 强制下线：username 为空时使所有用户的 Token 失效
+func revokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req RevokeSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, errCodeBadRequest, "Invalid request")
+		return
+	}
+
+	var err error
+	if req.Username == "" {
+		err = revokeAllTokens()
+	} else {
+		err = revokeUserTokens(req.Username)
+	}
+	if err != nil {
+		respondUserError(w, r, err)
+		return
+	}
+	respondData(w, r, http.StatusOK, MessageResponse{Message: "Sessions revoked"})
 }
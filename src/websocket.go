@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket 接口 /ws。使用子协议 assismgr.v1 连接的客户端通过 subscribe / unsubscribe 订阅主题，
+// 状态变化时收到事件；未指定子协议的旧客户端仍在每次采样后直接收到 SystemInfo。
+// 服务端定时发送 ping，超过 wsPongWait 没有收到任何消息的连接会被断开
+
+const wsProtocol = "assismgr.v1"
+
+// 可订阅的主题
+const (
+	topicSystem   = "system"   // SystemInfo，每次采样后推送
+	topicNetwork  = "network"  // NetworkEvent，每次采样后推送
+	topicUpgrade  = "upgrade"  // UpgradeProgress，升级状态或 RAUC 输出变化时推送
+	topicServices = "services" // Service，服务操作后推送该服务的状态
+	topicLeds     = "leds"     // LedEvent，LED 设置变化时推送
+	topicLogs     = "logs"     // LogLine，守护进程每输出一行日志推送一次
+)
+
+// 订阅主题时立即发送的当前状态，为 nil 的主题没有初始状态
+var wsTopics = map[string]func(ctx context.Context) []wsEvent{
+	topicSystem:   systemSnapshot,
+	topicNetwork:  networkSnapshot,
+	topicUpgrade:  upgradeSnapshot,
+	topicServices: servicesSnapshot,
+	topicLeds:     ledsSnapshot,
+	topicLogs:     nil,
+}
+
+// 需要 viewer 以上角色才能订阅的主题。本程序的日志可能包含设备配置等信息，不对 viewer 开放
+var wsTopicRoles = map[string]Role{
+	topicLogs: RoleOperator,
+}
+
+var (
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsWriteWait    = 10 * time.Second
+)
+
+const (
+	wsMaxMessageSize = 4096 // 客户端消息的最大长度
+	wsMaxQueue       = 256  // 每个连接最多缓存的待发送消息数
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{wsProtocol},
+}
+
+// 客户端发送的消息，type 为 subscribe、unsubscribe 或 ping
+type wsRequest struct {
+	Type   string   `json:"type"`
+	Topics []string `json:"topics"`
+}
+
+// 服务端发送的消息，type 为 event、subscribed（data 为当前订阅的主题）、
+// dropped（count 条消息因客户端读取太慢被丢弃）、pong 或 error
+type wsMessage struct {
+	Type  string      `json:"type"`
+	Topic string      `json:"topic,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+	Count int         `json:"count,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// 待发送的消息。key 相同的状态事件在队列中只保留最新的一条，key 为空的消息不合并
+type wsEvent struct {
+	key string
+	msg wsMessage
+}
+
+func newTopicEvent(topic, key string, data interface{}) wsEvent {
+	return wsEvent{key: key, msg: wsMessage{Type: "event", Topic: topic, Data: data}}
+}
+
+// 一个 WebSocket 连接的订阅和发送队列
+type wsClient struct {
+	legacy bool // 旧客户端，只订阅 system 且直接发送 data
+	role   Role // 连接时用户的角色，决定可以订阅的主题
+
+	mu      sync.Mutex
+	topics  map[string]bool
+	queue   []wsEvent
+	dropped map[string]int // 各主题被丢弃的消息数
+	wake    chan struct{}
+}
+
+func newWSClient(legacy bool) *wsClient {
+	c := &wsClient{
+		legacy:  legacy,
+		topics:  make(map[string]bool),
+		dropped: make(map[string]int),
+		wake:    make(chan struct{}, 1),
+	}
+	if legacy {
+		c.topics[topicSystem] = true
+	}
+	return c
+}
+
+// 加入发送队列，不会阻塞。队列已满时丢弃并计数，连接恢复后通知客户端
+func (c *wsClient) enqueue(ev wsEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ev.msg.Type == "event" && !c.topics[ev.msg.Topic] {
+		return
+	}
+	if ev.key != "" {
+		for i := range c.queue {
+			if c.queue[i].key == ev.key {
+				c.queue[i] = ev
+				return
+			}
+		}
+	}
+	if len(c.queue) >= wsMaxQueue {
+		c.dropped[ev.msg.Topic]++
+		return
+	}
+	c.queue = append(c.queue, ev)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// 取出待发送的消息，丢弃计数排在最前面
+func (c *wsClient) take() []wsMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msgs := make([]wsMessage, 0, len(c.dropped)+len(c.queue))
+	topics := make([]string, 0, len(c.dropped))
+	for topic := range c.dropped {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		msgs = append(msgs, wsMessage{Type: "dropped", Topic: topic, Count: c.dropped[topic]})
+		delete(c.dropped, topic)
+	}
+	for _, ev := range c.queue {
+		msgs = append(msgs, ev.msg)
+	}
+	c.queue = nil
+	return msgs
+}
+
+// 修改订阅，返回新增的主题和修改后的全部主题
+func (c *wsClient) setTopics(topics []string, subscribe bool) (added, current []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, topic := range topics {
+		if subscribe && !c.topics[topic] {
+			added = append(added, topic)
+		}
+		if subscribe {
+			c.topics[topic] = true
+		} else {
+			delete(c.topics, topic)
+		}
+	}
+	current = []string{}
+	for topic := range c.topics {
+		current = append(current, topic)
+	}
+	sort.Strings(current)
+	return added, current
+}
+
+func (c *wsClient) subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics[topic]
+}
+
+// 所有连接
+var (
+	wsClientsLock sync.Mutex
+	wsClients     = make(map[*wsClient]struct{})
+)
+
+// 推送事件给订阅了该主题的连接
+func publishEvent(topic, key string, data interface{}) {
+	wsClientsLock.Lock()
+	defer wsClientsLock.Unlock()
+	for c := range wsClients {
+		c.enqueue(newTopicEvent(topic, key, data))
+	}
+}
+
+// 是否有连接订阅了该主题，生成事件需要执行命令或查询数据库时先检查
+func hasSubscribers(topic string) bool {
+	wsClientsLock.Lock()
+	defer wsClientsLock.Unlock()
+	for c := range wsClients {
+		if c.subscribed(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocket 接口。浏览器无法设置请求头，Token 通过查询参数传递，由 authMiddleware 校验
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+
+	c := newWSClient(conn.Subprotocol() != wsProtocol)
+	c.role = currentRole(r)
+	wsClientsLock.Lock()
+	wsClients[c] = struct{}{}
+	wsClientsLock.Unlock()
+	defer func() {
+		wsClientsLock.Lock()
+		delete(wsClients, c)
+		wsClientsLock.Unlock()
+	}()
+	if c.legacy {
+		for _, ev := range systemSnapshot(r.Context()) {
+			c.enqueue(ev)
+		}
+	}
+
+	// 写入只在 writeLoop 中进行，writeLoop 退出时关闭连接，readLoop 随之返回
+	ctx, cancel := context.WithCancel(r.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		c.writeLoop(ctx, conn)
+	}()
+	c.readLoop(ctx, conn)
+	cancel()
+	<-done
+}
+
+func (c *wsClient) writeLoop(ctx context.Context, conn *websocket.Conn) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 服务退出时发送关闭帧
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-c.wake:
+			for _, msg := range c.take() {
+				var v interface{} = msg
+				if c.legacy {
+					v = msg.Data
+				}
+				conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if err := conn.WriteJSON(v); err != nil {
+					log.Println("WebSocket write error:", err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// 处理客户端消息，连接关闭或超时后返回。收到任何消息（包括 pong）都会延长读取期限
+func (c *wsClient) readLoop(ctx context.Context, conn *websocket.Conn) {
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		if c.legacy {
+			continue
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.sendError("Invalid message")
+			continue
+		}
+		switch req.Type {
+		case "subscribe", "unsubscribe":
+			c.handleSubscribe(ctx, req)
+		case "ping":
+			c.enqueue(wsEvent{msg: wsMessage{Type: "pong"}})
+		default:
+			c.sendError("Unknown message type " + req.Type)
+		}
+	}
+}
+
+// 修改订阅并回复当前订阅的主题，新订阅的主题随后发送当前状态
+func (c *wsClient) handleSubscribe(ctx context.Context, req wsRequest) {
+	topics := make([]string, 0, len(req.Topics))
+	for _, topic := range req.Topics {
+		if _, ok := wsTopics[topic]; !ok {
+			c.sendError("Unknown topic " + topic)
+			continue
+		}
+		if required, ok := wsTopicRoles[topic]; ok && req.Type == "subscribe" && !c.role.allows(required) {
+			c.enqueue(wsEvent{msg: wsMessage{Type: "error", Error: &apiError{Code: errCodeForbidden, Message: "Permission denied for topic " + topic}}})
+			continue
+		}
+		topics = append(topics, topic)
+	}
+
+	added, current := c.setTopics(topics, req.Type == "subscribe")
+	c.enqueue(wsEvent{msg: wsMessage{Type: "subscribed", Data: current}})
+	for _, topic := range added {
+		if snapshot := wsTopics[topic]; snapshot != nil {
+			for _, ev := range snapshot(ctx) {
+				c.enqueue(ev)
+			}
+		}
+	}
+}
+
+func (c *wsClient) sendError(message string) {
+	c.enqueue(wsEvent{msg: wsMessage{Type: "error", Error: &apiError{Code: errCodeBadRequest, Message: message}}})
+}
+
+func systemSnapshot(ctx context.Context) []wsEvent {
+	if info, ok := sysInfoHub.snapshot(); ok {
+		return []wsEvent{newTopicEvent(topicSystem, topicSystem, info)}
+	}
+	return nil
+}
+
+func networkSnapshot(ctx context.Context) []wsEvent {
+	if state, ok := getNetworkState(); ok {
+		return []wsEvent{newTopicEvent(topicNetwork, topicNetwork, state)}
+	}
+	return nil
+}
+
+func upgradeSnapshot(ctx context.Context) []wsEvent {
+	return []wsEvent{newTopicEvent(topicUpgrade, topicUpgrade, currentUpgradeProgress())}
+}
+
+func servicesSnapshot(ctx context.Context) []wsEvent {
+	var events []wsEvent
+	for _, s := range listServices(ctx) {
+		events = append(events, newTopicEvent(topicServices, topicServices+"/"+s.Name, s))
+	}
+	return events
+}
+
+func ledsSnapshot(ctx context.Context) []wsEvent {
+	ev, err := currentLedEvent()
+	if err != nil {
+		return nil
+	}
+	return []wsEvent{newTopicEvent(topicLeds, topicLeds, ev)}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 注册一个不连接网络的客户端，测试结束后移除
+func addTestWSClient(t *testing.T, topics ...string) *wsClient {
+	t.Helper()
+	c := newWSClient(false)
+	c.setTopics(topics, true)
+	wsClientsLock.Lock()
+	wsClients[c] = struct{}{}
+	wsClientsLock.Unlock()
+	t.Cleanup(func() {
+		wsClientsLock.Lock()
+		delete(wsClients, c)
+		wsClientsLock.Unlock()
+	})
+	return c
+}
+
+func TestWSClientQueue(t *testing.T) {
+	c := newWSClient(false)
+	c.setTopics([]string{topicSystem, topicLogs}, true)
+
+	// 未订阅的主题不入队，同一 key 的状态事件只保留最新的一条
+	c.enqueue(newTopicEvent(topicUpgrade, topicUpgrade, 1))
+	c.enqueue(newTopicEvent(topicSystem, topicSystem, 1))
+	c.enqueue(newTopicEvent(topicLogs, "", "a"))
+	c.enqueue(newTopicEvent(topicSystem, topicSystem, 2))
+	c.enqueue(wsEvent{msg: wsMessage{Type: "pong"}})
+	want := []wsMessage{
+		{Type: "event", Topic: topicSystem, Data: 2},
+		{Type: "event", Topic: topicLogs, Data: "a"},
+		{Type: "pong"},
+	}
+	if got := c.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("take() = %+v", got)
+	}
+	if got := c.take(); len(got) != 0 {
+		t.Errorf("再次 take() = %+v", got)
+	}
+
+	// 队列满后丢弃并在下次发送时通知
+	for i := 0; i < wsMaxQueue+3; i++ {
+		c.enqueue(newTopicEvent(topicLogs, "", i))
+	}
+	got := c.take()
+	if len(got) != wsMaxQueue+1 || got[0] != (wsMessage{Type: "dropped", Topic: topicLogs, Count: 3}) {
+		t.Errorf("队列满时 take() 返回 %d 条，第一条 %+v", len(got), got[0])
+	}
+}
+
+func TestWSClientTopics(t *testing.T) {
+	c := newWSClient(false)
+	added, current := c.setTopics([]string{topicSystem, topicLeds}, true)
+	if !reflect.DeepEqual(added, []string{topicSystem, topicLeds}) || !reflect.DeepEqual(current, []string{topicLeds, topicSystem}) {
+		t.Errorf("subscribe: added=%v current=%v", added, current)
+	}
+	added, current = c.setTopics([]string{topicSystem, topicUpgrade}, true)
+	if !reflect.DeepEqual(added, []string{topicUpgrade}) || len(current) != 3 {
+		t.Errorf("重复订阅: added=%v current=%v", added, current)
+	}
+	added, current = c.setTopics([]string{topicSystem, topicLeds, topicUpgrade}, false)
+	if added != nil || !reflect.DeepEqual(current, []string{}) {
+		t.Errorf("unsubscribe: added=%v current=%v", added, current)
+	}
+}
@@ -1,21 +1,37 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func initWifiMgr() {
-	handleAuthRoute("/ap-status", apStatusHandler)
-	handleAuthRoute("/toggle-ap", toggleAPHandler)
-	handleAuthRoute("/scan", handleWLANScan)
-	handleAuthRoute("/connect", handleConnectWLAN)
+// 连接无线网络，表单提交
+type WifiConnectRequest struct {
+	SSID     string `json:"ssid"`
+	Password string `json:"password"`
+}
+
+type WifiConnectResponse struct {
+	Status string `json:"status"` // success 或 fail
+	Output string `json:"output"`
+}
+
+type WifiScanResponse struct {
+	Networks []WifiNetwork `json:"networks"`
+}
+
+type APStatus struct {
+	APRunning bool `json:"apRunning"`
+}
+
+type ToggleAPResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
 }
 
 func parseWifiOutput(output string) []WifiNetwork {
@@ -95,20 +111,17 @@ func parseFlags(flagsStr string) []string {
 
 func handleConnectWLAN(w http.ResponseWriter, r *http.Request) {
 	result := "fail"
-	fmt.Println(r.Body)
 	ssid := r.FormValue("ssid")
-	password := r.FormValue("password")
-	// cmd := exec.Command("nmcli", "device", "wifi", "connect", ssid, "password", password)
-	cmd := exec.Command("ls", "-ls")
-	output, err := cmd.CombinedOutput()
-	log.Printf("Connecting to %s: passwd :%s %s", ssid, password, output)
+	// cmd := exec.Command("nmcli", "device", "wifi", "connect", ssid, "password", r.FormValue("password"))
+	output, err := getRunner().CombinedOutput(r.Context(), "ls", "-ls")
+	log.Printf("Connecting to %s: %s", ssid, output)
 	if err != nil {
 		result = "fail"
 	} else {
 		result = "success"
 	}
 
-	fmt.Fprintf(w, `{"status":"%s","output":"%s"}`, result, "ok")
+	respondData(w, r, http.StatusOK, WifiConnectResponse{Status: result, Output: "ok"})
 }
 
 /*
@@ -183,44 +196,37 @@ func extractPSK(output string) string {
 }
 */
 
+// 发起扫描后等待扫描完成的时间
+var wifiScanWait = 2 * time.Second
+
 func handleWLANScan(w http.ResponseWriter, r *http.Request) {
 	// 执行扫描命令
 	// fmt.Println("start scan handle")
-	cmd := exec.Command("wpa_cli", "-i", "wlan0", "scan")
-	if err := cmd.Run(); err != nil {
-		fmt.Println(err)
-		http.Error(w, fmt.Sprintf("Scan init failed: %v", err), http.StatusInternalServerError)
+	if _, err := getRunner().Output(r.Context(), "wpa_cli", "-i", getConfig().WlanIface, "scan"); err != nil {
+		log.Println(err)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, fmt.Sprintf("Scan init failed: %v", err))
 		return
 	}
 
 	// 等待扫描完成
-	time.Sleep(2 * time.Second)
+	time.Sleep(wifiScanWait)
 
 	// 获取扫描结果
-	cmd = exec.Command("wpa_cli", "-i", "wlan0", "scan_result")
-	output, err := cmd.CombinedOutput()
+	output, err := getRunner().CombinedOutput(r.Context(), "wpa_cli", "-i", getConfig().WlanIface, "scan_result")
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, fmt.Sprintf("Scan results failed: %v", err), http.StatusInternalServerError)
+		log.Println(err)
+		respondError(w, r, http.StatusInternalServerError, errCodeCommandFailed, fmt.Sprintf("Scan results failed: %v", err))
 		return
 	}
 
 	// 解析结果
 	networks := parseWifiOutput(string(output))
-	jsonString, err := json.Marshal(map[string][]WifiNetwork{
-		"networks": networks,
-	})
-	if err != nil {
-		log.Println(err)
-	}
-
-	w.Write(jsonString)
+	respondData(w, r, http.StatusOK, WifiScanResponse{Networks: networks})
 }
 
 // 检查hostapd状态
-func isHostapdRunning() bool {
-	cmd := exec.Command("systemctl", "is-active", "hostapd")
-	output, err := cmd.Output()
+func isHostapdRunning(ctx context.Context) bool {
+	output, err := getRunner().Output(ctx, "systemctl", "is-active", "hostapd")
 	if err != nil {
 		return false
 	}
@@ -229,40 +235,31 @@ func isHostapdRunning() bool {
 
 // AP状态端点
 func apStatusHandler(w http.ResponseWriter, r *http.Request) {
-	response := map[string]bool{
-		"apRunning": isHostapdRunning(),
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respondData(w, r, http.StatusOK, APStatus{APRunning: isHostapdRunning(r.Context())})
 }
 
 // 切换AP端点
 func toggleAPHandler(w http.ResponseWriter, r *http.Request) {
-	isRunning := isHostapdRunning()
+	isRunning := isHostapdRunning(r.Context())
 	action := "stop"
 	if !isRunning {
 		action = "start"
 	}
 
+	ctx := context.Background()
 	if action == "start" {
-		exec.Command("ifconfig", "wlan1", "up")
+		getRunner().Output(ctx, "ifconfig", getConfig().ApIface, "up")
 	} else {
-		exec.Command("ifconfig", "wlan1", "down")
+		getRunner().Output(ctx, "ifconfig", getConfig().ApIface, "down")
 	}
-	cmd := exec.Command("sudo", "systemctl", action, "hostapd")
-	if err := cmd.Run(); err != nil {
-		response := map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+	if _, err := getRunner().Output(ctx, "sudo", "systemctl", action, "hostapd"); err != nil {
+		respondErrorJSON(w, r, http.StatusInternalServerError, errCodeCommandFailed, err.Error(),
+			map[string]interface{}{"success": false})
 		return
 	}
 
-	response := map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("热点已%s", map[string]string{"start": "开启", "stop": "关闭"}[action]),
-	}
-	json.NewEncoder(w).Encode(response)
+	respondData(w, r, http.StatusOK, ToggleAPResponse{
+		Success: true,
+		Message: fmt.Sprintf("热点已%s", map[string]string{"start": "开启", "stop": "关闭"}[action]),
+	})
 }
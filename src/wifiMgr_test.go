@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// wpa_cli scan_result 的输出，字段之间为 TAB
+const wpaScanResult = "bssid / frequency / signal level / flags / ssid\n" +
+	"aa:bb:cc:dd:ee:01\t2437\t-45\t[WPA2-PSK-CCMP][ESS]\tHome WiFi\n" +
+	"aa:bb:cc:dd:ee:02\t5180\t-70\t[ESS]\tGuest\n" +
+	"aa:bb:cc:dd:ee:03\t2412\t-80\t[WPA2-PSK-CCMP][WPS][ESS]\n" +
+	"aa:bb:cc:dd:ee:04\tbad\t-60\t[ESS]\tBroken\n" +
+	"short line\n" +
+	"\n"
+
+func TestParseWifiOutput(t *testing.T) {
+	want := []WifiNetwork{
+		{BSSID: "aa:bb:cc:dd:ee:01", Frequency: 2437, Signal: -45, Flags: []string{"WPA2-PSK-CCMP", "ESS"}, SSID: "Home WiFi"},
+		{BSSID: "aa:bb:cc:dd:ee:02", Frequency: 5180, Signal: -70, Flags: []string{"ESS"}, SSID: "Guest"},
+		{BSSID: "aa:bb:cc:dd:ee:03", Frequency: 2412, Signal: -80, Flags: []string{"WPA2-PSK-CCMP", "WPS", "ESS"}, SSID: ""},
+	}
+	got := parseWifiOutput(wpaScanResult)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWifiOutput =\n  %+v\n期望\n  %+v", got, want)
+	}
+
+	if got := parseWifiOutput(""); len(got) != 0 {
+		t.Errorf("空输出应返回空列表，得到 %+v", got)
+	}
+}
+
+func TestHandleWLANScan(t *testing.T) {
+	wait := wifiScanWait
+	wifiScanWait = 0
+	t.Cleanup(func() { wifiScanWait = wait })
+
+	iface := getConfig().WlanIface
+	f := newFakeRunner(t).
+		on("wpa_cli -i "+iface+" scan", "OK\n", nil).
+		on("wpa_cli -i "+iface+" scan_result", wpaScanResult, nil)
+
+	w := callHandler(handleWLANScan, http.MethodGet, "/scan", false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d，响应: %s", w.Code, w.Body.String())
+	}
+	var resp WifiScanResponse
+	decodeBody(t, w, &resp)
+	if len(resp.Networks) != 3 || resp.Networks[0].SSID != "Home WiFi" {
+		t.Errorf("扫描结果不正确: %+v", resp.Networks)
+	}
+	assertCommands(t, f, "wpa_cli -i "+iface+" scan", "wpa_cli -i "+iface+" scan_result")
+}
+
+func TestHandleWLANScanFailure(t *testing.T) {
+	iface := getConfig().WlanIface
+	f := newFakeRunner(t).on("wpa_cli -i "+iface+" scan", "", errExitStatus1)
+
+	w := callHandler(handleWLANScan, http.MethodGet, "/scan", true)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("状态码 %d，期望 500", w.Code)
+	}
+	assertCommands(t, f, "wpa_cli -i "+iface+" scan")
+}
@@ -0,0 +1,107 @@
+// compress 为静态文件生成 gzip 和 brotli 预压缩版本（.gz / .br），
+// 压缩后体积没有明显减小的文件会被跳过。
+//
+// 用法：go run ./tools/compress <目录>
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// 需要压缩的文件类型，图片等已压缩的格式不处理
+var compressibleExts = map[string]bool{
+	".html": true,
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".svg":  true,
+	".ico":  true,
+	".txt":  true,
+}
+
+const (
+	minSize  = 512 // 小于该大小的文件不压缩
+	maxRatio = 0.9 // 压缩后需小于原大小的 90%
+)
+
+type encoder struct {
+	ext string
+	new func(w io.Writer) io.WriteCloser
+}
+
+var encoders = []encoder{
+	{".gz", func(w io.Writer) io.WriteCloser {
+		zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return zw
+	}},
+	{".br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.BestCompression)
+	}},
+}
+
+func compress(data []byte, enc encoder) ([]byte, error) {
+	var buf bytes.Buffer
+	w := enc.new(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: compress <dir>")
+		os.Exit(2)
+	}
+	root := os.Args[1]
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !compressibleExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, enc := range encoders {
+			out := path + enc.ext
+			if len(data) < minSize {
+				os.Remove(out)
+				continue
+			}
+			compressed, err := compress(data, enc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if float64(len(compressed)) > float64(len(data))*maxRatio {
+				os.Remove(out)
+				continue
+			}
+			if err := os.WriteFile(out, compressed, 0644); err != nil {
+				return err
+			}
+			log.Printf("%s: %d -> %d bytes", out, len(data), len(compressed))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}